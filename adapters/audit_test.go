@@ -0,0 +1,92 @@
+package adapters
+
+import (
+	"github.com/soffa-projects/go-micro/handlers"
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type auditedAccount struct {
+	Id       string `gorm:"primaryKey"`
+	Name     string
+	Password string
+}
+
+func TestAuditLog(t *testing.T) {
+	ds := NewGormAdapter("file:audit?mode=memory&cache=shared", "audit")
+	defer ds.Close()
+	_, err := ds.Raw(micro.Query{Raw: "create table audited_accounts (id text primary key, name text, password text)"})
+	assert.Nil(t, err)
+	_, err = ds.Raw(micro.Query{Raw: `create table audit_log (id text primary key, entity text, entity_id text, action text,
+		tenant text, actor text, ip_address text, changes text, created_at datetime)`})
+	assert.Nil(t, err)
+
+	env := &micro.Env{DataSources: map[string]micro.DataSource{"audit": ds}, Audit: micro.NewAuditLog("")}
+	ctx := micro.NewAuthCtx(env, "audit", &micro.Authentication{Authenticated: true, UserId: "u1", IpAddress: "10.0.0.1"})
+	repo := micro.NewRepoImpl[auditedAccount](func(e *auditedAccount) {})
+
+	assert.Nil(t, repo.Create(ctx, &auditedAccount{Id: "a1", Name: "alice", Password: "s3cret"}))
+	account, _ := repo.FindById(ctx, "a1")
+	account.Name = "alice2"
+	assert.Nil(t, repo.Update(ctx, account))
+	assert.Nil(t, repo.Update(ctx, account))
+	assert.Nil(t, repo.Patch(ctx, "a1", map[string]any{"password": "changed"}))
+	assert.Nil(t, repo.DeleteById(ctx, "a1"))
+
+	history, err := env.Audit.History(ctx, "audited_account", "a1", 1, 10)
+	assert.Nil(t, err)
+	assert.Len(t, history, 4)
+	actions := make([]micro.AuditAction, len(history))
+	for i, entry := range history {
+		actions[i] = entry.Action
+		assert.Equal(t, "u1", entry.Actor)
+		assert.Equal(t, "10.0.0.1", entry.IpAddress)
+		assert.Equal(t, "audit", entry.Tenant)
+	}
+	assert.Equal(t, []micro.AuditAction{micro.AuditDelete, micro.AuditUpdate, micro.AuditUpdate, micro.AuditCreate}, actions)
+
+	created := history[3].Changes
+	assert.Equal(t, "alice", created["name"].To)
+	assert.Equal(t, micro.RedactedValue, created["password"].To)
+	updated := history[2].Changes
+	assert.Len(t, updated, 1)
+	assert.Equal(t, "alice", updated["name"].From)
+	assert.Equal(t, "alice2", updated["name"].To)
+	assert.Equal(t, micro.RedactedValue, history[1].Changes["password"].To)
+	assert.Equal(t, "alice2", history[0].Changes["name"].From)
+	assert.Nil(t, history[0].Changes["name"].To)
+
+	page, err := env.Audit.History(ctx, "audited_account", "a1", 2, 3)
+	assert.Nil(t, err)
+	assert.Len(t, page, 1)
+
+	// the tenants sharing the table only see their own changes
+	env.DataSources["other"] = ds
+	other, err := env.Audit.History(micro.NewCtx(env, "other"), "audited_account", "a1", 1, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, other)
+
+	env.DataSources[micro.DefaultTenantId] = ds
+	router := NewEchoAdapter(env, micro.RouterConfig{})
+	handlers.CRUD[auditedAccount, auditedAccount, auditedAccount](router.Group("/accounts"))
+	req := httptest.NewRequest(http.MethodGet, "/accounts/a1/history", nil)
+	req.Header.Set(micro.TenantIdHttpHeader, "audit")
+	rec := httptest.NewRecorder()
+	router.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "alice2")
+	rec = httptest.NewRecorder()
+	router.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/accounts/a1/history", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "alice2")
+
+	// the history route is not registered when the audit is disabled
+	router = NewEchoAdapter(&micro.Env{DataSources: env.DataSources}, micro.RouterConfig{})
+	handlers.CRUD[auditedAccount, auditedAccount, auditedAccount](router.Group("/accounts"))
+	rec = httptest.NewRecorder()
+	router.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/accounts/a1/history", nil))
+	assert.NotEqual(t, http.StatusOK, rec.Code)
+}
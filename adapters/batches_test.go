@@ -0,0 +1,77 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type streamedEvent struct {
+	Id   string `gorm:"primaryKey"`
+	Kind string
+	micro.SoftDelete
+}
+
+func TestFindInBatchesAndIterate(t *testing.T) {
+	ds := NewGormAdapter("file:batches?mode=memory&cache=shared", "batches")
+	defer ds.Close()
+	_, err := ds.Raw(micro.Query{Raw: "create table streamed_events (id text primary key, kind text, deleted_at datetime, deleted_by text)"})
+	assert.Nil(t, err)
+
+	env := &micro.Env{DataSources: map[string]micro.DataSource{"batches": ds}}
+	ctx := micro.NewCtx(env, "batches")
+	repo := micro.NewRepoImpl[streamedEvent](func(e *streamedEvent) {})
+	events := make([]*streamedEvent, 25)
+	for i := range events {
+		events[i] = &streamedEvent{Id: fmt.Sprintf("e%02d", i), Kind: []string{"a", "b"}[i%2]}
+	}
+	assert.Nil(t, repo.CreateAll(ctx, events))
+	assert.Nil(t, repo.DeleteById(ctx, "e00"))
+
+	err = ctx.Tx(func(tx micro.Ctx) error {
+		var sizes []int
+		var last string
+		err := repo.FindInBatches(tx, 10, func(batch []*streamedEvent) error {
+			sizes = append(sizes, len(batch))
+			assert.Greater(t, batch[0].Id, last)
+			last = batch[len(batch)-1].Id
+			return nil
+		})
+		assert.Equal(t, []int{10, 10, 4}, sizes)
+		return err
+	})
+	assert.Nil(t, err)
+
+	// the batches are paged by primary key, another order would skip rows
+	err = repo.NewQuery(ctx).Order("kind").FindInBatches(10, func(batch []*streamedEvent) error {
+		return nil
+	})
+	assert.ErrorContains(t, err, "primary key order")
+
+	count := 0
+	assert.Nil(t, repo.NewQuery(ctx).Where("kind = ?", "b").Iterate(func(item *streamedEvent) error {
+		assert.Equal(t, "b", item.Kind)
+		count++
+		return nil
+	}))
+	assert.Equal(t, 12, count)
+
+	count = 0
+	assert.Nil(t, repo.Iterate(ctx, func(item *streamedEvent) error {
+		count++
+		return nil
+	}))
+	assert.Equal(t, 24, count)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	batches := 0
+	err = repo.FindInBatches(ctx.WithContext(cancelled), 5, func(batch []*streamedEvent) error {
+		batches++
+		cancel()
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, batches)
+}
@@ -3,7 +3,6 @@ package adapters
 import (
 	"fmt"
 	"github.com/go-resty/resty/v2"
-	log "github.com/sirupsen/logrus"
 	"github.com/soffa-projects/go-micro/micro"
 	"github.com/soffa-projects/go-micro/util/h"
 )
@@ -21,12 +20,13 @@ func NewDiscordClient(webhook string) micro.NotificationService {
 	}
 	return &discordClient{
 		webHookUrl: webhook,
-		client:     resty.New(),
+		client:     resty.New().SetTransport(micro.NewTracingTransport(nil)),
 	}
 }
 
-func (s *discordClient) Send(_ micro.Ctx, message micro.Notification) error {
+func (s *discordClient) Send(ctx micro.Ctx, message micro.Notification) error {
 	out, err := s.client.R().
+		SetContext(ctx.Context()).
 		SetBody(h.Map{
 			"content": message.Message,
 		}).
@@ -2,14 +2,16 @@ package adapters
 
 import (
 	"context"
+	gerror "errors"
 	"fmt"
 	"github.com/getsentry/sentry-go"
 	sentryecho "github.com/getsentry/sentry-go/echo"
 	"github.com/go-playground/validator/v10"
-	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	log "github.com/sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"github.com/soffa-projects/go-micro/di"
 	"github.com/soffa-projects/go-micro/micro"
 	"github.com/soffa-projects/go-micro/schema"
 	"github.com/soffa-projects/go-micro/util/digest"
@@ -17,6 +19,9 @@ import (
 	"github.com/soffa-projects/go-micro/util/h"
 	echoSwagger "github.com/swaggo/echo-swagger"
 	"github.com/thoas/go-funk"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
 	"net/url"
@@ -27,6 +32,11 @@ import (
 
 var validate *validator.Validate
 
+// proxyClient forwards the trace context (W3C traceparent) to the upstreams
+var proxyClient = &http.Client{
+	Transport: micro.NewTracingTransport(http.DefaultTransport),
+}
+
 //goland:noinspection GoTypeAssertionOnErrors
 func Bind(c echo.Context, input interface{}) error {
 
@@ -77,6 +87,7 @@ type echoRouterAdapter struct {
 	micro.Router
 	e   *echo.Echo
 	cfg micro.RouterConfig
+	env *micro.Env
 }
 
 func NewEchoAdapter(env *micro.Env, config micro.RouterConfig) micro.Router {
@@ -127,7 +138,7 @@ func NewEchoAdapter(env *micro.Env, config micro.RouterConfig) micro.Router {
 			c.Set(micro.TenantId, tenantId)
 			c.Set(micro.AuthKey, auth)
 
-			log.Infof("tenant = %s", tenantId)
+			defer closeRequestScope(c)
 			return next(c)
 		}
 	})
@@ -135,15 +146,13 @@ func NewEchoAdapter(env *micro.Env, config micro.RouterConfig) micro.Router {
 		Level: 5,
 	}))*/
 	e.Use(middleware.RequestID())
+	if config.Tracing {
+		e.Use(tracingMiddleware())
+	}
 	if config.Cors {
 		e.Use(middleware.CORS())
 	}
-	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
-		Skipper: func(c echo.Context) bool {
-			return strings.Contains(c.Request().URL.Path, ".")
-		},
-		Format: "method=${method}, uri=${uri}, status=${status}\n",
-	}))
+	e.Use(accessLogMiddleware())
 	if config.Production {
 		e.Use(middleware.Recover())
 	}
@@ -183,10 +192,14 @@ func NewEchoAdapter(env *micro.Env, config micro.RouterConfig) micro.Router {
 		spec.Host = host
 		e.GET("/swagger/*", echoSwagger.WrapHandler)
 	}
-	/*if config.Prometheus != nil && config.Prometheus.Enabled {
-		e.F(echoprometheus.NewMiddleware(config.Prometheus.Subsystem)) // adds middleware to gather metrics
-		e.GET("/metrics", echoprometheus.NewHandler()) // adds route to serve gathered metrics
-	}*/
+	if config.Prometheus != nil && config.Prometheus.Enabled && env.Metrics != nil {
+		metricsPath := config.Prometheus.Path
+		if metricsPath == "" {
+			metricsPath = micro.DefaultMetricsPath
+		}
+		e.Use(metricsMiddleware(env.Metrics, metricsPath))
+		e.GET(metricsPath, echo.WrapHandler(promhttp.HandlerFor(env.Metrics.Registry, promhttp.HandlerOpts{})))
+	}
 	if config.RemoveTrailSlash {
 		e.Pre(middleware.RemoveTrailingSlash())
 	}
@@ -196,13 +209,13 @@ func NewEchoAdapter(env *micro.Env, config micro.RouterConfig) micro.Router {
 			return func(c echo.Context) error {
 				auth := c.Get(micro.AuthKey).(*micro.Authentication)
 				if auth.Bearer == "" {
-					log.Infof("no bearer found in request, skipping jwt filter")
+					log.Debugf("no bearer found in request, skipping jwt filter")
 					return next(c)
 				}
 				parts := strings.Split(auth.Bearer, ".")
 				if len(parts) < 3 {
 					// maybe it's a basic auth
-					log.Infof("a non no jwt bearer token found in request, skipping jwt filter")
+					log.Debugf("a non jwt bearer token found in request, skipping jwt filter")
 					return next(c)
 				}
 
@@ -235,21 +248,27 @@ func NewEchoAdapter(env *micro.Env, config micro.RouterConfig) micro.Router {
 					auth.Permissions = strings.Split(value.(string), ",")
 				}
 				if value, ok := h.MapLookup(data, "tenant", "tenant_id", "tenant-id", "tenantId"); ok {
-					log.Infof("tenant found in jwt token: %s", value.(string))
+					log.Debugf("tenant found in jwt token: %s", value.(string))
 					c.Set(micro.TenantId, value.(string))
 				}
 
-				log.Infof("current request is fully authenticated")
+				log.Debugf("current request is fully authenticated")
 				c.Set(micro.AuthKey, auth)
 				return next(c)
 			}
 		})
 	}
 
-	e.GET("/health", func(c echo.Context) error {
-		status := schema.NewHealthStatus()
-		return c.JSON(http.StatusOK, status)
-	})
+	if env.Health == nil {
+		e.GET("/health", func(c echo.Context) error {
+			status := schema.NewHealthStatus()
+			return c.JSON(http.StatusOK, status)
+		})
+	} else {
+		e.GET("/health", healthHandler(env.Health.Check))
+		e.GET("/health/ready", healthHandler(env.Health.Ready))
+		e.GET("/health/live", healthHandler(env.Health.Live))
+	}
 
 	if !config.Production && env.TokenProvider != nil {
 
@@ -278,7 +297,99 @@ func NewEchoAdapter(env *micro.Env, config micro.RouterConfig) micro.Router {
 		log.Infof("DEV token endpoint is available at /dev/token?tenant=<tenant>")
 	}
 
-	return &echoRouterAdapter{e: e, cfg: config}
+	return &echoRouterAdapter{e: e, cfg: config, env: env}
+}
+
+func metricsMiddleware(metrics *micro.Metrics, metricsPath string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			route := c.Path()
+			if route == metricsPath {
+				return next(c)
+			}
+			start := time.Now()
+			err := next(c)
+			status := c.Response().Status
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			} else if err != nil && !c.Response().Committed {
+				status = http.StatusInternalServerError
+			}
+			if route == "" {
+				route = "unmatched"
+			}
+			txMode, _ := c.Get(micro.TxModeKey).(micro.TxMode)
+			metrics.ObserveHttpRequest(c.Request().Method, route, txMode, status, time.Since(start))
+			return err
+		}
+	}
+}
+
+// accessLogMiddleware logs every request at debug level, with the request scoped fields
+func accessLogMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if strings.Contains(c.Request().URL.Path, ".") {
+				return next(c)
+			}
+			start := time.Now()
+			err := next(c)
+			createRouteContext(c).LogWith(log).WithFields(logrus.Fields{
+				"method":   c.Request().Method,
+				"uri":      c.Request().RequestURI,
+				"status":   c.Response().Status,
+				"duration": time.Since(start).String(),
+			}).Debug("request completed")
+			return err
+		}
+	}
+}
+
+func tracingMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			route := c.Path()
+			if route == "" {
+				route = req.URL.Path
+			}
+			ctx := micro.ExtractTraceHeaders(req.Context(), req.Header)
+			ctx, span := micro.Tracer().Start(ctx, req.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(req.Method),
+					semconv.HTTPRoute(route),
+					semconv.URLPath(req.URL.Path),
+					semconv.ClientAddress(c.RealIP()),
+				),
+			)
+			defer span.End()
+			c.SetRequest(req.WithContext(ctx))
+			err := next(c)
+			status := c.Response().Status
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			}
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+			if err != nil {
+				span.RecordError(err)
+			}
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			return err
+		}
+	}
+}
+
+func healthHandler(check func(ctx context.Context) (*schema.HealthStatus, bool)) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		status, ok := check(c.Request().Context())
+		if !ok {
+			return c.JSON(http.StatusServiceUnavailable, status)
+		}
+		return c.JSON(http.StatusOK, status)
+	}
 }
 
 func (r *echoRouterAdapter) Handler() http.Handler {
@@ -289,8 +400,8 @@ func (r *echoRouterAdapter) Start(addr string) error {
 	return r.e.Start(addr)
 }
 
-func (r *echoRouterAdapter) Shutdown() error {
-	return r.e.Shutdown(context.Background())
+func (r *echoRouterAdapter) Shutdown(ctx context.Context) error {
+	return r.e.Shutdown(ctx)
 }
 
 func (r *echoRouterAdapter) GET(path string, handler interface{}, filters ...micro.MiddlewareFunc) {
@@ -324,14 +435,24 @@ func (r *echoRouterAdapter) request(method string, path string, handler interfac
 	}, createMiddlewares(filters)...)
 }
 
+func (r *echoRouterAdapter) Env() *micro.Env {
+	return r.env
+}
+
 func (r *echoRouterAdapter) Group(path string, filters ...micro.MiddlewareFunc) micro.BaseRouter {
 	middlewares := createMiddlewares(filters)
 	return &echoGroupRoute{
-		g: r.e.Group(r.path(path), middlewares...),
+		g:   r.e.Group(r.path(path), middlewares...),
+		env: r.env,
 	}
 }
 
 func (r *echoRouterAdapter) Proxy(path string, upstreams *micro.RouterUpstream, middlewares ...micro.MiddlewareFunc) {
+	if r.env.Health != nil {
+		for id, upstream := range upstreams.All() {
+			r.env.Health.RegisterInformational("upstream:"+id, upstreamHealthCheck(upstream))
+		}
+	}
 	r.e.Any(path, func(c echo.Context) error {
 		uriParts := strings.Split(c.Request().URL.Path, "?")
 		requestUri := uriParts[0]
@@ -357,7 +478,8 @@ func (r *echoRouterAdapter) Proxy(path string, upstreams *micro.RouterUpstream,
 		if requestQuery != "" {
 			chain = strings.Join([]string{chain, requestQuery}, "?")
 		}
-		req, _ := http.NewRequest(
+		req, _ := http.NewRequestWithContext(
+			c.Request().Context(),
 			c.Request().Method,
 			chain,
 			c.Request().Body,
@@ -372,11 +494,14 @@ func (r *echoRouterAdapter) Proxy(path string, upstreams *micro.RouterUpstream,
 			}
 		}
 
-		resp, err := http.DefaultClient.Do(req)
+		start := time.Now()
+		resp, err := proxyClient.Do(req)
 
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+			r.env.Metrics.ObserveUpstream(upstream.Id, http.StatusBadGateway, time.Since(start))
+			return echo.NewHTTPError(http.StatusBadGateway, err.Error())
 		}
+		r.env.Metrics.ObserveUpstream(upstream.Id, resp.StatusCode, time.Since(start))
 		//goland:noinspection ALL
 		defer resp.Body.Close()
 		copyHeader(resp.Header, c.Response().Header())
@@ -409,6 +534,29 @@ func (r *echoRouterAdapter) path(path string) string {
 	return newPath
 }
 
+// upstreamHealthCheck reports an upstream as down when its /health endpoint is not reachable
+func upstreamHealthCheck(upstream *micro.Upstream) micro.HealthCheck {
+	return func(ctx context.Context) error {
+		target, err := url.JoinPath(upstream.Uri, "health")
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := proxyClient.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("upstream %s returned %d", upstream.Id, resp.StatusCode)
+		}
+		return nil
+	}
+}
+
 func copyHeader(src, dst http.Header) {
 	for k, vv := range src {
 		for _, v := range vv {
@@ -425,6 +573,11 @@ type echoGroupRoute struct {
 	micro.BaseRouter
 	g   *echo.Group
 	ctx micro.Ctx
+	env *micro.Env
+}
+
+func (r *echoGroupRoute) Env() *micro.Env {
+	return r.env
 }
 
 func (r *echoGroupRoute) GET(path string, handler interface{}, filters ...micro.MiddlewareFunc) {
@@ -532,58 +685,104 @@ func handleRequest(c echo.Context, handler interface{}) (err error) {
 	} else {
 		log.Debugf("current tenant_id is %s", tenantId)
 	}
+	if err := ctx.Env.Health.TenantUnavailable(tenantId); err != nil {
+		// e.g. the migrations of the tenant failed, the other tenants are still served
+		ctx.LogWith(log).Errorf("tenant %s is unavailable -- %v", tenantId, err)
+		return c.JSON(http.StatusServiceUnavailable, micro.ErrorResponse{
+			Kind:  "error.tenant_unavailable",
+			Error: "tenant unavailable",
+		})
+	}
 
-	disabledTx := c.Get(micro.DisableImplicitTransaction)
-	if disabledTx == "1" {
-		return mapHttpResponse(c, invokeHandler(c, ctx, handler, handlerType, numIn))
+	args, err := bindHandlerArgs(c, ctx, handlerType, numIn)
+	if err != nil {
+		return mapHttpResponse(c, err)
 	}
-	return ctx.Tx(func(tx micro.Ctx) error {
-		return mapHttpResponse(c, invokeHandler(c, ctx, handler, handlerType, numIn))
-	})
+	var result any
+	mode, opts := routeTxMode(c)
+	c.Set(micro.TxModeKey, mode)
+	if mode == micro.TxModeNone {
+		result, err = invokeHandler(ctx, handler, args)
+	} else {
+		// the handler runs in the transaction, which is rolled back when it fails and may
+		// run it again (see micro.TxOptions.Retries): the response is written after the commit
+		err = ctx.Tx(func(tx micro.Ctx) error {
+			tx.BindScope()
+			var txErr error
+			result, txErr = invokeHandler(tx, handler, args)
+			return txErr
+		}, opts)
+	}
+	if err != nil {
+		return mapHttpResponse(c, err)
+	}
+	if result == nil {
+		return nil
+	}
+	return c.JSON(http.StatusOK, result)
 }
 
-func invokeHandler(c echo.Context, tx micro.Ctx, handler interface{}, handlerType reflect.Type, numIn int) error {
-	args := []reflect.Value{reflect.ValueOf(tx)}
+// routeTxMode returns the implicit transaction of the request: the one set on the route or
+// its group (micro.NoTx, micro.ReadOnlyTx, micro.Tx, micro.WithTx), none when the router
+// disables the implicit transactions or for GET and HEAD requests, read-write otherwise
+func routeTxMode(c echo.Context) (micro.TxMode, micro.TxOptions) {
+	opts, _ := c.Get(micro.TxOptionsKey).(micro.TxOptions)
+	if mode, ok := c.Get(micro.TxModeKey).(micro.TxMode); ok {
+		return mode, opts
+	}
+	if c.Get(micro.DisableImplicitTransaction) == "1" {
+		return micro.TxModeNone, opts
+	}
+	switch c.Request().Method {
+	case http.MethodGet, http.MethodHead:
+		return micro.TxModeNone, opts
+	}
+	return micro.TxModeReadWrite, opts
+}
 
+// bindHandlerArgs binds the input of the handler (its second argument), once for all the
+// attempts of the transaction since the request body can only be read once
+func bindHandlerArgs(c echo.Context, ctx micro.Ctx, handlerType reflect.Type, numIn int) ([]reflect.Value, error) {
+	var args []reflect.Value
 	if numIn == 2 {
 		inputType := handlerType.In(1)
 		inputValue := reflect.New(inputType).Elem()
 		modelInput := inputValue.Addr().Interface() //
 		if err := Bind(c, modelInput); err != nil {
-			log.Errorf("validation failed for %s\n%v", c.Request().RequestURI, err.Error())
-			return err
+			ctx.LogWith(log).Warnf("validation failed for %s -- %v", c.Request().RequestURI, err.Error())
+			return nil, err
 		}
 		args = append(args, inputValue)
 	}
+	return args, nil
+}
 
+func invokeHandler(ctx micro.Ctx, handler interface{}, args []reflect.Value) (any, error) {
 	handlerValue := reflect.ValueOf(handler)
 
-	res := handlerValue.Call(args)
+	res := handlerValue.Call(append([]reflect.Value{reflect.ValueOf(ctx)}, args...))
 
 	if len(res) > 2 {
-		return fmt.Errorf("invalid handler return type")
+		return nil, fmt.Errorf("invalid handler return type")
 	}
 
 	var result interface{}
 
 	for _, r := range res {
 		if err, ok := r.Interface().(error); ok {
-			return err
+			return nil, err
 		} else if result == nil {
 			result = r.Interface()
 		}
 	}
-	if result == nil {
-		return nil
-	}
-	return c.JSON(http.StatusOK, result)
+	return result, nil
 }
 
 func mapHttpResponse(c echo.Context, err error) error {
 	if err == nil {
 		return nil
 	}
-	log.Errorf("error while handling request %s -- %v", c.Request().RequestURI, err.Error())
+	createRouteContext(c).LogWith(log).Errorf("error while handling request %s -- %v", c.Request().RequestURI, err.Error())
 
 	switch e := err.(type) {
 	case *errors.FunctionalError:
@@ -625,18 +824,10 @@ func mapHttpResponse(c echo.Context, err error) error {
 	case *echo.HTTPError:
 		return c.JSON(e.Code, e.Message)
 
-	case *pgconn.PgError:
-		if e.Code == "23505" {
-			return c.JSON(http.StatusConflict, micro.ErrorResponse{
-				Kind:  "db_error",
-				Error: e.Message,
-			})
-		}
-		return c.JSON(http.StatusInternalServerError, micro.ErrorResponse{
-			Kind:  "db_error",
-			Error: e.Message,
-		})
 	default:
+		if status, response, ok := dbErrorResponse(err); ok {
+			return c.JSON(status, response)
+		}
 		return c.JSON(http.StatusInternalServerError, micro.ErrorResponse{
 			Kind:  "unknown_error",
 			Error: err.Error(),
@@ -645,6 +836,58 @@ func mapHttpResponse(c echo.Context, err error) error {
 
 }
 
+// dbErrorResponse returns the response of a database error, the driver message is only
+// logged since it may reveal the schema or the values of other rows
+func dbErrorResponse(err error) (int, micro.ErrorResponse, bool) {
+	var unique *micro.UniqueViolation
+	var foreignKey *micro.ForeignKeyViolation
+	var notNull *micro.NotNullViolation
+	var check *micro.CheckViolation
+	switch {
+	case gerror.As(err, &unique):
+		var details any
+		if len(unique.Columns) > 0 {
+			details = map[string]any{"columns": unique.Columns}
+		}
+		return http.StatusConflict, micro.ErrorResponse{
+			Kind:    "error.unique_violation",
+			Error:   "resource already exists",
+			Details: details,
+		}, true
+	case gerror.As(err, &foreignKey):
+		return http.StatusConflict, micro.ErrorResponse{
+			Kind:  "error.foreign_key_violation",
+			Error: "related resource is missing or still referenced",
+		}, true
+	case gerror.As(err, &notNull):
+		var details any
+		if notNull.Column != "" {
+			details = map[string]any{"column": notNull.Column}
+		}
+		return http.StatusBadRequest, micro.ErrorResponse{
+			Kind:    "error.not_null_violation",
+			Error:   "missing required value",
+			Details: details,
+		}, true
+	case gerror.As(err, &check):
+		return http.StatusBadRequest, micro.ErrorResponse{
+			Kind:  "error.check_violation",
+			Error: "invalid value",
+		}, true
+	case micro.IsRetryable(err):
+		return http.StatusServiceUnavailable, micro.ErrorResponse{
+			Kind:  "error.transaction_conflict",
+			Error: "concurrent update, please retry",
+		}, true
+	case isDriverError(err):
+		return http.StatusInternalServerError, micro.ErrorResponse{
+			Kind:  "db_error",
+			Error: "database error",
+		}, true
+	}
+	return 0, micro.ErrorResponse{}, false
+}
+
 // =================================================================================
 // INIT
 // =================================================================================
@@ -662,6 +905,15 @@ func createMiddlewares(filters []micro.MiddlewareFunc) []echo.MiddlewareFunc {
 	}).([]echo.MiddlewareFunc)
 }
 
+// closeRequestScope stops the request components created while handling the request
+func closeRequestScope(c echo.Context) {
+	if scope, ok := c.Get(micro.ScopeKey).(*di.Scope); ok {
+		if err := scope.Close(c.Request().Context()); err != nil {
+			log.Errorf("unable to close request scope: %s", err)
+		}
+	}
+}
+
 func createRouteContext(c echo.Context) micro.Ctx {
 	env := c.Get(micro.EnvKey).(*micro.Env)
 	value := c.Get(micro.AuthKey)
@@ -1,8 +1,9 @@
 package adapters
 
 import (
+	"context"
+	"fmt"
 	"github.com/go-co-op/gocron"
-	log "github.com/sirupsen/logrus"
 	"github.com/soffa-projects/go-micro/micro"
 	"time"
 )
@@ -34,23 +35,40 @@ func (s *GoCronSchedulingAdapter) StartAsync() {
 	s.internal.StartAsync()
 }
 
+func (s *GoCronSchedulingAdapter) Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		// waits for the running jobs
+		s.internal.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (s *GoCronSchedulingAdapter) Every(interval string, handler micro.SchedulerHandler) {
-	s.schedule(interval, 0, handler)
+	s.schedule("every "+interval, interval, 0, handler)
 }
 
 func (s *GoCronSchedulingAdapter) Once(handler micro.SchedulerHandler) {
-	s.schedule("5s", 1, handler)
+	s.schedule("once", "5s", 1, handler)
 }
 
 func (s *GoCronSchedulingAdapter) EveryTenant(interval string, handler micro.SchedulerHandler) {
-	s.schedule(interval, 0, handler, s.tenantLoader.GetTenant()...)
+	s.schedule("every tenant "+interval, interval, 0, handler, s.tenantLoader.GetTenant()...)
 }
 
 func (s *GoCronSchedulingAdapter) OncePerTenant(handler micro.SchedulerHandler) {
-	s.schedule("5s", 1, handler, s.tenantLoader.GetTenant()...)
+	s.schedule("once per tenant", "5s", 1, handler, s.tenantLoader.GetTenant()...)
 }
 
-func (s *GoCronSchedulingAdapter) schedule(interval string, limit int, handler func(ctx micro.Ctx) error, tenants ...string) {
+// schedule registers the handler, the job name labels the logs and the metrics of the runs
+// and is derived from the schedule the caller registered.
+func (s *GoCronSchedulingAdapter) schedule(jobName string, interval string, limit int, handler func(ctx micro.Ctx) error, tenants ...string) {
 	sched, err := s.internal.Every(interval).Do(func() error {
 		defer func() {
 			if err := recover(); err != nil {
@@ -58,17 +76,17 @@ func (s *GoCronSchedulingAdapter) schedule(interval string, limit int, handler f
 			}
 		}()
 		if tenants == nil || len(tenants) == 0 {
-			err := handler(micro.NewCtx(s.env, micro.DefaultTenantId))
+			err := s.run(jobName, micro.DefaultTenantId, handler)
 			if err != nil {
-				log.Error(err)
+				log.WithField("job", jobName).Error(err)
 			}
 			return err
 
 		} else {
 			for _, tenantId := range tenants {
-				err := handler(micro.NewCtx(s.env, tenantId))
+				err := s.run(jobName, tenantId, handler)
 				if err != nil {
-					log.Error(err)
+					log.WithField("job", jobName).WithField("tenant", tenantId).Error(err)
 				}
 			}
 			return nil
@@ -83,3 +101,14 @@ func (s *GoCronSchedulingAdapter) schedule(interval string, limit int, handler f
 		s.empty = false
 	}
 }
+
+func (s *GoCronSchedulingAdapter) run(jobName string, tenantId string, handler micro.SchedulerHandler) (err error) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job %s panicked: %v", jobName, r)
+		}
+		s.env.Metrics.ObserveJob(jobName, tenantId, time.Since(start), err)
+	}()
+	return handler(micro.NewCtx(s.env, tenantId))
+}
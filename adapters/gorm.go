@@ -1,15 +1,17 @@
 package adapters
 
 import (
-  _ "github.com/jackc/pgx/v5"
+  "context"
+  "database/sql"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/jackc/pgx/v5/stdlib"
   "github.com/onrik/gorm-logrus"
-  "github.com/pressly/goose/v3"
-  log "github.com/sirupsen/logrus"
   "github.com/soffa-projects/go-micro/micro"
   "gorm.io/driver/postgres"
-  "gorm.io/driver/sqlite"
   "gorm.io/gorm"
-  "io/fs"
+  "gorm.io/gorm/clause"
+  neturl "net/url"
   "strings"
 )
 
@@ -19,17 +21,35 @@ type adapter struct {
 	internal *gorm.DB
 	tenantId string
 	url      string
+	// replicas is nil in a transaction
+	replicas *replicaSet
+	// hooks are the AfterCommit and AfterRollback hooks of a transaction
+	hooks *txHooks
 	//config  *micro.DataSourceCfg
 }
 
 func (a adapter) IsPostgres() bool {
-	return strings.HasPrefix(a.url, "postgres")
+	return a.Dialect() == micro.DialectPostgres
+}
+
+func (a adapter) Dialect() string {
+	return a.internal.Dialector.Name()
 }
 
 func (a adapter) Tenant() string {
 	return a.tenantId
 }
 
+func (a adapter) WithContext(ctx context.Context) micro.DataSource {
+	return &adapter{
+		internal: a.internal.WithContext(ctx),
+		tenantId: a.tenantId,
+		url:      a.url,
+		replicas: a.replicas,
+		hooks:    a.hooks,
+	}
+}
+
 func (a adapter) Create(model interface{}) error {
 	if model, ok := model.(micro.EntityHooks); ok {
 		if err := model.PreCreate(); err != nil {
@@ -44,36 +64,42 @@ func (a adapter) Save(model interface{}) error {
 }
 
 func (a adapter) Exists(model any, q micro.Query) (bool, error) {
-	var count int64
-	res := a.buildQuery(model, q).Count(&count)
-	return count > 0, res.Error
+	count, err := a.Count(model, q)
+	return count > 0, err
 }
 
 func (a adapter) Find(target any, q micro.Query) error {
-	res := a.buildQuery(target, q).Find(target)
-	return res.Error
+	return a.read(func(db *gorm.DB) error {
+		return buildQuery(db, target, q).Find(target).Error
+	})
 }
 
 func (a adapter) FindAll(target any) error {
-	res := a.buildQuery(target, micro.Query{}).Find(target)
-	return res.Error
+	return a.Find(target, micro.Query{})
 }
 
 func (a adapter) First(model any, q micro.Query) error {
-	res := a.buildQuery(model, q).First(model)
-	if res.Error == gorm.ErrRecordNotFound {
+	var rows int64
+	err := a.read(func(db *gorm.DB) error {
+		res := buildQuery(db, model, q).First(model)
+		rows = res.RowsAffected
+		return res.Error
+	})
+	if err == gorm.ErrRecordNotFound {
 		return micro.ErrRecordNotFound
 	}
-	if res.RowsAffected == 0 {
+	if rows == 0 {
 		return micro.ErrRecordNotFound
 	}
-	return res.Error
+	return err
 }
 
 func (a adapter) Count(model any, q micro.Query) (int64, error) {
 	var count int64
-	res := a.buildQuery(model, q).Count(&count)
-	return count, res.Error
+	err := a.read(func(db *gorm.DB) error {
+		return buildQuery(db, model, q).Count(&count).Error
+	})
+	return count, err
 }
 
 func (a adapter) Delete(model any, q micro.Query) (int64, error) {
@@ -92,25 +118,57 @@ func (a adapter) Raw(q micro.Query) (int64, error) {
 }
 
 func (a adapter) buildQuery(model any, q micro.Query) *gorm.DB {
+	return buildQuery(a.internal, model, q)
+}
+
+func buildQuery(db *gorm.DB, model any, q micro.Query) *gorm.DB {
 	var builder *gorm.DB
 	if q.Model != nil {
-		builder = a.internal.Model(q.Model)
+		builder = db.Model(q.Model)
 	} else {
-		builder = a.internal.Model(model)
+		builder = db.Model(model)
+	}
+	if q.Unscoped {
+		builder = builder.Unscoped()
 	}
 
 	if q.Raw != "" {
 		builder = builder.Raw(strings.TrimSpace(q.Raw), q.Args...)
 	} else {
+		for _, join := range q.Joins {
+			builder = builder.Joins(join.Expr, join.Args...)
+		}
 		if q.W != "" {
 			builder = builder.Where(strings.TrimSpace(q.W), q.Args...)
 		}
+		for _, cond := range q.Conditions {
+			if cond.Or {
+				builder = builder.Or(strings.TrimSpace(cond.Expr), cond.Args...)
+			} else {
+				builder = builder.Where(strings.TrimSpace(cond.Expr), cond.Args...)
+			}
+		}
+		if q.Group != "" {
+			builder = builder.Group(q.Group)
+		}
+		for _, having := range q.Having {
+			builder = builder.Having(having.Expr, having.Args...)
+		}
 		if q.Sort != "" {
 			builder = builder.Order(q.Sort)
 		}
 		if q.Select != "" {
 			builder = builder.Select(q.Select)
 		}
+		for _, association := range q.Preload {
+			builder = builder.Preload(association)
+		}
+		if q.Offset > 0 {
+			builder = builder.Offset(int(q.Offset))
+		}
+		if q.Limit > 0 {
+			builder = builder.Limit(int(q.Limit))
+		}
 	}
 
 	return builder
@@ -121,6 +179,58 @@ func (a adapter) Patch(model interface{}, id string, data map[string]interface{}
 	return res.RowsAffected, res.Error
 }
 
+func (a adapter) CreateInBatches(target any, batchSize int) error {
+	return a.internal.CreateInBatches(target, batchSize).Error
+}
+
+func (a adapter) Upsert(target any, opts micro.UpsertOptions) (int64, error) {
+	onConflict := clause.OnConflict{DoNothing: opts.DoNothing}
+	for _, column := range opts.Conflict {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: column})
+	}
+	if !opts.DoNothing {
+		if len(opts.Update) > 0 {
+			onConflict.DoUpdates = clause.AssignmentColumns(opts.Update)
+		} else {
+			onConflict.UpdateAll = true
+		}
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = micro.DefaultBatchSize
+	}
+	res := a.internal.Clauses(onConflict).CreateInBatches(target, batchSize)
+	return res.RowsAffected, res.Error
+}
+
+func (a adapter) FindInBatches(target any, q micro.Query, batchSize int, fn func(batch int) error) error {
+	if q.Sort != "" {
+		// the batches are paged with pk > last, another order would skip rows
+		return fmt.Errorf("FindInBatches returns the rows in primary key order, it cannot be sorted by %s", q.Sort)
+	}
+	return a.buildQuery(target, q).FindInBatches(target, batchSize, func(tx *gorm.DB, batch int) error {
+		return fn(batch)
+	}).Error
+}
+
+func (a adapter) Iterate(model any, q micro.Query) (micro.RowIterator, error) {
+	builder := a.buildQuery(model, q)
+	rows, err := builder.Rows()
+	if err != nil {
+		return nil, err
+	}
+	return &rowIterator{Rows: rows, db: builder}, nil
+}
+
+type rowIterator struct {
+	*sql.Rows
+	db *gorm.DB
+}
+
+func (it *rowIterator) Scan(dest any) error {
+	return it.db.ScanRows(it.Rows, dest)
+}
+
 func (a adapter) Ping() error {
 	return a.internal.Exec("SELECT 1").Error
 }
@@ -134,18 +244,96 @@ func (a adapter) NewTx() micro.DataSource {
 	}
 }
 
-func (a adapter) Transaction(cb func(tx micro.DataSource) error) error {
-	return a.internal.Transaction(func(tx *gorm.DB) error {
+func (a adapter) Transaction(cb func(tx micro.DataSource) error, opts ...micro.TxOptions) (err error) {
+	txOptions := sqlTxOptions(a.Dialect(), opts)
+	readOnly := false
+	for _, opt := range opts {
+		readOnly = readOnly || opt.ReadOnly
+	}
+	hooks := &txHooks{}
+	defer func() {
+		recovered := recover()
+		failure := err
+		if recovered != nil {
+			// an error raised with a panic (e.g. h.RaiseAny) may be retried too
+			failure, _ = recovered.(error)
+		}
+		switch {
+		case recovered != nil || err != nil:
+			// the rollback of an attempt retried by micro.Ctx.Tx is not the final outcome
+			if a.hooks != nil || !micro.IsRetryable(failure) || !micro.DeferRollbackHooks(a.internal.Statement.Context, hooks.afterRollback) {
+				hooks.afterRollback()
+			}
+		case a.hooks != nil:
+			// the hooks of a savepoint run when the outcome of the outer transaction is known
+			a.hooks.merge(hooks)
+		default:
+			hooks.afterCommit()
+		}
+		if recovered != nil {
+			panic(recovered)
+		}
+	}()
+	err = a.internal.Transaction(func(tx *gorm.DB) error {
+		if readOnly && a.Dialect() == micro.DialectSQLite {
+			// SQLite ignores the read-only transactions, query_only rejects the writes until
+			// the connection goes back to the pool
+			if err := tx.Exec("PRAGMA query_only = ON").Error; err != nil {
+				return err
+			}
+			defer tx.Exec("PRAGMA query_only = OFF")
+		}
 		return cb(&adapter{
 			internal: tx,
 			url:      a.url,
 			tenantId: a.tenantId,
+			hooks:    hooks,
 		})
-	})
+	}, txOptions...)
+	// the commit may fail with a serialization failure
+	return classifyError(err)
+}
+
+// sqlTxOptions returns the options of a transaction on dialect, the driver of SQL Server
+// rejects the read-only transactions: they run as read-write transactions
+func sqlTxOptions(dialect string, opts []micro.TxOptions) []*sql.TxOptions {
+	var txOptions []*sql.TxOptions
+	for _, opt := range opts {
+		readOnly := opt.ReadOnly && dialect != micro.DialectSQLServer
+		if opt.Isolation != sql.LevelDefault || readOnly {
+			txOptions = append(txOptions, &sql.TxOptions{Isolation: opt.Isolation, ReadOnly: readOnly})
+		}
+	}
+	return txOptions
+}
+
+func (a adapter) AfterCommit(fn func()) {
+	if a.hooks == nil {
+		fn()
+		return
+	}
+	a.hooks.add(fn, nil)
+}
+
+func (a adapter) AfterRollback(fn func()) {
+	if a.hooks != nil {
+		a.hooks.add(nil, fn)
+	}
 }
 
 func (a adapter) Close() {
-	sqlDB, err := a.internal.DB()
+	if a.replicas != nil {
+		a.replicas.close()
+	}
+	closeLink(a.internal)
+}
+
+func closeLink(db *gorm.DB) {
+	if pool, ok := db.ConnPool.(*tenantPool); ok {
+		pool.pool.release()
+		return
+	}
+	sqlDB, err := db.DB()
 	if err != nil {
 		log.Printf("unable to close database: %s", err)
 	} else {
@@ -153,61 +341,186 @@ func (a adapter) Close() {
 	}
 }
 
-func (a adapter) Migrate(fs fs.FS, location string, migrationsTable string) {
-	goose.SetBaseFS(fs)
-	goose.SetTableName(migrationsTable)
-	if err := goose.SetDialect(a.internal.Dialector.Name()); err != nil {
-		log.Fatalf("unable to set dialect: %s", err)
+func NewGormAdapter(url string, schema string) micro.DataSource {
+	return NewGormDataSource(micro.DataSourceCfg{
+		Url:    url,
+		Tenant: schema,
+	})
+}
+
+func NewGormDataSource(cfg micro.DataSourceCfg) micro.DataSource {
+	if err := createTenantDatabase(cfg); err != nil {
+		log.Fatalf("unable to create the tenant database: %s", err)
 	}
-	cnx, err := a.internal.DB()
-	dir := location
-	if err = goose.Up(cnx, dir, goose.WithAllowMissing()); err != nil {
-		if err.Error() == "no migration files found" {
-			log.Warnf("no migration files found in %s", dir)
-		} else {
-			log.Fatal(err)
+	db := openLink(cfg, cfg.Url, cfg.UrlSource)
+	if isPostgresUrl(cfg.Url) && tenantSchema(cfg) != "" {
+		if err := db.Exec("create schema if not exists " + quoteSchema(cfg.Tenant)).Error; err != nil {
+			log.Fatalf("unable to connect to database: %s", err)
 		}
 	}
-}
-
-func NewGormAdapter(url string, schema string) micro.DataSource {
-	db := createLink(url, schema)
 	return &adapter{
 		internal: db,
-		tenantId: schema,
-		url:      url,
+		tenantId: cfg.Tenant,
+		url:      cfg.Url,
+		replicas: newReplicaSet(cfg),
 	}
 }
 
-func createLink(url string, dbschema string) *gorm.DB {
+// openLink opens a connection to url (the primary or a replica) with the callbacks of the datasource
+func openLink(cfg micro.DataSourceCfg, url string, urlSource func() string) *gorm.DB {
+	return configureLink(cfg, createLink(cfg, url, urlSource))
+}
+
+// configureLink registers the callbacks of the datasource (tenancy, metrics, conventions, ...)
+func configureLink(cfg micro.DataSourceCfg, db *gorm.DB) *gorm.DB {
+	if cfg.Tenancy == micro.TenancyRow {
+		if err := registerTenantScope(db, cfg.Tenant); err != nil {
+			log.Fatalf("unable to register tenant scope: %s", err)
+		}
+	}
+	if err := registerMetricsCallbacks(db, cfg.Metrics, cfg.Tenant); err != nil {
+		log.Fatalf("unable to register database metrics: %s", err)
+	}
+	if err := registerConventionCallbacks(db); err != nil {
+		log.Fatalf("unable to register entity conventions: %s", err)
+	}
+	if err := registerErrorCallbacks(db); err != nil {
+		log.Fatalf("unable to register database errors: %s", err)
+	}
+	if cfg.Tracing {
+		if err := registerTracingCallbacks(db, cfg.Tenant); err != nil {
+			log.Fatalf("unable to register database tracing: %s", err)
+		}
+	}
+	return db
+}
+
+func createLink(cfg micro.DataSourceCfg, url string, urlSource func() string) *gorm.DB {
 	var dialector gorm.Dialector
-	supportSchema := false
-	tenantUrl := strings.ReplaceAll(url, "__tenant__", dbschema)
-	if strings.HasPrefix(url, "postgres") || strings.HasPrefix(tenantUrl, "pg") || strings.HasPrefix(tenantUrl, "postgresql") {
-		tenantUrl = strings.ReplaceAll(tenantUrl, "pg:", "postgres:")
-		tenantUrl = strings.ReplaceAll(tenantUrl, "postgresql:", "postgres:")
-		if dbschema != "" && dbschema != "public" {
-			tenantUrl += "?search_path=" + dbschema
+	dialect := dialectOf(url)
+	dbschema := tenantSchema(cfg)
+	tenantUrl := tenantDsn(url, dbschema)
+	if dialect == "" {
+		log.Fatalf("unsupported database type: %s", url)
+	} else if cfg.Tenancy == micro.TenancyRow {
+		pool := acquireSharedPool(tenantUrl, func() *sql.DB {
+			return openPool(dialect, tenantUrl, "", urlSource)
+		})
+		rowLevelSecurity := cfg.RowLevelSecurity && dialect == micro.DialectPostgres
+		dialector = connDialector(dialect, newRowTenantPool(pool, cfg.Tenant, rowLevelSecurity))
+	} else if dialect == micro.DialectPostgres && cfg.SharedPool {
+		serverUrl := tenantDsn(url, "")
+		pool := acquireSharedPool(serverUrl, func() *sql.DB {
+			return openPool(dialect, serverUrl, "", urlSource)
+		})
+		dialector = connDialector(dialect, newTenantPool(pool, dbschema))
+	} else if dialect == micro.DialectPostgres && urlSource != nil {
+		dialector = postgres.New(postgres.Config{Conn: openRotatingPostgres(tenantUrl, dbschema, urlSource)})
+	} else if dialect != micro.DialectSQLite && urlSource != nil {
+		conn, err := openRotating(driverName(dialect), func() string {
+			return tenantDsn(urlSource(), dbschema)
+		})
+		if err != nil {
+			log.Fatalf("invalid database url: %s", err)
 		}
-		dialector = postgres.Open(tenantUrl)
-		supportSchema = true
-	} else if strings.HasPrefix(tenantUrl, "file:") || strings.HasSuffix(tenantUrl, ".db") {
-		dialector = sqlite.Open(tenantUrl)
+		dialector = connDialector(dialect, conn)
 	} else {
-		log.Fatalf("unsupported database type: %s", tenantUrl)
+		dialector = openDialector(dialect, tenantUrl)
 	}
 
+	return openGorm(cfg, dialector)
+}
+
+func openGorm(cfg micro.DataSourceCfg, dialector gorm.Dialector) *gorm.DB {
 	gdb, err := gorm.Open(dialector, &gorm.Config{
 		Logger: gorm_logrus.New(),
 	})
 
-	if err == nil && supportSchema && dbschema != "" {
-		err = gdb.Exec("create schema if not exists  " + dbschema).Error
-	}
-
 	if err != nil {
 		log.Fatalf("unable to connect to database: %s", err)
 	}
+	if sqlDB, err := gdb.DB(); err == nil {
+		configurePool(sqlDB, cfg.Pool)
+	}
 
 	return gdb
 }
+
+// tenantSchema is the postgres schema of the datasource, only the schema tenancy uses one
+func tenantSchema(cfg micro.DataSourceCfg) string {
+	if cfg.Tenancy == "" || cfg.Tenancy == micro.TenancySchema {
+		return cfg.Tenant
+	}
+	return ""
+}
+
+// quoteSchema quotes the schema name so that it keeps its case, the same identifier is
+// used to create the schema and in the search_path of the connections
+func quoteSchema(schema string) string {
+	return pgx.Identifier{schema}.Sanitize()
+}
+
+// openPool opens a database/sql pool, used when the pool is shared by several gorm.DB.
+// url is the connection string of dbschema, the urls returned by urlSource are not.
+func openPool(dialect string, url string, dbschema string, urlSource func() string) *sql.DB {
+	if urlSource != nil && dialect == micro.DialectPostgres {
+		return openRotatingPostgres(url, dbschema, urlSource)
+	}
+	var db *sql.DB
+	var err error
+	if urlSource != nil && dialect != micro.DialectSQLite {
+		db, err = openRotating(driverName(dialect), func() string {
+			return tenantDsn(urlSource(), dbschema)
+		})
+	} else {
+		db, err = sql.Open(driverName(dialect), url)
+	}
+	if err != nil {
+		log.Fatalf("invalid database url: %s", err)
+	}
+	return db
+}
+
+func isPostgresUrl(url string) bool {
+	return strings.HasPrefix(url, "postgres") || strings.HasPrefix(url, "pg") || strings.HasPrefix(url, "postgresql")
+}
+
+// tenantDsn returns the connection string of a tenant: the postgres url with the search_path
+// of the schema, the database of the schema on MySQL and SQL Server (see tenantDatabase)
+func tenantDsn(url string, dbschema string) string {
+	tenantUrl := strings.ReplaceAll(url, micro.TenantUrlPlaceholder, dbschema)
+	var err error
+	switch dialectOf(url) {
+	case micro.DialectPostgres:
+		tenantUrl = strings.ReplaceAll(tenantUrl, "pg:", "postgres:")
+		tenantUrl = strings.ReplaceAll(tenantUrl, "postgresql:", "postgres:")
+		if dbschema != "" && dbschema != "public" {
+			tenantUrl += "?search_path=" + neturl.QueryEscape(quoteSchema(dbschema))
+		}
+	case micro.DialectMySQL:
+		tenantUrl, err = mysqlDsn(tenantUrl, tenantDatabase(micro.DialectMySQL, dbschema))
+	case micro.DialectSQLServer:
+		tenantUrl, err = sqlserverDsn(tenantUrl, tenantDatabase(micro.DialectSQLServer, dbschema))
+	}
+	if err != nil {
+		log.Fatalf("invalid database url: %s", err)
+	}
+	return tenantUrl
+}
+
+// openRotatingPostgres opens a pool whose new connections always use the latest url
+// returned by urlSource, the connections already opened are kept.
+func openRotatingPostgres(tenantUrl string, dbschema string, urlSource func() string) *sql.DB {
+	config, err := pgx.ParseConfig(tenantUrl)
+	if err != nil {
+		log.Fatalf("invalid database url: %s", err)
+	}
+	return stdlib.OpenDB(*config, stdlib.OptionBeforeConnect(func(ctx context.Context, cc *pgx.ConnConfig) error {
+		latest, err := pgx.ParseConfig(tenantDsn(urlSource(), dbschema))
+		if err != nil {
+			return err
+		}
+		cc.Config = latest.Config
+		return nil
+	}))
+}
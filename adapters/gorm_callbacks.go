@@ -0,0 +1,39 @@
+package adapters
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+)
+
+// gormOperation is a gorm operation and the registration of the callbacks run around it
+type gormOperation struct {
+	name   string
+	before func(name string, fn func(*gorm.DB)) error
+	after  func(name string, fn func(*gorm.DB)) error
+}
+
+func gormOperations(db *gorm.DB) []gormOperation {
+	cb := db.Callback()
+	return []gormOperation{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+}
+
+// registerAroundOperations registers the callbacks returned by before and after around every
+// gorm operation, as micro:<prefix>_before_<operation> and micro:<prefix>_after_<operation>
+func registerAroundOperations(db *gorm.DB, prefix string, before func(operation string) func(tx *gorm.DB), after func(operation string) func(tx *gorm.DB)) error {
+	for _, operation := range gormOperations(db) {
+		if err := operation.before(fmt.Sprintf("micro:%s_before_%s", prefix, operation.name), before(operation.name)); err != nil {
+			return err
+		}
+		if err := operation.after(fmt.Sprintf("micro:%s_after_%s", prefix, operation.name), after(operation.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
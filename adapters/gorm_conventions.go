@@ -0,0 +1,209 @@
+package adapters
+
+import (
+	"github.com/soffa-projects/go-micro/micro"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sync"
+)
+
+const versionCheckKey = "micro:version_check"
+
+type entityConventions struct {
+	audit      bool
+	softDelete bool
+	versioned  bool
+}
+
+var conventionsCache sync.Map
+
+// conventionsOf returns the conventions (micro.Audit, micro.SoftDelete, micro.Versioned)
+// embedded by the model of a statement
+func conventionsOf(s *schema.Schema) entityConventions {
+	if s == nil {
+		return entityConventions{}
+	}
+	if cached, ok := conventionsCache.Load(s.ModelType); ok {
+		return cached.(entityConventions)
+	}
+	model := reflect.New(s.ModelType).Interface()
+	_, audit := model.(micro.AuditedEntity)
+	_, softDelete := model.(micro.SoftDeletedEntity)
+	_, versioned := model.(micro.VersionedEntity)
+	result := entityConventions{audit: audit, softDelete: softDelete, versioned: versioned}
+	conventionsCache.Store(s.ModelType, result)
+	return result
+}
+
+// registerConventionCallbacks fills the audit columns, turns deletes into soft deletes and
+// enforces optimistic locking for the entities embedding the micro conventions
+func registerConventionCallbacks(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("micro:conventions_create", beforeCreateConventions); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("micro:conventions_query", softDeleteScope); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("micro:conventions_row", softDeleteScope); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("micro:conventions_update", beforeUpdateConventions); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register("micro:conventions_version", checkVersion); err != nil {
+		return err
+	}
+	return cb.Delete().Before("gorm:delete").Register("micro:conventions_delete", softDeleteConvention)
+}
+
+func beforeCreateConventions(tx *gorm.DB) {
+	stmt := tx.Statement
+	conventions := conventionsOf(stmt.Schema)
+	if !conventions.audit && !conventions.versioned {
+		return
+	}
+	actor := micro.ActorFromContext(stmt.Context)
+	forEachRecord(stmt.ReflectValue, func(record reflect.Value) {
+		if conventions.audit && actor != "" {
+			setIfZero(stmt, record, "CreatedBy", actor)
+			setIfZero(stmt, record, "UpdatedBy", actor)
+		}
+		if conventions.versioned {
+			setIfZero(stmt, record, "Version", int64(1))
+		}
+	})
+}
+
+func softDeleteScope(tx *gorm.DB) {
+	stmt := tx.Statement
+	if stmt.SQL.Len() > 0 || stmt.Unscoped || !conventionsOf(stmt.Schema).softDelete {
+		return
+	}
+	gorm.SoftDeleteQueryClause{Field: stmt.Schema.LookUpField("DeletedAt")}.ModifyStatement(stmt)
+}
+
+func beforeUpdateConventions(tx *gorm.DB) {
+	stmt := tx.Statement
+	if stmt.SQL.Len() > 0 {
+		return
+	}
+	conventions := conventionsOf(stmt.Schema)
+	if values, ok := stmt.Dest.(map[string]interface{}); ok && (conventions.audit || conventions.versioned) {
+		// Patch: the columns are set on a copy, the map of the caller may be reused
+		copied := make(map[string]interface{}, len(values)+2)
+		for key, value := range values {
+			copied[key] = value
+		}
+		stmt.Dest = copied
+	}
+	softDeleteScope(tx)
+	if conventions.audit {
+		field := stmt.Schema.LookUpField("UpdatedBy")
+		if actor := micro.ActorFromContext(stmt.Context); actor != "" && field != nil {
+			stmt.SetColumn(field.DBName, actor, true)
+		}
+	}
+	if conventions.versioned {
+		lockVersion(tx)
+	}
+}
+
+// lockVersion restricts the update to the loaded version and increments it
+func lockVersion(tx *gorm.DB) {
+	stmt := tx.Statement
+	field := stmt.Schema.LookUpField("Version")
+	column := clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		// Patch: the version is checked when the caller gives it
+		for _, key := range []string{field.DBName, field.Name} {
+			if expected, ok := dest[key]; ok {
+				delete(dest, key)
+				stmt.AddClause(clause.Where{Exprs: []clause.Expression{clause.Eq{Column: column, Value: expected}}})
+				tx.InstanceSet(versionCheckKey, nil)
+				break
+			}
+		}
+		dest[field.DBName] = gorm.Expr("? + 1", clause.Column{Name: field.DBName})
+	default:
+		if stmt.ReflectValue.Kind() != reflect.Struct {
+			return
+		}
+		current, _ := field.ValueOf(stmt.Context, stmt.ReflectValue)
+		version, _ := current.(int64)
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{clause.Eq{Column: column, Value: version}}})
+		stmt.SetColumn(field.DBName, version+1, true)
+		tx.InstanceSet(versionCheckKey, version)
+	}
+}
+
+func checkVersion(tx *gorm.DB) {
+	previous, ok := tx.InstanceGet(versionCheckKey)
+	if !ok || tx.Error != nil || tx.RowsAffected > 0 {
+		return
+	}
+	stmt := tx.Statement
+	if version, ok := previous.(int64); ok && stmt.ReflectValue.Kind() == reflect.Struct {
+		_ = stmt.Schema.LookUpField("Version").Set(stmt.Context, stmt.ReflectValue, version)
+	}
+	_ = tx.AddError(micro.ErrStaleEntity)
+}
+
+// softDeleteConvention replaces the DELETE statement by an UPDATE of deleted_at (and deleted_by)
+func softDeleteConvention(tx *gorm.DB) {
+	stmt := tx.Statement
+	if stmt.SQL.Len() > 0 || stmt.Unscoped || !conventionsOf(stmt.Schema).softDelete {
+		return
+	}
+	deletedAt := stmt.Schema.LookUpField("DeletedAt")
+	now := tx.NowFunc()
+	set := clause.Set{{Column: clause.Column{Name: deletedAt.DBName}, Value: now}}
+	stmt.SetColumn(deletedAt.DBName, now, true)
+	if actor := micro.ActorFromContext(stmt.Context); actor != "" {
+		if deletedBy := stmt.Schema.LookUpField("DeletedBy"); deletedBy != nil {
+			set = append(set, clause.Assignment{Column: clause.Column{Name: deletedBy.DBName}, Value: actor})
+			stmt.SetColumn(deletedBy.DBName, actor, true)
+		}
+	}
+	stmt.AddClause(set)
+
+	// same primary key conditions as gorm:delete
+	_, queryValues := schema.GetIdentityFieldValuesMap(stmt.Context, stmt.ReflectValue, stmt.Schema.PrimaryFields)
+	column, values := schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, queryValues)
+	if len(values) > 0 {
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{clause.IN{Column: column, Values: values}}})
+	}
+	if _, ok := stmt.Clauses["WHERE"]; !ok && !stmt.AllowGlobalUpdate {
+		_ = tx.AddError(gorm.ErrMissingWhereClause)
+		return
+	}
+
+	gorm.SoftDeleteQueryClause{Field: deletedAt}.ModifyStatement(stmt)
+	stmt.AddClauseIfNotExists(clause.Update{})
+	stmt.Build(tx.Callback().Update().Clauses...)
+}
+
+func forEachRecord(value reflect.Value, fn func(record reflect.Value)) {
+	value = reflect.Indirect(value)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			fn(reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		fn(value)
+	}
+}
+
+func setIfZero(stmt *gorm.Statement, record reflect.Value, name string, value any) {
+	field := stmt.Schema.LookUpField(name)
+	if field == nil || !record.CanAddr() {
+		return
+	}
+	if _, zero := field.ValueOf(stmt.Context, record); zero {
+		_ = field.Set(stmt.Context, record, value)
+	}
+}
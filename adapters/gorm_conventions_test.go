@@ -0,0 +1,92 @@
+package adapters
+
+import (
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type conventionDoc struct {
+	Id   string `gorm:"primaryKey"`
+	Name string
+	micro.Audit
+	micro.SoftDelete
+	micro.Versioned
+}
+
+// createdOnlyDoc has the audit convention but maps its own columns, without an updater
+type createdOnlyDoc struct {
+	Id          string `gorm:"primaryKey"`
+	Name        string
+	micro.Audit `gorm:"-"`
+	CreatedBy   string
+}
+
+func TestEntityConventions(t *testing.T) {
+	ds := NewGormAdapter("file:conventions?mode=memory&cache=shared", "conventions")
+	defer ds.Close()
+	_, err := ds.Raw(micro.Query{Raw: `create table convention_docs (
+		id text primary key, name text, created_at datetime, updated_at datetime, created_by text,
+		updated_by text, deleted_at datetime, deleted_by text, version integer)`})
+	assert.Nil(t, err)
+
+	env := &micro.Env{DataSources: map[string]micro.DataSource{"conventions": ds}}
+	ctx := micro.NewAuthCtx(env, "conventions", &micro.Authentication{Authenticated: true, UserId: "u1"})
+	repo := micro.NewRepoImpl[conventionDoc](func(e *conventionDoc) {})
+
+	doc := &conventionDoc{Id: "d1", Name: "a"}
+	assert.Nil(t, repo.Create(ctx, doc))
+	assert.Equal(t, "u1", doc.CreatedBy)
+	assert.Equal(t, int64(1), doc.Version)
+
+	loaded, err := repo.FindById(ctx, "d1")
+	assert.Nil(t, err)
+	stale := *loaded
+	loaded.Name = "b"
+	assert.Nil(t, repo.Update(ctx, loaded))
+	assert.Equal(t, int64(2), loaded.Version)
+
+	stale.Name = "c"
+	assert.Equal(t, micro.ErrStaleEntity, repo.Update(ctx, &stale))
+	assert.Equal(t, int64(1), stale.Version)
+
+	patch := map[string]interface{}{"name": "p", "version": int64(2)}
+	updated, err := ctx.CurrentDB().Patch(&conventionDoc{}, "d1", patch)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), updated)
+	assert.Equal(t, map[string]interface{}{"name": "p", "version": int64(2)}, patch)
+	_, err = ctx.CurrentDB().Patch(&conventionDoc{}, "d1", patch)
+	assert.Equal(t, micro.ErrStaleEntity, err, "the version was incremented by the first patch")
+	loaded, _ = repo.FindById(ctx, "d1")
+	assert.Equal(t, int64(3), loaded.Version)
+
+	assert.Nil(t, repo.DeleteById(ctx, "d1"))
+	deleted, err := repo.FindById(ctx, "d1")
+	assert.Nil(t, err)
+	assert.Nil(t, deleted)
+	count, _ := repo.CountAll(ctx)
+	assert.Equal(t, int64(0), count)
+
+	var all []conventionDoc
+	assert.Nil(t, ctx.CurrentDB().Find(&all, micro.Query{Unscoped: true}))
+	assert.Len(t, all, 1)
+	assert.NotNil(t, all[0].DeletedAt)
+	assert.Equal(t, "u1", *all[0].DeletedBy)
+	assert.Equal(t, "p", all[0].Name)
+
+	_, err = ds.Raw(micro.Query{Raw: `create table created_only_docs (
+		id text primary key, name text, created_by text)`})
+	assert.Nil(t, err)
+	createdOnly := micro.NewRepoImpl[createdOnlyDoc](func(e *createdOnlyDoc) {})
+	created := &createdOnlyDoc{Id: "c1", Name: "a"}
+	assert.Nil(t, createdOnly.Create(ctx, created))
+	assert.Equal(t, "u1", created.CreatedBy)
+	created.Name = "b"
+	assert.NotPanics(t, func() {
+		assert.Nil(t, createdOnly.Update(ctx, created))
+	})
+	created, err = createdOnly.FindById(ctx, "c1")
+	assert.Nil(t, err)
+	assert.Equal(t, "b", created.Name)
+	assert.Equal(t, "u1", created.CreatedBy)
+}
@@ -0,0 +1,27 @@
+package adapters
+
+import (
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDialects(t *testing.T) {
+	assert.Equal(t, micro.DialectPostgres, dialectOf("postgresql://u:p@localhost/app"))
+	assert.Equal(t, micro.DialectMySQL, dialectOf("mariadb://u:p@localhost/app"))
+	assert.Equal(t, micro.DialectSQLServer, dialectOf("mssql://u:p@localhost?database=app"))
+	assert.Equal(t, micro.DialectSQLite, dialectOf("file:app?mode=memory"))
+	assert.Equal(t, "", dialectOf("oracle://localhost"))
+
+	assert.Equal(t, "u:p@tcp(localhost:3306)/app?parseTime=true", tenantDsn("mysql://u:p@localhost/app", micro.DefaultTenantId))
+	assert.Equal(t, "u:p@tcp(db:3307)/acme?parseTime=true", tenantDsn("mysql://u:p@db:3307/app", "acme"))
+	assert.Equal(t, "u:p@tcp(db:3306)/acme", tenantDsn("mysql://u:p@db/__tenant__?parseTime=false", "acme"))
+	assert.Equal(t, "sqlserver://u:p@db:1433?database=acme", tenantDsn("mssql://u:p@db:1433?database=app", "acme"))
+	assert.Equal(t, "postgres://u:p@db/app?search_path=%22acme%22", tenantDsn("pg://u:p@db/app", "acme"))
+	assert.Equal(t, "", tenantDatabase(micro.DialectPostgres, "acme"))
+
+	ds := NewGormAdapter("file:dialects?mode=memory&cache=shared", "dialects")
+	defer ds.Close()
+	assert.Equal(t, micro.DialectSQLite, ds.Dialect())
+	assert.False(t, ds.IsPostgres())
+}
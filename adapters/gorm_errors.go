@@ -0,0 +1,205 @@
+package adapters
+
+import (
+	"errors"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/soffa-projects/go-micro/micro"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// registerErrorCallbacks translates the driver errors of every statement (see classifyError)
+func registerErrorCallbacks(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().After("*").Register("micro:errors_create", translateError); err != nil {
+		return err
+	}
+	if err := cb.Query().After("*").Register("micro:errors_query", translateError); err != nil {
+		return err
+	}
+	if err := cb.Update().After("*").Register("micro:errors_update", translateError); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("*").Register("micro:errors_delete", translateError); err != nil {
+		return err
+	}
+	if err := cb.Row().After("*").Register("micro:errors_row", translateError); err != nil {
+		return err
+	}
+	return cb.Raw().After("*").Register("micro:errors_raw", translateError)
+}
+
+func translateError(tx *gorm.DB) {
+	if tx.Error != nil {
+		tx.Error = classifyError(tx.Error)
+	}
+}
+
+// classifyError returns the micro error (micro.UniqueViolation, micro.Deadlock, ...) of a
+// driver error, err unchanged when it has no equivalent
+func classifyError(err error) error {
+	if err == nil || isClassified(err) {
+		return err
+	}
+	var pgErr *pgconn.PgError
+	var mysqlErr *mysqldriver.MySQLError
+	var mssqlErr mssql.Error
+	var sqliteErr sqlite3.Error
+	switch {
+	case errors.As(err, &pgErr):
+		return classifyPostgresError(pgErr, err)
+	case errors.As(err, &mysqlErr):
+		return classifyMySQLError(mysqlErr, err)
+	case errors.As(err, &mssqlErr):
+		return classifySQLServerError(mssqlErr, err)
+	case errors.As(err, &sqliteErr):
+		return classifySQLiteError(sqliteErr, err)
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return &micro.UniqueViolation{Err: err}
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return &micro.ForeignKeyViolation{Err: err}
+	}
+	return err
+}
+
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+func classifyPostgresError(e *pgconn.PgError, err error) error {
+	switch e.Code {
+	case "23505":
+		// Key (email, tenant_id)=(a@b.io, t1) already exists.
+		return &micro.UniqueViolation{Constraint: e.ConstraintName, Columns: splitColumns(between(e.Detail, "Key (", ")=")), Err: err}
+	case "23503":
+		return &micro.ForeignKeyViolation{Constraint: e.ConstraintName, Err: err}
+	case "23502":
+		return &micro.NotNullViolation{Column: e.ColumnName, Err: err}
+	case "23514":
+		return &micro.CheckViolation{Constraint: e.ConstraintName, Err: err}
+	case "40P01":
+		return &micro.Deadlock{Err: err}
+	case "40001":
+		return &micro.SerializationFailure{Err: err}
+	case "55P03":
+		return &micro.LockTimeout{Err: err}
+	}
+	return err
+}
+
+// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+func classifyMySQLError(e *mysqldriver.MySQLError, err error) error {
+	switch e.Number {
+	case 1062:
+		// Duplicate entry 'a@b.io' for key 'users.idx_email'
+		return &micro.UniqueViolation{Constraint: between(e.Message, "for key '", "'"), Err: err}
+	case 1451, 1452:
+		// Cannot add or update a child row: a foreign key constraint fails (... CONSTRAINT `fk` FOREIGN KEY ...)
+		return &micro.ForeignKeyViolation{Constraint: between(e.Message, "CONSTRAINT `", "`"), Err: err}
+	case 1048, 1364:
+		// Column 'name' cannot be null, Field 'name' doesn't have a default value
+		return &micro.NotNullViolation{Column: between(e.Message, "'", "'"), Err: err}
+	case 3819:
+		// Check constraint 'chk_amount' is violated.
+		return &micro.CheckViolation{Constraint: between(e.Message, "'", "'"), Err: err}
+	case 1213:
+		return &micro.Deadlock{Err: err}
+	case 1205:
+		return &micro.LockTimeout{Err: err}
+	}
+	return err
+}
+
+// https://learn.microsoft.com/en-us/sql/relational-databases/errors-events/database-engine-events-and-errors
+func classifySQLServerError(e mssql.Error, err error) error {
+	switch e.Number {
+	case 2627:
+		// Violation of UNIQUE KEY constraint 'uq_email'. Cannot insert duplicate key in object 'dbo.users'. ...
+		return &micro.UniqueViolation{Constraint: between(e.Message, "'", "'"), Err: err}
+	case 2601:
+		// Cannot insert duplicate key row in object 'dbo.users' with unique index 'ix_email'. ...
+		return &micro.UniqueViolation{Constraint: between(e.Message, "unique index '", "'"), Err: err}
+	case 547:
+		// The INSERT statement conflicted with the FOREIGN KEY constraint "fk_owner". ...
+		constraint := between(e.Message, "constraint \"", "\"")
+		if strings.Contains(e.Message, "CHECK constraint") {
+			return &micro.CheckViolation{Constraint: constraint, Err: err}
+		}
+		return &micro.ForeignKeyViolation{Constraint: constraint, Err: err}
+	case 515:
+		// Cannot insert the value NULL into column 'name', table 'app.dbo.users'; ...
+		return &micro.NotNullViolation{Column: between(e.Message, "column '", "'"), Err: err}
+	case 1205:
+		return &micro.Deadlock{Err: err}
+	case 3960:
+		return &micro.SerializationFailure{Err: err}
+	case 1222:
+		return &micro.LockTimeout{Err: err}
+	}
+	return err
+}
+
+func classifySQLiteError(e sqlite3.Error, err error) error {
+	// UNIQUE constraint failed: users.email, users.tenant_id
+	detail := strings.TrimSpace(between(e.Error(), "failed:", ""))
+	switch e.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		var columns []string
+		for _, column := range splitColumns(detail) {
+			columns = append(columns, column[strings.LastIndex(column, ".")+1:])
+		}
+		return &micro.UniqueViolation{Columns: columns, Err: err}
+	case sqlite3.ErrConstraintForeignKey:
+		return &micro.ForeignKeyViolation{Err: err}
+	case sqlite3.ErrConstraintNotNull:
+		return &micro.NotNullViolation{Column: detail[strings.LastIndex(detail, ".")+1:], Err: err}
+	case sqlite3.ErrConstraintCheck:
+		return &micro.CheckViolation{Constraint: detail, Err: err}
+	}
+	return err
+}
+
+func isClassified(err error) bool {
+	var unique *micro.UniqueViolation
+	var foreignKey *micro.ForeignKeyViolation
+	var notNull *micro.NotNullViolation
+	var check *micro.CheckViolation
+	return errors.As(err, &unique) || errors.As(err, &foreignKey) || errors.As(err, &notNull) ||
+		errors.As(err, &check) || micro.IsRetryable(err)
+}
+
+// isDriverError returns true when err comes from one of the database drivers
+func isDriverError(err error) bool {
+	var pgErr *pgconn.PgError
+	var mysqlErr *mysqldriver.MySQLError
+	var mssqlErr mssql.Error
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &pgErr) || errors.As(err, &mysqlErr) || errors.As(err, &mssqlErr) || errors.As(err, &sqliteErr)
+}
+
+// between returns the text of s between prefix and suffix (the end of s when suffix is
+// empty), an empty string when prefix is missing
+func between(s string, prefix string, suffix string) string {
+	start := strings.Index(s, prefix)
+	if start < 0 {
+		return ""
+	}
+	s = s[start+len(prefix):]
+	if suffix == "" {
+		return s
+	}
+	if end := strings.Index(s, suffix); end >= 0 {
+		return s[:end]
+	}
+	return ""
+}
+
+func splitColumns(s string) []string {
+	var columns []string
+	for _, column := range strings.Split(s, ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
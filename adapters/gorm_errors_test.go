@@ -0,0 +1,83 @@
+package adapters
+
+import (
+	"errors"
+	"fmt"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+type errorOwner struct {
+	Id string `gorm:"primaryKey"`
+}
+
+type errorAccount struct {
+	Id      string `gorm:"primaryKey"`
+	Email   string
+	Name    string
+	Amount  int
+	OwnerId string
+}
+
+func TestErrorClassification(t *testing.T) {
+	ds := NewGormAdapter("file:errors?mode=memory&cache=shared&_foreign_keys=on", "errors")
+	defer ds.Close()
+	_, err := ds.Raw(micro.Query{Raw: "create table error_owners (id text primary key)"})
+	assert.Nil(t, err)
+	_, err = ds.Raw(micro.Query{Raw: "create table error_accounts (id text primary key, email text unique, name text not null, " +
+		"amount integer check (amount >= 0), owner_id text references error_owners(id))"})
+	assert.Nil(t, err)
+	assert.Nil(t, ds.Create(&errorOwner{Id: "o1"}))
+	assert.Nil(t, ds.Create(&errorAccount{Id: "a1", Email: "a@x.io", Name: "a", OwnerId: "o1"}))
+
+	var unique *micro.UniqueViolation
+	err = ds.Create(&errorAccount{Id: "a2", Email: "a@x.io", Name: "b", OwnerId: "o1"})
+	assert.True(t, errors.As(err, &unique))
+	assert.Equal(t, []string{"email"}, unique.Columns)
+	err = ds.Transaction(func(tx micro.DataSource) error {
+		return tx.Create(&errorAccount{Id: "a1", Email: "b@x.io", Name: "b", OwnerId: "o1"})
+	})
+	assert.True(t, errors.As(err, &unique))
+	assert.Equal(t, []string{"id"}, unique.Columns)
+
+	var notNull *micro.NotNullViolation
+	_, err = ds.Raw(micro.Query{Raw: "insert into error_accounts (id) values ('a3')"})
+	assert.True(t, errors.As(err, &notNull))
+	assert.Equal(t, "name", notNull.Column)
+
+	var check *micro.CheckViolation
+	err = ds.Create(&errorAccount{Id: "a4", Email: "d@x.io", Name: "d", Amount: -1, OwnerId: "o1"})
+	assert.True(t, errors.As(err, &check))
+
+	var foreignKey *micro.ForeignKeyViolation
+	err = ds.Create(&errorAccount{Id: "a5", Email: "e@x.io", Name: "e", OwnerId: "missing"})
+	assert.True(t, errors.As(err, &foreignKey))
+
+	pgUnique := &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key", Detail: "Key (email, tenant_id)=(a@x.io, t1) already exists."}
+	assert.True(t, errors.As(classifyError(fmt.Errorf("saving: %w", pgUnique)), &unique))
+	assert.Equal(t, "users_email_key", unique.Constraint)
+	assert.Equal(t, []string{"email", "tenant_id"}, unique.Columns)
+	assert.True(t, micro.IsRetryable(classifyError(&pgconn.PgError{Code: "40001"})))
+	assert.True(t, micro.IsRetryable(classifyError(&mysqldriver.MySQLError{Number: 1213})))
+	assert.True(t, errors.As(classifyError(&mysqldriver.MySQLError{Number: 1062, Message: "Duplicate entry 'a@x.io' for key 'users.idx_email'"}), &unique))
+	assert.Equal(t, "users.idx_email", unique.Constraint)
+
+	status, response, ok := dbErrorResponse(classifyError(pgUnique))
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "error.unique_violation", response.Kind)
+	assert.NotContains(t, response.Error, "a@x.io")
+	status, _, _ = dbErrorResponse(check)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _, _ = dbErrorResponse(classifyError(&pgconn.PgError{Code: "40P01"}))
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	status, response, _ = dbErrorResponse(&pgconn.PgError{Code: "42P01", Message: "relation \"secrets\" does not exist"})
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, "database error", response.Error)
+	_, _, ok = dbErrorResponse(errors.New("boom"))
+	assert.False(t, ok)
+}
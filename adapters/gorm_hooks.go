@@ -0,0 +1,62 @@
+package adapters
+
+import (
+	"sync"
+)
+
+// txHooks are the functions registered with AfterCommit and AfterRollback in a transaction
+type txHooks struct {
+	mu       sync.Mutex
+	commit   []func()
+	rollback []func()
+}
+
+func (h *txHooks) add(commit func(), rollback func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if commit != nil {
+		h.commit = append(h.commit, commit)
+	}
+	if rollback != nil {
+		h.rollback = append(h.rollback, rollback)
+	}
+}
+
+// merge adds the hooks of a released savepoint
+func (h *txHooks) merge(other *txHooks) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.commit = append(h.commit, other.commit...)
+	h.rollback = append(h.rollback, other.rollback...)
+}
+
+func (h *txHooks) afterCommit() {
+	h.mu.Lock()
+	hooks := h.commit
+	h.mu.Unlock()
+	runHooks("commit", hooks)
+}
+
+func (h *txHooks) afterRollback() {
+	h.mu.Lock()
+	hooks := h.rollback
+	h.mu.Unlock()
+	runHooks("rollback", hooks)
+}
+
+// runHooks runs the hooks in their registration order, a failing hook doesn't prevent the
+// next ones from running
+func runHooks(outcome string, hooks []func()) {
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					log.Errorf("after %s hook failed: %v", outcome, recovered)
+				}
+			}()
+			hook()
+		}()
+	}
+}
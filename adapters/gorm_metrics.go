@@ -0,0 +1,29 @@
+package adapters
+
+import (
+	"github.com/soffa-projects/go-micro/micro"
+	"gorm.io/gorm"
+	"time"
+)
+
+const metricsStartKey = "micro:metrics_start"
+
+// registerMetricsCallbacks records the duration of every gorm operation for the given tenant
+func registerMetricsCallbacks(db *gorm.DB, metrics *micro.Metrics, tenant string) error {
+	if metrics == nil {
+		return nil
+	}
+	before := func(string) func(tx *gorm.DB) {
+		return func(tx *gorm.DB) {
+			tx.InstanceSet(metricsStartKey, time.Now())
+		}
+	}
+	after := func(operation string) func(tx *gorm.DB) {
+		return func(tx *gorm.DB) {
+			if start, ok := tx.InstanceGet(metricsStartKey); ok {
+				metrics.ObserveDbQuery(tenant, operation, time.Since(start.(time.Time)))
+			}
+		}
+	}
+	return registerAroundOperations(db, "metrics", before, after)
+}
@@ -0,0 +1,250 @@
+package adapters
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/database"
+	"github.com/soffa-projects/go-micro/micro"
+	"gorm.io/gorm"
+	"hash/fnv"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+func (a adapter) Migrate(fsys fs.FS, location string, migrationsTable string) error {
+	report, err := a.RunMigrations(context.Background(), micro.MigrationSource{
+		FS:       fsys,
+		Location: location,
+		Table:    migrationsTable,
+	}, micro.MigrationOptions{Command: micro.MigrateUp})
+	for _, step := range report.Steps {
+		log.Infof("[%s] migration applied: %s", a.tenantId, step.Name)
+	}
+	return err
+}
+
+func (a adapter) RunMigrations(ctx context.Context, source micro.MigrationSource, opts micro.MigrationOptions) (micro.MigrationReport, error) {
+	report := micro.MigrationReport{
+		Tenant:   a.tenantId,
+		Location: source.Location,
+		Table:    source.Table,
+		DryRun:   opts.DryRun,
+	}
+	cnx, closeCnx, err := a.migrationDB()
+	if err != nil {
+		return report, err
+	}
+	defer closeCnx()
+	provider, err := a.migrationProvider(cnx, source)
+	if errors.Is(err, goose.ErrNoMigrations) {
+		log.Warnf("no migration files found in %s", source.Location)
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+	// the provider is not closed, Close would close the connection pool of the datasource
+	if opts.DryRun || opts.Command == micro.MigrateStatus {
+		err = planMigrations(ctx, provider, opts, &report)
+	} else {
+		err = runMigrations(ctx, provider, opts, &report)
+	}
+	version, versionErr := provider.GetDBVersion(ctx)
+	report.Version = version
+	if err == nil {
+		err = versionErr
+	}
+	return report, err
+}
+
+// migrationDB returns the connection of the migrations, a dedicated one when the
+// datasource uses a shared pool
+func (a adapter) migrationDB() (*sql.DB, func(), error) {
+	if pool, shared := a.internal.ConnPool.(*tenantPool); shared && pool.schema != "" {
+		// the migrations need a connection bound to the tenant schema
+		cnx, err := sql.Open("pgx", tenantDsn(a.url, pool.schema))
+		if err != nil {
+			return nil, nil, err
+		}
+		return cnx, func() {
+			_ = cnx.Close()
+		}, nil
+	}
+	cnx, err := a.internal.DB()
+	return cnx, func() {}, err
+}
+
+func (a adapter) migrationProvider(cnx *sql.DB, source micro.MigrationSource) (*goose.Provider, error) {
+	store, err := database.NewStore(gooseDialect(a.Dialect()), source.Table)
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := fs.Sub(source.FS, source.Location)
+	if err != nil {
+		return nil, err
+	}
+	options := []goose.ProviderOption{goose.WithStore(store), goose.WithAllowOutofOrder(true)}
+	if len(source.Go) > 0 {
+		migrations := make([]*goose.Migration, len(source.Go))
+		for i, migration := range source.Go {
+			migrations[i] = goose.NewGoMigration(migration.Version,
+				a.goMigrationFunc(source.Env, migration.Up), a.goMigrationFunc(source.Env, migration.Down))
+		}
+		options = append(options, goose.WithGoMigrations(migrations...))
+	}
+	// the replicas of the service and the tenants sharing a database don't migrate the
+	// same table at the same time
+	locker, err := migrationLocker(a.Dialect(), migrationLockId(a.tenantId, source.Table))
+	if err != nil {
+		return nil, err
+	}
+	if locker != nil {
+		options = append(options, goose.WithSessionLocker(locker))
+	}
+	return goose.NewProvider("", cnx, fsys, options...)
+}
+
+// goMigrationFunc runs fn with a Ctx bound to the migration transaction
+func (a adapter) goMigrationFunc(env *micro.Env, fn micro.MigrationFunc) *goose.GoFunc {
+	if fn == nil {
+		return nil
+	}
+	return &goose.GoFunc{RunTx: func(ctx context.Context, tx *sql.Tx) error {
+		db := a.internal.Session(&gorm.Session{NewDB: true, Context: ctx})
+		db.Statement.ConnPool = tx
+		ds := &adapter{internal: db, url: a.url, tenantId: a.tenantId}
+		return fn(micro.NewCtx(env, a.tenantId).WithDB(ds).WithContext(ctx))
+	}}
+}
+
+// migrationLockId is the advisory lock of the migrations of a tenant table
+func migrationLockId(tenant string, table string) int64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(tenant + "/" + table))
+	return int64(hash.Sum64() & 0x7fffffffffffffff)
+}
+
+func runMigrations(ctx context.Context, provider *goose.Provider, opts micro.MigrationOptions, report *micro.MigrationReport) error {
+	var results []*goose.MigrationResult
+	var err error
+	switch opts.Command {
+	case micro.MigrateUp:
+		results, err = provider.Up(ctx)
+	case micro.MigrateDown:
+		var result *goose.MigrationResult
+		if result, err = provider.Down(ctx); result != nil {
+			results = append(results, result)
+		}
+	case micro.MigrateRedo:
+		var result *goose.MigrationResult
+		if result, err = provider.Down(ctx); result != nil {
+			results = append(results, result)
+			if err == nil {
+				if result, err = provider.ApplyVersion(ctx, result.Source.Version, true); result != nil {
+					results = append(results, result)
+				}
+			}
+		}
+	case micro.MigrateTo:
+		var current int64
+		if current, err = provider.GetDBVersion(ctx); err != nil {
+			return err
+		}
+		if opts.Version >= current {
+			results, err = provider.UpTo(ctx, opts.Version)
+		} else {
+			results, err = provider.DownTo(ctx, opts.Version)
+		}
+	default:
+		return fmt.Errorf("unknown migration command: %s", opts.Command)
+	}
+	var partial *goose.PartialError
+	if errors.As(err, &partial) {
+		results = partial.Applied
+	}
+	for _, result := range results {
+		if result.Error == nil {
+			report.Steps = append(report.Steps, migrationStep(result.Source, result.Direction, result.Duration))
+		}
+	}
+	if errors.Is(err, goose.ErrNoNextVersion) {
+		// nothing to roll back
+		return nil
+	}
+	return err
+}
+
+// planMigrations fills the status of the migrations and, in dry-run mode, the steps
+// the command would run
+func planMigrations(ctx context.Context, provider *goose.Provider, opts micro.MigrationOptions, report *micro.MigrationReport) error {
+	status, err := provider.Status(ctx)
+	if err != nil {
+		return err
+	}
+	var applied, pending []*goose.Source
+	for _, migration := range status {
+		if migration.State == goose.StateApplied {
+			applied = append(applied, migration.Source)
+		} else {
+			pending = append(pending, migration.Source)
+		}
+		if opts.Command == micro.MigrateStatus {
+			report.Status = append(report.Status, micro.MigrationState{
+				Version:   migration.Source.Version,
+				Name:      migrationStep(migration.Source, "", 0).Name,
+				Applied:   migration.State == goose.StateApplied,
+				AppliedAt: migration.AppliedAt,
+			})
+		}
+	}
+	// the last applied migration is rolled back first
+	sort.Slice(applied, func(i, j int) bool {
+		return applied[i].Version > applied[j].Version
+	})
+	switch opts.Command {
+	case micro.MigrateUp:
+		for _, source := range pending {
+			report.Steps = append(report.Steps, migrationStep(source, "up", 0))
+		}
+	case micro.MigrateDown, micro.MigrateRedo:
+		if len(applied) > 0 {
+			report.Steps = append(report.Steps, migrationStep(applied[0], "down", 0))
+			if opts.Command == micro.MigrateRedo {
+				report.Steps = append(report.Steps, migrationStep(applied[0], "up", 0))
+			}
+		}
+	case micro.MigrateTo:
+		if len(applied) == 0 || opts.Version >= applied[0].Version {
+			for _, source := range pending {
+				if source.Version <= opts.Version {
+					report.Steps = append(report.Steps, migrationStep(source, "up", 0))
+				}
+			}
+			break
+		}
+		for _, source := range applied {
+			if source.Version > opts.Version {
+				report.Steps = append(report.Steps, migrationStep(source, "down", 0))
+			}
+		}
+	}
+	return nil
+}
+
+func migrationStep(source *goose.Source, direction string, duration time.Duration) micro.MigrationStep {
+	name := filepath.Base(source.Path)
+	if source.Type == goose.TypeGo && source.Path == "" {
+		name = fmt.Sprintf("%d.go", source.Version)
+	}
+	return micro.MigrationStep{
+		Version:   source.Version,
+		Name:      name,
+		Direction: direction,
+		Duration:  duration,
+	}
+}
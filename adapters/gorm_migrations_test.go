@@ -0,0 +1,138 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+var testMigrations = fstest.MapFS{
+	"ok/001_accounts.sql": {Data: []byte(`-- +goose Up
+create table accounts (id text primary key);
+-- +goose Down
+drop table accounts;
+`)},
+	"ok/002_orders.sql": {Data: []byte(`-- +goose Up
+create table orders (id text primary key);
+-- +goose Down
+drop table orders;
+`)},
+	"broken/001_accounts.sql": {Data: []byte(`-- +goose Up
+create table accounts (id text primary key);
+-- +goose Down
+drop table accounts;
+`)},
+	"broken/002_invalid.sql": {Data: []byte(`-- +goose Up
+create tabel invalid;
+`)},
+}
+
+func TestMigrations(t *testing.T) {
+	env := &micro.Env{DataSources: map[string]micro.DataSource{
+		"t1": NewGormAdapter("file:migrations1?mode=memory&cache=shared", "t1"),
+		"t2": NewGormAdapter("file:migrations2?mode=memory&cache=shared", "t2"),
+	}}
+	defer env.Close()
+	migrator := micro.NewMigrator(env, func(tenant string) []micro.MigrationSource {
+		location := "ok"
+		if tenant == "t2" {
+			location = "broken"
+		}
+		return []micro.MigrationSource{{FS: testMigrations, Location: location, Table: "z_migrations"}}
+	}, 2)
+	ctx := context.Background()
+
+	reports := migrator.Run(ctx, micro.MigrationOptions{Command: micro.MigrateUp, DryRun: true}, "t1")
+	assert.Len(t, reports, 1)
+	assert.Nil(t, reports[0].Err)
+	assert.Len(t, reports[0].Steps, 2)
+	assert.Equal(t, int64(0), reports[0].Version)
+
+	reports = migrator.Run(ctx, micro.MigrationOptions{Command: micro.MigrateUp})
+	assert.Len(t, reports, 2)
+	assert.Equal(t, "t1", reports[0].Tenant)
+	assert.Nil(t, reports[0].Err)
+	assert.Equal(t, int64(2), reports[0].Version)
+	assert.Equal(t, "002_orders.sql", reports[0].Steps[1].Name)
+	assert.Equal(t, "t2", reports[1].Tenant)
+	assert.NotNil(t, reports[1].Err)
+	assert.Len(t, reports[1].Steps, 1)
+	assert.NotNil(t, micro.MigrationErrors(reports))
+
+	env.Health = micro.NewHealthRegistry()
+	env.Health.SetState(micro.HealthReady)
+	failed, err := checkMigrations(env, reports)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"t2": true}, failed)
+	// the service stays ready, only the requests of the failed tenant are rejected
+	_, ready := env.Health.Ready(ctx)
+	assert.True(t, ready)
+	status, _ := env.Health.Check(ctx)
+	assert.Equal(t, "DOWN", status.Components["migrations:t2"].Status)
+	router := NewEchoAdapter(env, micro.RouterConfig{})
+	router.GET("/tenant", func(ctx micro.Ctx) (any, error) {
+		return ctx.TenantId, nil
+	})
+	for tenant, code := range map[string]int{"t1": http.StatusOK, "t2": http.StatusServiceUnavailable} {
+		req := httptest.NewRequest(http.MethodGet, "/tenant", nil)
+		req.Header.Set(micro.TenantIdHttpHeader, tenant)
+		rec := httptest.NewRecorder()
+		router.Handler().ServeHTTP(rec, req)
+		assert.Equal(t, code, rec.Code, tenant)
+	}
+	_, err = checkMigrations(env, []micro.MigrationReport{{Tenant: micro.DefaultTenantId, Err: reports[1].Err}})
+	assert.ErrorContains(t, err, "shared datasource")
+
+	reports = migrator.Run(ctx, micro.MigrationOptions{Command: micro.MigrateStatus}, "t1")
+	assert.Len(t, reports[0].Status, 2)
+	assert.True(t, reports[0].Status[1].Applied)
+
+	reports = migrator.Run(ctx, micro.MigrationOptions{Command: micro.MigrateRedo}, "t1")
+	assert.Nil(t, reports[0].Err)
+	assert.Equal(t, []string{"down", "up"}, []string{reports[0].Steps[0].Direction, reports[0].Steps[1].Direction})
+	assert.Equal(t, int64(2), reports[0].Version)
+
+	reports = migrator.Run(ctx, micro.MigrationOptions{Command: micro.MigrateTo, Version: 1, DryRun: true}, "t1")
+	assert.Len(t, reports[0].Steps, 1)
+	assert.Equal(t, int64(2), reports[0].Version)
+	reports = migrator.Run(ctx, micro.MigrationOptions{Command: micro.MigrateDown}, "t1")
+	assert.Nil(t, reports[0].Err)
+	assert.Equal(t, int64(1), reports[0].Version)
+	exists, _ := env.DataSources["t1"].Raw(micro.Query{Raw: "select * from orders"})
+	assert.Equal(t, int64(0), exists)
+
+	reports = migrator.Run(ctx, micro.MigrationOptions{Command: micro.MigrateTo, Version: 2}, "t1", "unknown")
+	assert.Nil(t, reports[0].Err)
+	assert.Equal(t, int64(2), reports[0].Version)
+	assert.NotNil(t, reports[1].Err)
+}
+
+func TestMigrationCommand(t *testing.T) {
+	opts, tenants, err := micro.ParseMigrationArgs([]string{"to", "20240101", "--dry-run", "--tenant", "t1,t2"})
+	assert.Nil(t, err)
+	assert.Equal(t, micro.MigrationOptions{Command: micro.MigrateTo, Version: 20240101, DryRun: true}, opts)
+	assert.Equal(t, []string{"t1", "t2"}, tenants)
+	opts, _, err = micro.ParseMigrationArgs(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, micro.MigrateStatus, opts.Command)
+	_, _, err = micro.ParseMigrationArgs([]string{"sideways"})
+	assert.NotNil(t, err)
+
+	env := &micro.Env{DataSources: map[string]micro.DataSource{
+		"t1": NewGormAdapter("file:migrations3?mode=memory&cache=shared", "t1"),
+	}}
+	defer env.Close()
+	env.Migrator = micro.NewMigrator(env, func(string) []micro.MigrationSource {
+		return []micro.MigrationSource{{FS: testMigrations, Location: "ok", Table: "z_migrations"}}
+	}, 1)
+	app := &micro.App{Env: env}
+	out := &bytes.Buffer{}
+	assert.Nil(t, app.RunMigrationCommand(context.Background(), []string{"up"}, out))
+	assert.Contains(t, out.String(), "[t1] z_migrations (version 2)")
+	assert.Contains(t, out.String(), "002_orders.sql")
+}
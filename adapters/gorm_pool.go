@@ -0,0 +1,190 @@
+package adapters
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/soffa-projects/go-micro/micro"
+	"strings"
+	"sync"
+)
+
+// sharedPools are the connection pools shared by the tenants of a server (shared pool mode)
+var sharedPools = struct {
+	sync.Mutex
+	pools map[string]*sharedPool
+}{pools: map[string]*sharedPool{}}
+
+type sharedPool struct {
+	key  string
+	db   *sql.DB
+	refs int
+}
+
+// acquireSharedPool returns the pool of key, opened with open on the first call
+func acquireSharedPool(key string, open func() *sql.DB) *sharedPool {
+	sharedPools.Lock()
+	defer sharedPools.Unlock()
+	pool, ok := sharedPools.pools[key]
+	if !ok {
+		pool = &sharedPool{key: key, db: open()}
+		sharedPools.pools[key] = pool
+	}
+	pool.refs++
+	return pool
+}
+
+// release closes the pool when its last tenant is closed
+func (p *sharedPool) release() {
+	sharedPools.Lock()
+	defer sharedPools.Unlock()
+	p.refs--
+	if p.refs <= 0 {
+		delete(sharedPools.pools, p.key)
+		_ = p.db.Close()
+	}
+}
+
+// tenantPool is the gorm connection pool of a tenant on a shared pool: every statement
+// runs on a connection of the shared pool after setting the tenant (the search_path or
+// the TenantSetting), for the session or for the transaction.
+type tenantPool struct {
+	pool    *sharedPool
+	schema  string
+	session string
+	local   string
+}
+
+// newTenantPool selects the tenant schema with the search_path
+func newTenantPool(pool *sharedPool, schema string) *tenantPool {
+	if schema == "" {
+		schema = "public"
+	}
+	searchPath := quoteSchema(schema)
+	return &tenantPool{
+		pool:    pool,
+		schema:  schema,
+		session: "SET search_path TO " + searchPath,
+		local:   "SET LOCAL search_path TO " + searchPath,
+	}
+}
+
+// newRowTenantPool shares the pool between the tenants of shared tables, with row level
+// security the tenant is set for the postgres policies
+func newRowTenantPool(pool *sharedPool, tenant string, rowLevelSecurity bool) *tenantPool {
+	if !rowLevelSecurity {
+		return &tenantPool{pool: pool}
+	}
+	value := "'" + strings.ReplaceAll(tenant, "'", "''") + "'"
+	return &tenantPool{
+		pool:    pool,
+		session: fmt.Sprintf("SELECT set_config('%s', %s, false)", micro.TenantSetting, value),
+		local:   fmt.Sprintf("SELECT set_config('%s', %s, true)", micro.TenantSetting, value),
+	}
+}
+
+var errSharedPoolPrepare = errors.New("prepared statements are not supported with a shared pool")
+
+func (p *tenantPool) conn(ctx context.Context) (*sql.Conn, error) {
+	conn, err := p.pool.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, p.session); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (p *tenantPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	if p.session == "" {
+		return p.pool.db.PrepareContext(ctx, query)
+	}
+	return nil, errSharedPoolPrepare
+}
+
+func (p *tenantPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if p.session == "" {
+		return p.pool.db.ExecContext(ctx, query, args...)
+	}
+	conn, err := p.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	return conn.ExecContext(ctx, query, args...)
+}
+
+func (p *tenantPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if p.session == "" {
+		return p.pool.db.QueryContext(ctx, query, args...)
+	}
+	conn, err := p.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// Conn.Close waits for the rows to be closed before returning the connection to the pool
+	go func() {
+		_ = conn.Close()
+	}()
+	return rows, nil
+}
+
+func (p *tenantPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if p.session == "" {
+		return p.pool.db.QueryRowContext(ctx, query, args...)
+	}
+	conn, err := p.conn(ctx)
+	if err != nil {
+		// the row must carry the error: the query is never sent with a cancelled context
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		return p.pool.db.QueryRowContext(cancelled, query, args...)
+	}
+	row := conn.QueryRowContext(ctx, query, args...)
+	go func() {
+		_ = conn.Close()
+	}()
+	return row
+}
+
+func (p *tenantPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := p.pool.db.BeginTx(ctx, opts)
+	if err != nil || p.local == "" {
+		return tx, err
+	}
+	if _, err := tx.ExecContext(ctx, p.local); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (p *tenantPool) GetDBConn() (*sql.DB, error) {
+	return p.pool.db, nil
+}
+
+// configurePool applies the pool settings, the zero values keep the database/sql defaults
+func configurePool(db *sql.DB, cfg micro.PoolCfg) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+}
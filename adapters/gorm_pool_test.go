@@ -0,0 +1,110 @@
+package adapters
+
+import (
+	"database/sql"
+	"github.com/jackc/pgx/v5"
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func TestPoolSettings(t *testing.T) {
+	ds := NewGormDataSource(micro.DataSourceCfg{
+		Url:    "file:pool?mode=memory&cache=shared",
+		Tenant: "pool",
+		Pool:   micro.PoolCfg{MaxOpenConns: 3, MaxIdleConns: 2, ConnMaxLifetime: time.Minute},
+	})
+	defer ds.Close()
+	sqlDB, err := ds.(*adapter).internal.DB()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, sqlDB.Stats().MaxOpenConnections)
+}
+
+func TestSharedPool(t *testing.T) {
+	assert.Equal(t, `SET search_path TO "acme"`, newTenantPool(nil, "acme").session)
+	assert.Equal(t, `SET LOCAL search_path TO "public"`, newTenantPool(nil, "").local)
+
+	open := func() *sql.DB {
+		db, err := sql.Open("sqlite3", "file:sharedpool?mode=memory&cache=shared")
+		assert.Nil(t, err)
+		db.SetMaxOpenConns(1)
+		return db
+	}
+	first := acquireSharedPool("sharedpool", open)
+	second := acquireSharedPool("sharedpool", open)
+	assert.Same(t, first, second)
+
+	// with a single connection, a statement that doesn't release it blocks the next one
+	pool := &tenantPool{pool: first, session: "SELECT 1", local: "SELECT 1"}
+	db, err := gorm.Open(&sqlite.Dialector{Conn: pool}, &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Exec("create table pooled_items (id text primary key)").Error)
+	assert.Nil(t, db.Exec("insert into pooled_items values ('a')").Error)
+	var ids []string
+	assert.Nil(t, db.Raw("select id from pooled_items").Scan(&ids).Error)
+	assert.Equal(t, []string{"a"}, ids)
+	var count int64
+	assert.Nil(t, db.Table("pooled_items").Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+	assert.Nil(t, db.Transaction(func(tx *gorm.DB) error {
+		return tx.Exec("insert into pooled_items values ('b')").Error
+	}))
+	assert.Nil(t, db.Raw("select id from pooled_items order by id").Scan(&ids).Error)
+	assert.Equal(t, []string{"a", "b"}, ids)
+
+	first.release()
+	assert.Nil(t, first.db.Ping())
+	second.release()
+	assert.NotNil(t, first.db.Ping())
+}
+
+func TestSharedPoolIsolation(t *testing.T) {
+	assert.Equal(t, `SET search_path TO "Acme"`, newTenantPool(nil, "Acme").session)
+	config, err := pgx.ParseConfig(tenantDsn("pg://u:p@db/app", "Acme"))
+	assert.Nil(t, err)
+	assert.Equal(t, quoteSchema("Acme"), config.RuntimeParams["search_path"])
+
+	pool := acquireSharedPool("sharedisolation", func() *sql.DB {
+		db, err := sql.Open("sqlite3", "file:sharedisolation?mode=memory&cache=shared")
+		assert.Nil(t, err)
+		db.SetMaxOpenConns(1)
+		return db
+	})
+	defer pool.release()
+
+	// sqlite has no search_path, the session statement selects the tenant of the view instead
+	_, err = pool.db.Exec(`create table tenant_session (id text);
+		insert into tenant_session values ('');
+		create table tenant_rows (tenant text, id text);
+		create view pooled_rows as select id from tenant_rows where tenant = (select id from tenant_session);
+		create trigger pooled_rows_insert instead of insert on pooled_rows begin
+			insert into tenant_rows values ((select id from tenant_session), new.id);
+		end`)
+	assert.Nil(t, err)
+	open := func(tenant string) *gorm.DB {
+		statement := "update tenant_session set id = '" + tenant + "'"
+		db, err := gorm.Open(&sqlite.Dialector{Conn: &tenantPool{pool: pool, session: statement, local: statement}}, &gorm.Config{})
+		assert.Nil(t, err)
+		return db
+	}
+	t1 := open("t1")
+	t2 := open("t2")
+
+	assert.Nil(t, t1.Exec("insert into pooled_rows values ('a')").Error)
+	assert.Nil(t, t2.Exec("insert into pooled_rows values ('b')").Error)
+	assert.Nil(t, t2.Transaction(func(tx *gorm.DB) error {
+		return tx.Exec("insert into pooled_rows values ('c')").Error
+	}))
+
+	var ids []string
+	assert.Nil(t, t1.Raw("select id from pooled_rows order by id").Scan(&ids).Error)
+	assert.Equal(t, []string{"a"}, ids)
+	assert.Nil(t, t2.Raw("select id from pooled_rows order by id").Scan(&ids).Error)
+	assert.Equal(t, []string{"b", "c"}, ids)
+	var count int64
+	assert.Nil(t, t1.Table("pooled_rows").Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
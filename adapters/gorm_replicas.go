@@ -0,0 +1,194 @@
+package adapters
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/soffa-projects/go-micro/micro"
+	"gorm.io/gorm"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sharedReplicas are the read replicas shared by the datasources of the tenants, keyed by
+// the url of their connection pool
+var sharedReplicas = struct {
+	sync.Mutex
+	replicas map[string]*sharedReplica
+}{replicas: map[string]*sharedReplica{}}
+
+// sharedReplica is the connection pool of a read replica and its health, checked in the
+// background by a single goroutine whatever the number of tenants using it
+type sharedReplica struct {
+	key     string
+	pool    *sharedPool
+	healthy atomic.Bool
+	refs    int
+	stop    chan struct{}
+}
+
+// replicaSet balances the reads of a datasource between its read replicas (round-robin)
+type replicaSet struct {
+	replicas []*replica
+	counter  uint64
+	stopOnce sync.Once
+}
+
+// replica is the connection of a tenant to a shared replica
+type replica struct {
+	shared *sharedReplica
+	db     *gorm.DB
+}
+
+func newReplicaSet(cfg micro.DataSourceCfg) *replicaSet {
+	if len(cfg.Replicas) == 0 {
+		return nil
+	}
+	set := &replicaSet{}
+	for i, url := range cfg.Replicas {
+		var urlSource func() string
+		if i < len(cfg.ReplicaSources) {
+			urlSource = cfg.ReplicaSources[i]
+		}
+		shared, tenant := acquireReplica(cfg, url, urlSource)
+		db := configureLink(cfg, openGorm(cfg, connDialector(dialectOf(url), tenant)))
+		set.replicas = append(set.replicas, &replica{shared: shared, db: db})
+	}
+	return set
+}
+
+// acquireReplica returns the shared replica of url and the pool selecting the tenant of
+// cfg on its connections: the postgres schemas and the tenants of shared tables use the
+// same pool, the tenants with their own database (MySQL, SQL Server) have their own pool.
+// The new connections of the pool use the latest url of urlSource when it is set.
+func acquireReplica(cfg micro.DataSourceCfg, url string, urlSource func() string) (*sharedReplica, *tenantPool) {
+	dialect := dialectOf(url)
+	schema := tenantSchema(cfg)
+	poolSchema := schema
+	if cfg.Tenancy == micro.TenancyRow || dialect == micro.DialectPostgres {
+		poolSchema = ""
+	}
+	key := tenantDsn(url, poolSchema)
+
+	sharedReplicas.Lock()
+	defer sharedReplicas.Unlock()
+	shared, ok := sharedReplicas.replicas[key]
+	if !ok {
+		shared = &sharedReplica{
+			key: key,
+			pool: acquireSharedPool(key, func() *sql.DB {
+				return openPool(dialect, key, poolSchema, urlSource)
+			}),
+			stop: make(chan struct{}),
+		}
+		shared.healthy.Store(true)
+		sharedReplicas.replicas[key] = shared
+		interval := cfg.ReplicaCheckInterval
+		if interval <= 0 {
+			interval = micro.DefaultReplicaCheckInterval
+		}
+		go shared.watch(interval)
+	}
+	shared.refs++
+
+	switch {
+	case cfg.Tenancy == micro.TenancyRow:
+		return shared, newRowTenantPool(shared.pool, cfg.Tenant, cfg.RowLevelSecurity && dialect == micro.DialectPostgres)
+	case dialect == micro.DialectPostgres && schema != "":
+		return shared, newTenantPool(shared.pool, schema)
+	}
+	return shared, &tenantPool{pool: shared.pool}
+}
+
+// release stops the health checks and closes the pool when the last tenant is closed
+func (r *sharedReplica) release() {
+	sharedReplicas.Lock()
+	defer sharedReplicas.Unlock()
+	r.refs--
+	if r.refs <= 0 {
+		delete(sharedReplicas.replicas, r.key)
+		close(r.stop)
+		r.pool.release()
+	}
+}
+
+// next returns the next healthy replica, nil when none is available
+func (s *replicaSet) next() *replica {
+	count := uint64(len(s.replicas))
+	start := atomic.AddUint64(&s.counter, 1)
+	for i := uint64(0); i < count; i++ {
+		r := s.replicas[(start+i)%count]
+		if r.shared.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// failed checks a replica after a failed read, it returns true when the replica is down
+func (s *replicaSet) failed(r *replica) bool {
+	if err := r.shared.ping(); err != nil {
+		if r.shared.healthy.Swap(false) {
+			log.Warnf("read replica unavailable, reads sent to the primary: %s", err)
+		}
+		return true
+	}
+	return false
+}
+
+func (r *sharedReplica) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			err := r.ping()
+			if err == nil && !r.healthy.Swap(true) {
+				log.Infof("read replica available again")
+			} else if err != nil && r.healthy.Swap(false) {
+				log.Warnf("read replica unavailable, reads sent to the primary: %s", err)
+			}
+		}
+	}
+}
+
+func (s *replicaSet) close() {
+	s.stopOnce.Do(func() {
+		for _, r := range s.replicas {
+			r.shared.release()
+		}
+	})
+}
+
+func (r *sharedReplica) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return r.pool.db.PingContext(ctx)
+}
+
+// reader returns the connection used for a read: a healthy replica when the datasource is
+// not in a transaction and the primary was not requested (micro.WithPrimaryReads)
+func (a adapter) reader() (*gorm.DB, *replica) {
+	ctx := a.internal.Statement.Context
+	if a.replicas == nil || micro.PrimaryReads(ctx) {
+		return a.internal, nil
+	}
+	r := a.replicas.next()
+	if r == nil {
+		return a.internal, nil
+	}
+	return r.db.WithContext(ctx), r
+}
+
+// read runs fn on the reader, and again on the primary when the replica is down
+func (a adapter) read(fn func(db *gorm.DB) error) error {
+	db, r := a.reader()
+	err := fn(db)
+	if err != nil && r != nil && !errors.Is(err, gorm.ErrRecordNotFound) && a.replicas.failed(r) {
+		return fn(a.internal)
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package adapters
+
+import (
+	"github.com/soffa-projects/go-micro/micro"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+)
+
+// registerTenantScope restricts the statements of db to the rows of tenant (TenancyRow): the
+// tenant column is filled on insert, added to the conditions of the other statements and
+// never updated.
+// The models without the column (e.g. global tables) and the raw queries are not scoped.
+func registerTenantScope(db *gorm.DB, tenant string) error {
+	scope := func(tx *gorm.DB) {
+		stmt := tx.Statement
+		if stmt.SQL.Len() > 0 || stmt.Schema == nil {
+			return
+		}
+		if field := stmt.Schema.LookUpField(micro.TenantColumn); field != nil {
+			// the conditions of the caller are grouped (like gorm.SoftDeleteQueryClause) so
+			// that their OR cannot escape the tenant condition
+			if c, ok := stmt.Clauses["WHERE"]; ok {
+				if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 0 {
+					where.Exprs = []clause.Expression{clause.And(where.Exprs...)}
+					c.Expression = where
+					stmt.Clauses["WHERE"] = c
+				}
+			}
+			stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+				clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: tenant},
+			}})
+		}
+	}
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("micro:tenant_create", func(tx *gorm.DB) {
+		stmt := tx.Statement
+		if stmt.Schema == nil {
+			return
+		}
+		field := stmt.Schema.LookUpField(micro.TenantColumn)
+		if field == nil {
+			return
+		}
+		forEachRecord(stmt.ReflectValue, func(record reflect.Value) {
+			if record.CanAddr() {
+				_ = field.Set(stmt.Context, record, tenant)
+			}
+		})
+		// an upsert (e.g. Save of an unknown id) must not update the row of another tenant
+		if c, ok := stmt.Clauses["ON CONFLICT"]; ok {
+			if onConflict, ok := c.Expression.(clause.OnConflict); ok && !onConflict.DoNothing {
+				onConflict.Where.Exprs = append(onConflict.Where.Exprs, clause.Eq{
+					Column: clause.Column{Table: stmt.Table, Name: field.DBName}, Value: tenant,
+				})
+				c.Expression = onConflict
+				stmt.Clauses["ON CONFLICT"] = c
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("micro:tenant_query", scope); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("micro:tenant_row", scope); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("micro:tenant_update", func(tx *gorm.DB) {
+		scope(tx)
+		// the tenant column is never updated, a row cannot be moved to another tenant
+		if stmt := tx.Statement; stmt.SQL.Len() == 0 && stmt.Schema != nil {
+			if field := stmt.Schema.LookUpField(micro.TenantColumn); field != nil {
+				stmt.Omits = append(stmt.Omits, field.DBName)
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	return cb.Delete().Before("gorm:delete").Register("micro:tenant_delete", scope)
+}
@@ -0,0 +1,53 @@
+package adapters
+
+import (
+	"github.com/soffa-projects/go-micro/micro"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const tracingSpanKey = "micro:tracing_span"
+
+// registerTracingCallbacks creates a client span for every gorm operation, as a child
+// of the span carried by the statement context (see micro.Ctx.CurrentDB)
+func registerTracingCallbacks(db *gorm.DB, tenant string) error {
+	system := semconv.DBSystemKey.String(db.Dialector.Name())
+	before := func(operation string) func(tx *gorm.DB) {
+		return func(tx *gorm.DB) {
+			ctx, span := micro.Tracer().Start(tx.Statement.Context, "gorm."+operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(system, attribute.String("tenant", tenant)),
+			)
+			tx.Statement.Context = ctx
+			tx.InstanceSet(tracingSpanKey, span)
+		}
+	}
+	after := func(string) func(tx *gorm.DB) {
+		return traceEnd
+	}
+	return registerAroundOperations(db, "tracing", before, after)
+}
+
+// traceEnd ends the span started before the operation
+func traceEnd(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(tracingSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+	if tx.Statement.Table != "" {
+		span.SetAttributes(semconv.DBSQLTable(tx.Statement.Table))
+	}
+	span.SetAttributes(
+		semconv.DBStatement(tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+	if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
+		span.RecordError(tx.Error)
+		span.SetStatus(codes.Error, tx.Error.Error())
+	}
+}
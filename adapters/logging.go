@@ -0,0 +1,5 @@
+package adapters
+
+import "github.com/soffa-projects/go-micro/micro"
+
+var log = micro.Logger("adapters")
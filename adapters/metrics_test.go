@@ -0,0 +1,90 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type metricsEntry struct {
+	Id string `gorm:"primaryKey"`
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := micro.NewMetrics("test")
+	ds := NewGormDataSource(micro.DataSourceCfg{Url: "file:metrics?mode=memory&cache=shared", Tenant: micro.DefaultTenantId, Metrics: metrics})
+	defer ds.Close()
+	_, err := ds.Raw(micro.Query{Raw: "create table metrics_entries (id text primary key)"})
+	assert.Nil(t, err)
+	env := &micro.Env{DataSources: map[string]micro.DataSource{micro.DefaultTenantId: ds}, Metrics: metrics}
+	router := NewEchoAdapter(env, micro.RouterConfig{Prometheus: &micro.PrometheusCfg{Enabled: true}})
+	router.POST("/entries", func(ctx micro.Ctx) (any, error) {
+		return nil, ctx.CurrentDB().Create(&metricsEntry{Id: "m1"})
+	})
+	router.GET("/entries", func(ctx micro.Ctx) (any, error) {
+		var entries []metricsEntry
+		return entries, ctx.CurrentDB().FindAll(&entries)
+	})
+	router.Proxy("/upstream/*", micro.NewRouterUpstream(map[string]*micro.Upstream{
+		"down": {Uri: "http://127.0.0.1:1", Prefix: "/upstream"},
+	}))
+	serve := func(method string, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.Handler().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/entries").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/entries").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/entries").Code)
+	assert.Equal(t, http.StatusBadGateway, serve(http.MethodGet, "/upstream/items").Code)
+
+	rec := serve(http.MethodGet, micro.DefaultMetricsPath)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, `test_http_requests_total{method="POST",route="/entries",status="200",tx="read_write"} 1`)
+	assert.Contains(t, body, `test_http_requests_total{method="GET",route="/entries",status="200",tx="none"} 2`)
+	assert.Contains(t, body, `test_http_requests_total{method="GET",route="/upstream/*",status="502",tx="none"} 1`)
+	assert.Contains(t, body, `test_db_query_duration_seconds_count{operation="create",tenant="public"} 1`)
+	assert.Contains(t, body, `test_db_query_duration_seconds_count{operation="query",tenant="public"} 2`)
+	assert.Contains(t, body, `test_proxy_upstream_duration_seconds_count{status="502",upstream="down"} 1`)
+	assert.NotContains(t, body, `route="/metrics"`)
+}
+
+type jobTenants []string
+
+func (t jobTenants) GetTenant() []string {
+	return t
+}
+
+func TestJobMetrics(t *testing.T) {
+	metrics := micro.NewMetrics("test")
+	scheduler := NewGoCronAdapter(&micro.Env{Metrics: metrics}, jobTenants{"t1", "t2"})
+	var runs sync.WaitGroup
+	runs.Add(3)
+	scheduler.Once(func(ctx micro.Ctx) error {
+		defer runs.Done()
+		return errors.New("job failed")
+	})
+	scheduler.OncePerTenant(func(ctx micro.Ctx) error {
+		defer runs.Done()
+		return nil
+	})
+	scheduler.StartAsync()
+	runs.Wait()
+	assert.Nil(t, scheduler.Stop(context.Background()))
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, micro.DefaultMetricsPath, nil))
+	body := rec.Body.String()
+	assert.Contains(t, body, `test_scheduler_job_runs_total{job="once",tenant="public"} 1`)
+	assert.Contains(t, body, `test_scheduler_job_failures_total{job="once",tenant="public"} 1`)
+	assert.Contains(t, body, `test_scheduler_job_runs_total{job="once per tenant",tenant="t1"} 1`)
+	assert.Contains(t, body, `test_scheduler_job_duration_seconds_count{job="once per tenant",tenant="t2"} 1`)
+	assert.NotContains(t, body, `test_scheduler_job_failures_total{job="once per tenant"`)
+}
@@ -0,0 +1,84 @@
+package adapters
+
+import (
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type queryCustomer struct {
+	Id     string `gorm:"primaryKey"`
+	Name   string
+	City   string
+	Age    int
+	Orders []queryOrder `gorm:"foreignKey:CustomerId"`
+}
+
+type queryOrder struct {
+	Id         string `gorm:"primaryKey"`
+	CustomerId string
+	Amount     int
+}
+
+type cityStats struct {
+	City  string
+	Total int
+}
+
+func TestQueryBuilder(t *testing.T) {
+	ds := NewGormAdapter("file:query?mode=memory&cache=shared", "query")
+	defer ds.Close()
+	_, err := ds.Raw(micro.Query{Raw: "create table query_customers (id text primary key, name text, city text, age integer)"})
+	assert.Nil(t, err)
+	_, err = ds.Raw(micro.Query{Raw: "create table query_orders (id text primary key, customer_id text, amount integer)"})
+	assert.Nil(t, err)
+
+	env := &micro.Env{DataSources: map[string]micro.DataSource{"query": ds}}
+	ctx := micro.NewCtx(env, "query")
+	repo := micro.NewRepoImpl[queryCustomer](func(e *queryCustomer) {})
+	assert.Nil(t, repo.CreateAll(ctx, []*queryCustomer{
+		{Id: "c1", Name: "alice", City: "paris", Age: 31, Orders: []queryOrder{{Id: "o1", Amount: 10}, {Id: "o2", Amount: 5}}},
+		{Id: "c2", Name: "bob", City: "paris", Age: 45},
+		{Id: "c3", Name: "carol", City: "lyon", Age: 27},
+		{Id: "c4", Name: "dave", City: "nice", Age: 60},
+	}))
+
+	found, err := repo.NewQuery(ctx).In("city", []string{"paris", "lyon"}).Between("age", 25, 40).Order("name").Find()
+	assert.Nil(t, err)
+	assert.Len(t, found, 2)
+	assert.Equal(t, "alice", found[0].Name)
+	assert.Equal(t, "carol", found[1].Name)
+
+	found, err = repo.NewQuery(ctx).Like("name", "%o%").OrWhere("age > ?", 50).Order("age", true).Find()
+	assert.Nil(t, err)
+	assert.Len(t, found, 3)
+	assert.Equal(t, "dave", found[0].Name)
+
+	first, err := repo.NewQuery(ctx).Where("id = ?", "c1").Preload("Orders").First()
+	assert.Nil(t, err)
+	assert.Len(t, first.Orders, 2)
+	missing, err := repo.NewQuery(ctx).Where("id = ?", "none").First()
+	assert.Nil(t, err)
+	assert.Nil(t, missing)
+
+	page, err := repo.NewQuery(ctx).Order("name").Paginate(2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, page.Total)
+	assert.Equal(t, 2, page.Page)
+	assert.Len(t, page.Data, 1)
+	assert.Equal(t, "dave", page.Data[0].Name)
+
+	stats, err := micro.Project[cityStats](repo.NewQuery(ctx).
+		Select("city", "count(*) as total").GroupBy("city").Having("count(*) > ?", 1))
+	assert.Nil(t, err)
+	assert.Equal(t, []cityStats{{City: "paris", Total: 2}}, stats)
+
+	spent, err := micro.Project[cityStats](repo.NewQuery(ctx).
+		Join("JOIN query_orders ON query_orders.customer_id = query_customers.id").
+		Select("query_customers.city", "sum(query_orders.amount) as total").GroupBy("query_customers.city"))
+	assert.Nil(t, err)
+	assert.Equal(t, []cityStats{{City: "paris", Total: 15}}, spent)
+
+	_, err = repo.NewQuery(ctx).Order("name; drop table query_customers").Find()
+	assert.ErrorContains(t, err, "invalid column name")
+}
@@ -0,0 +1,122 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+type seededPlan struct {
+	Id   string `gorm:"primaryKey"`
+	Name string
+}
+
+var testSeeds = fstest.MapFS{
+	"tenant/plans.yaml": {Data: []byte(`
+- table: seeded_plans
+  rows:
+    - {id: free, name: Free}
+    - {id: pro, name: Pro}
+`)},
+	"tenant/demo.development.json": {Data: []byte(`[{"table": "seeded_plans", "rows": [{"id": "demo", "name": "Demo"}]}]`)},
+	"tenant/readme.md":             {Data: []byte("ignored")},
+}
+
+func TestGoMigrationsAndSeeds(t *testing.T) {
+	env := &micro.Env{Environment: "test", DataSources: map[string]micro.DataSource{
+		"t1": NewGormAdapter("file:seeds1?mode=memory&cache=shared", "t1"),
+		"t2": NewGormAdapter("file:seeds2?mode=memory&cache=shared", "t2"),
+	}}
+	defer env.Close()
+	migrations := []micro.GoMigration{
+		{Location: "tenant", Version: 1, Up: func(ctx micro.Ctx) error {
+			_, err := ctx.CurrentDB().Raw(micro.Query{Raw: "create table seeded_plans (id text primary key, name text)"})
+			return err
+		}, Down: func(ctx micro.Ctx) error {
+			_, err := ctx.CurrentDB().Raw(micro.Query{Raw: "drop table seeded_plans"})
+			return err
+		}},
+		{Location: "tenant", Version: 2, Up: func(ctx micro.Ctx) error {
+			if ctx.TenantId == "t2" {
+				return errors.New("rejected")
+			}
+			return micro.NewRepoImpl[seededPlan](func(e *seededPlan) {}).Create(ctx, &seededPlan{Id: "legacy", Name: ctx.TenantId})
+		}},
+	}
+	migrator := micro.NewMigrator(env, func(string) []micro.MigrationSource {
+		return []micro.MigrationSource{{FS: fstest.MapFS{}, Location: ".", Table: "z_migrations", Go: micro.GoMigrationsOf(migrations, "tenant")}}
+	}, 2)
+	reports := migrator.Run(context.Background(), micro.MigrationOptions{Command: micro.MigrateUp})
+	assert.Nil(t, reports[0].Err)
+	assert.Equal(t, int64(2), reports[0].Version)
+	assert.Equal(t, "2.go", reports[0].Steps[1].Name)
+	// the failed migration is rolled back with its transaction
+	assert.NotNil(t, reports[1].Err)
+	assert.Equal(t, int64(1), reports[1].Version)
+
+	repo := micro.NewRepoImpl[seededPlan](func(e *seededPlan) {})
+	legacy, _ := repo.FindById(micro.NewCtx(env, "t1"), "legacy")
+	assert.Equal(t, "t1", legacy.Name)
+
+	fixtures, err := micro.LoadFixtures(testSeeds, "tenant")
+	assert.Nil(t, err)
+	assert.Len(t, fixtures, 2)
+	seeders := append(fixtures, micro.Seeder{Name: "enterprise", Run: func(ctx micro.Ctx) error {
+		return repo.Create(ctx, &seededPlan{Id: "enterprise", Name: "Enterprise"})
+	}})
+	seeds := micro.NewSeeds(env, func(string) []micro.Seeder { return seeders }, "", 1)
+	seedReports := seeds.Run(context.Background(), "t1")
+	assert.Nil(t, micro.SeedErrors(seedReports))
+	assert.Equal(t, []string{"plans", "enterprise"}, seedReports[0].Applied)
+	seedReports = seeds.Run(context.Background(), "t1")
+	assert.Empty(t, seedReports[0].Applied)
+	assert.Equal(t, []string{"plans", "enterprise"}, seedReports[0].Skipped)
+
+	seeds.Environment = "development"
+	seedReports = seeds.Run(context.Background(), "t2")
+	assert.Nil(t, seedReports[0].Err)
+	assert.Equal(t, []string{"demo.development", "plans", "enterprise"}, seedReports[0].Applied)
+	count, _ := repo.CountAll(micro.NewCtx(env, "t2"))
+	assert.Equal(t, int64(4), count)
+	demo, _ := repo.FindById(micro.NewCtx(env, "t2"), "demo")
+	assert.NotNil(t, demo)
+	demo, _ = repo.FindById(micro.NewCtx(env, "t1"), "demo")
+	assert.Nil(t, demo)
+}
+
+type seededRowPlan struct {
+	Id   string `gorm:"primaryKey"`
+	Name string
+	micro.TenantOwned
+}
+
+func (seededRowPlan) TableName() string {
+	return "seeded_plans"
+}
+
+func TestRowTenancyFixtures(t *testing.T) {
+	url := "file:row_seeds?mode=memory&cache=shared"
+	env := &micro.Env{Environment: "test", DataSources: map[string]micro.DataSource{}}
+	for _, tenant := range []string{"t1", "t2"} {
+		env.DataSources[tenant] = NewGormDataSource(micro.DataSourceCfg{Url: url, Tenant: tenant, Tenancy: micro.TenancyRow})
+	}
+	defer env.Close()
+	_, err := env.DataSources["t1"].Raw(micro.Query{Raw: "create table seeded_plans (id text, name text, tenant_id text not null, primary key (id, tenant_id))"})
+	assert.Nil(t, err)
+
+	fixtures, err := micro.LoadFixtures(testSeeds, "tenant", micro.TenantColumn)
+	assert.Nil(t, err)
+	seeds := micro.NewSeeds(env, func(string) []micro.Seeder { return fixtures }, "", 1)
+	assert.Nil(t, micro.SeedErrors(seeds.Run(context.Background())))
+
+	repo := micro.NewRepoImpl[seededRowPlan](func(e *seededRowPlan) {})
+	for _, tenant := range []string{"t1", "t2"} {
+		plans, err := repo.FindAll(micro.NewCtx(env, tenant))
+		assert.Nil(t, err)
+		assert.Len(t, plans, 2)
+		assert.Equal(t, tenant, plans[0].TenantId)
+	}
+}
@@ -1,17 +1,18 @@
 package adapters
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
-	log "github.com/sirupsen/logrus"
 	"github.com/soffa-projects/go-micro/micro"
+	"net/http"
 )
 
 type SendGridEmailSender struct {
 	micro.Mailer
-	apiKey string
+	apiKey func() string
 }
 
 type sengridError struct {
@@ -25,6 +26,13 @@ type sengridErrorResponse struct {
 }
 
 func NewSendGridEmailSender(apikey string) micro.Mailer {
+	return NewRotatingSendGridEmailSender(func() string {
+		return apikey
+	})
+}
+
+// NewRotatingSendGridEmailSender reads the api key from apikey before every call
+func NewRotatingSendGridEmailSender(apikey func() string) micro.Mailer {
 	return SendGridEmailSender{apiKey: apikey}
 }
 
@@ -46,7 +54,7 @@ func (s SendGridEmailSender) Send(message micro.Email) error {
 	}
 	m.AddPersonalizations(p)
 
-	request := sendgrid.GetRequest(s.apiKey, "/v3/mail/send", "https://api.sendgrid.com")
+	request := sendgrid.GetRequest(s.apiKey(), "/v3/mail/send", "https://api.sendgrid.com")
 	request.Method = "POST"
 	var Body = mail.GetRequestBody(m)
 	request.Body = Body
@@ -59,7 +67,7 @@ func (s SendGridEmailSender) Send(message micro.Email) error {
 		_ = json.Unmarshal([]byte(res.Body), &resultErr)
 		return fmt.Errorf("%s", resultErr.Errors[0].Message)
 	} else {
-		log.Infof("Email sent to %v", recipients)
+		log.Debugf("Email sent to %v", recipients)
 		/*model := ProjectInvitation{Id: invite.Id}
 		  if err = ctx.Env.DB.UpdateColumn(&model, "status", StatusSent); err != nil {
 		    log.Error(err)
@@ -77,3 +85,21 @@ func (s SendGridEmailSender) SendBatch(message []micro.Email) error {
 	}
 	return nil
 }
+
+// HealthCheck verifies that the api key is accepted by sendgrid
+func (s SendGridEmailSender) HealthCheck(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.sendgrid.com/v3/scopes", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey())
+	res, err := micro.NewHttpClient(0).Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid returned %d", res.StatusCode)
+	}
+	return nil
+}
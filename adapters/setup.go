@@ -1,38 +1,61 @@
 package adapters
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"github.com/pelletier/go-toml/v2"
 	"github.com/redis/go-redis/v9"
-	log "github.com/sirupsen/logrus"
 	"github.com/soffa-projects/go-micro/micro"
 	"github.com/soffa-projects/go-micro/util/h"
 	"github.com/thoas/go-funk"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"golang.org/x/text/language"
-	"os"
+	"io/fs"
 	"strings"
 )
 
 func NewApp(name string, version string, cfg micro.Cfg) *micro.App {
-	isProduction := os.Getenv("GO_ENV") == "production"
+	goEnv := h.GetEnvOrDefault("GO_ENV", "development")
+	isProduction := goEnv == "production"
 	if !isProduction {
 		err := godotenv.Load()
 		if err != nil {
 			log.Warn("unable to loading .env file")
 		}
-		micro.Set(micro.InsecureJwtDev, os.Getenv(micro.InsecureJwtDev))
 	}
 
+	settings := &micro.Settings{}
+	if err := micro.LoadConfig(settings, micro.ConfigOptions{}); err != nil {
+		log.Fatalf("unable to load settings: %s", err)
+	}
+	if !isProduction && settings.InsecureJwtDev {
+		micro.Set(micro.InsecureJwtDev, "true")
+	}
+	micro.ConfigureLogging(isProduction, settings.LogLevel, settings.LogFormat)
+
 	env := &micro.Env{
-		Production: isProduction,
-		AppName:    name,
-		AppVersion: version,
+		Production:  isProduction,
+		Environment: goEnv,
+		AppName:     name,
+		AppVersion:  version,
+		Settings:    settings,
+		Health:      micro.NewHealthRegistry(),
 	}
 
-	env.ServerPort = h.ToInt(h.GetEnvOrDefault("PORT", "8080"))
+	env.ServerPort = settings.Port
+	stopSecrets := setupSecrets(env)
+	setupConfig(env, cfg, goEnv)
 	setupLocales(env, cfg)
+	setupMetrics(env, cfg)
+	shutdownTracing := setupTracing(env, cfg)
 	prepareMultiTenancy(env, cfg)
 	setupDatabase(env, cfg)
 	setupScheduler(env)
@@ -40,21 +63,96 @@ func NewApp(name string, version string, cfg micro.Cfg) *micro.App {
 	setupNotifications(env)
 	setupTokenProvider(env)
 	setupRedis(env, cfg)
+	stopFlags := setupFlags(env, cfg)
+	setupAudit(env, cfg)
+	setupHealthChecks(env)
 	router := setupRouter(env, cfg)
 
 	// configure locales if any
 	app := &micro.App{
-		Name:              name,
-		Version:           version,
-		Env:               env,
-		ShutdownListeners: []func(){},
-		Router:            router,
+		Name:            name,
+		Version:         version,
+		Env:             env,
+		Router:          router,
+		ShutdownTimeout: cfg.ShutdownTimeout,
+		ShutdownDelay:   cfg.ShutdownDelay,
+	}
+	if settings.ShutdownTimeout > 0 {
+		app.ShutdownTimeout = settings.ShutdownTimeout
+	}
+	if settings.ShutdownDelay > 0 {
+		app.ShutdownDelay = settings.ShutdownDelay
 	}
+	if shutdownTracing != nil {
+		app.AddShutdownListener(shutdownTracing)
+	}
+	app.AddShutdownListener(stopSecrets)
+	app.AddShutdownListener(stopFlags)
 
 	return app
 
 }
 
+func setupConfig(env *micro.Env, cfg micro.Cfg, goEnv string) {
+	log.Infof("settings: %v", micro.DumpConfig(env.Settings))
+	if cfg.Config == nil {
+		return
+	}
+	err := micro.LoadConfig(cfg.Config, micro.ConfigOptions{
+		FS:    cfg.FS,
+		Files: micro.DefaultConfigFiles(goEnv),
+	})
+	if err != nil {
+		log.Fatalf("unable to load configuration: %s", err)
+	}
+	if err = micro.ResolveSecrets(context.Background(), env.Secrets, cfg.Config); err != nil {
+		log.Fatalf("unable to resolve configuration secrets: %s", err)
+	}
+	env.Conf = cfg.Config
+	log.Infof("configuration: %v", micro.DumpConfig(cfg.Config))
+}
+
+// setupSecrets registers the secret providers, the secret settings are kept as references
+// and resolved by the components so that they can follow the rotations
+func setupSecrets(env *micro.Env) func() {
+	resolver := micro.NewSecretResolver(env.Settings.SecretsTTL)
+	resolver.RegisterProvider("file", micro.FileSecretProvider{})
+	resolver.RegisterProvider("env", micro.EnvSecretProvider{})
+	if env.Settings.VaultAddr != "" {
+		token, err := resolver.Resolve(context.Background(), env.Settings.VaultToken)
+		if err != nil {
+			log.Fatalf("unable to resolve %s: %s", micro.VaultToken, err)
+		}
+		vault := micro.NewVaultSecretProvider(env.Settings.VaultAddr, token)
+		vault.Namespace = env.Settings.VaultNamespace
+		resolver.RegisterProvider("vault", vault)
+		log.Infof("env.%s detected, vault secrets enabled", micro.VaultAddr)
+	}
+	env.Secrets = resolver
+	ctx, cancel := context.WithCancel(context.Background())
+	resolver.Watch(ctx)
+	return cancel
+}
+
+// resolveSecret returns the current value of a setting, the application stops when
+// a reference cannot be resolved at startup
+func resolveSecret(env *micro.Env, name string, value string) string {
+	resolved, err := env.Secrets.Resolve(context.Background(), value)
+	if err != nil {
+		log.Fatalf("unable to resolve %s: %s", name, err)
+	}
+	return resolved
+}
+
+// secretSource returns a function following the rotations of value, or nil when value is not a reference
+func secretSource(env *micro.Env, name string, value string) func() string {
+	if !env.Secrets.IsReference(value) {
+		return nil
+	}
+	resolveSecret(env, name, value)
+	return env.Secrets.Source(value)
+}
+
 func setupLocales(env *micro.Env, cfg micro.Cfg) {
 	if cfg.AvailableLocales == nil && cfg.DefaultLocale == "" {
 		return
@@ -84,18 +182,94 @@ func setupLocales(env *micro.Env, cfg micro.Cfg) {
 
 }
 
-func getInitialTenants() []string {
-	tenants := strings.Split(h.RequireEnv(micro.DatabaseInitialTenants), ",")
+func getInitialTenants(env *micro.Env) []string {
+	tenants := env.Settings.DatabaseInitialTenants
+	if len(tenants) == 0 {
+		log.Fatalf("Missing env variable: %s", micro.DatabaseInitialTenants)
+	}
 	return funk.Map(tenants, func(tenant string) string {
 		parts := strings.Split(tenant, "|")
 		return parts[0]
 	}).([]string)
 }
 
+func setupMetrics(env *micro.Env, cfg micro.Cfg) {
+	if !cfg.EnableMetrics && !env.Settings.MetricsEnabled {
+		return
+	}
+	log.Infof("metrics enabled, exposing %s", micro.DefaultMetricsPath)
+	env.Metrics = micro.NewMetrics(metricsNamespace(env.AppName))
+}
+
+func setupTracing(env *micro.Env, cfg micro.Cfg) func() {
+	exporter := cfg.TraceExporter
+	if exporter == nil {
+		kind := env.Settings.TracesExporter
+		if kind == "" || kind == micro.TraceExporterNone {
+			return nil
+		}
+		if kind != micro.TraceExporterOtlp {
+			log.Fatalf("traces exporter not supported: %s", kind)
+		}
+		// endpoint, headers and protocol are read from the standard OTEL_EXPORTER_OTLP_* variables
+		otlp, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			log.Fatalf("error configuring otlp exporter: %s", err)
+		}
+		exporter = otlp
+	}
+	log.Infof("tracing enabled, exporting spans with %T", exporter)
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(env.AppName),
+		semconv.ServiceVersion(env.AppVersion),
+	))
+	if err != nil {
+		log.Warnf("unable to build tracing resource: %s", err)
+		res = resource.Default()
+	}
+	var processor sdktrace.SpanProcessor
+	if cfg.TraceExporter != nil {
+		// exporters given in code are mostly used in tests, spans must be visible right away
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+	} else {
+		processor = sdktrace.NewBatchSpanProcessor(exporter)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	env.Tracing = true
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			log.Errorf("error shutting down tracer provider: %s", err)
+		}
+	}
+}
+
+func metricsNamespace(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
 func prepareMultiTenancy(env *micro.Env, cfg micro.Cfg) {
 	var tenantLoader micro.TenantLoader
-	if cfg.MultiTenant {
-		defaultTenants := getInitialTenants()
+	if cfg.MultiTenant && cfg.TenantRegistry != nil {
+		tenantLoader = cfg.TenantRegistry
+	} else if cfg.MultiTenant && cfg.Tenancy == micro.TenancyDatabase {
+		getInitialTenants(env)
+		tenantLoader = micro.NewStaticTenantRegistry("", env.Settings.DatabaseInitialTenants...)
+	} else if cfg.MultiTenant {
+		defaultTenants := getInitialTenants(env)
 		tenantLoader = micro.NewFixedTenantLoader(defaultTenants)
 	} else {
 		tenantLoader = micro.NewFixedTenantLoader([]string{micro.DefaultTenantId})
@@ -103,12 +277,66 @@ func prepareMultiTenancy(env *micro.Env, cfg micro.Cfg) {
 	env.TenantLoader = tenantLoader
 }
 
+// tenantDatabaseUrl returns the url of a tenant database (TenancyDatabase): the one of the
+// tenant registry, or the database url with the tenant placeholder replaced. The shared
+// database of the default tenant is the database url itself.
+func tenantDatabaseUrl(env *micro.Env, databaseUrl string, urlSource func() string, tenant string) (string, func() string) {
+	if tenant == micro.DefaultTenantId && !strings.Contains(databaseUrl, micro.TenantUrlPlaceholder) {
+		return databaseUrl, urlSource
+	}
+	if registry, ok := env.TenantLoader.(micro.TenantRegistry); ok && tenant != micro.DefaultTenantId {
+		url, err := registry.TenantUrl(tenant)
+		if err != nil {
+			log.Fatalf("unable to get the database of tenant %s: %s", tenant, err)
+		}
+		if url != "" {
+			name := fmt.Sprintf("database url of tenant %s", tenant)
+			return resolveSecret(env, name, url), secretSource(env, name, url)
+		}
+	}
+	if !strings.Contains(databaseUrl, micro.TenantUrlPlaceholder) {
+		log.Fatalf("no database url for tenant %s: %s has no %s placeholder", tenant, micro.DatabaseUrl, micro.TenantUrlPlaceholder)
+	}
+	return strings.ReplaceAll(databaseUrl, micro.TenantUrlPlaceholder, tenant), tenantUrlSource(urlSource, tenant)
+}
+
+// tenantUrlSource replaces the tenant placeholder in the urls returned by urlSource
+func tenantUrlSource(urlSource func() string, tenant string) func() string {
+	if urlSource == nil {
+		return nil
+	}
+	return func() string {
+		return strings.ReplaceAll(urlSource(), micro.TenantUrlPlaceholder, tenant)
+	}
+}
+
 func setupDatabase(env *micro.Env, cfg micro.Cfg) {
-	databaseUrl := h.GetEnv(micro.DatabaseUrl)
+	databaseUrl := env.Settings.DatabaseUrl
 	if databaseUrl == "" {
 		return
 	}
+	urlSource := secretSource(env, micro.DatabaseUrl, databaseUrl)
+	databaseUrl = resolveSecret(env, micro.DatabaseUrl, databaseUrl)
 	log.Infof("env.%s detected, configuring database", micro.DatabaseUrl)
+	var replicas []string
+	var replicaSources []func() string
+	for _, replica := range env.Settings.DatabaseReplicaUrls {
+		replicaSources = append(replicaSources, secretSource(env, micro.DatabaseReplicaUrls, replica))
+		replicas = append(replicas, resolveSecret(env, micro.DatabaseReplicaUrls, replica))
+	}
+	if len(replicas) > 0 {
+		log.Infof("%d read replica(s) configured", len(replicas))
+	}
+	pool := micro.PoolCfg{
+		MaxOpenConns:    env.Settings.DatabaseMaxOpenConns,
+		MaxIdleConns:    env.Settings.DatabaseMaxIdleConns,
+		ConnMaxLifetime: env.Settings.DatabaseConnLifetime,
+		ConnMaxIdleTime: env.Settings.DatabaseConnIdleTime,
+	}
+	sharedPool := env.Settings.DatabaseSharedPool && cfg.MultiTenant
+	if sharedPool {
+		log.Infof("%s enabled, the tenants share one connection pool", micro.DatabaseSharedPool)
+	}
 	exists, migrationsFS := h.CheckFsFolder(cfg.FS, "db/migrations")
 	if !exists {
 		log.Error("no config/db/migrations found, skipping")
@@ -125,27 +353,186 @@ func setupDatabase(env *micro.Env, cfg micro.Cfg) {
 	tenants = append(tenants, micro.DefaultTenantId)
 
 	if cfg.MultiTenant {
+		tenancy := cfg.Tenancy
+		if tenancy == "" {
+			tenancy = micro.TenancySchema
+		}
+		log.Infof("multi-tenancy strategy: %s", tenancy)
 		for _, tenant := range tenants {
 			if _, ok := links[tenant]; ok {
 				continue
 			}
-			links[tenant] = NewGormAdapter(databaseUrl, tenant)
-			if tenant == micro.DefaultTenantId {
-				links[tenant].Migrate(migrationsFS, "shared", migrationsTable)
-			} else {
-				links[tenant].Migrate(migrationsFS, "tenant", migrationsTable)
+			dsCfg := micro.DataSourceCfg{
+				Url:              databaseUrl,
+				UrlSource:        urlSource,
+				Tenant:           tenant,
+				Metrics:          env.Metrics,
+				Tracing:          env.Tracing,
+				Replicas:         replicas,
+				ReplicaSources:   replicaSources,
+				Pool:             pool,
+				SharedPool:       sharedPool && tenancy == micro.TenancySchema,
+				Tenancy:          tenancy,
+				RowLevelSecurity: cfg.RowLevelSecurity,
 			}
+			if tenancy == micro.TenancyDatabase {
+				dsCfg.Url, dsCfg.UrlSource = tenantDatabaseUrl(env, databaseUrl, urlSource, tenant)
+				dsCfg.Replicas, dsCfg.ReplicaSources = nil, nil
+				for i, replica := range replicas {
+					dsCfg.Replicas = append(dsCfg.Replicas, strings.ReplaceAll(replica, micro.TenantUrlPlaceholder, tenant))
+					dsCfg.ReplicaSources = append(dsCfg.ReplicaSources, tenantUrlSource(replicaSources[i], tenant))
+				}
+			}
+			links[tenant] = NewGormDataSource(dsCfg)
 		}
+		env.Migrator = micro.NewMigrator(env, tenantMigrationPlan(migrationsFS, migrationsTable, tenancy, cfg.Migrations), env.Settings.MigrationConcurrency)
 	} else {
 		for _, tenant := range tenants {
 			if _, ok := links[tenant]; ok {
 				continue
 			}
-			links[tenant] = NewGormAdapter(databaseUrl, tenant)
-			links[tenant].Migrate(migrationsFS, ".", migrationsTable)
+			links[tenant] = NewGormDataSource(micro.DataSourceCfg{
+				Url:            databaseUrl,
+				UrlSource:      urlSource,
+				Tenant:         tenant,
+				Metrics:        env.Metrics,
+				Tracing:        env.Tracing,
+				Replicas:       replicas,
+				ReplicaSources: replicaSources,
+				Pool:           pool,
+				SharedPool:     sharedPool,
+			})
+		}
+		env.Migrator = micro.NewMigrator(env, func(string) []micro.MigrationSource {
+			return []micro.MigrationSource{{
+				FS:       migrationsFS,
+				Location: ".",
+				Table:    migrationsTable,
+				Go:       micro.GoMigrationsOf(cfg.Migrations, "."),
+			}}
+		}, env.Settings.MigrationConcurrency)
+	}
+	env.Seeds = micro.NewSeeds(env, seedPlan(cfg), cfg.TablePrefix+micro.DefaultSeedsTable, env.Settings.MigrationConcurrency)
+
+	if micro.IsCommand(micro.MigrateCommandName) {
+		// the migrate subcommand runs the migrations itself
+		return
+	}
+	reports := env.Migrator.Run(context.Background(), micro.MigrationOptions{Command: micro.MigrateUp})
+	failed, err := checkMigrations(env, reports)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if micro.IsCommand(micro.SeedCommandName) {
+		return
+	}
+	// the seeders expect the schema of the last migration
+	var seeded []string
+	if len(failed) > 0 {
+		for tenant := range env.DataSources {
+			if failed[tenant] {
+				log.Warnf("[%s] seeding skipped, the migrations failed", tenant)
+			} else {
+				seeded = append(seeded, tenant)
+			}
+		}
+	}
+	for _, report := range env.Seeds.Run(context.Background(), seeded...) {
+		if report.Err != nil {
+			log.Errorf("[%s] seeding failed: %s", report.Tenant, report.Err)
+		} else if len(report.Applied) > 0 {
+			log.Infof("[%s] seeders applied: %s", report.Tenant, strings.Join(report.Applied, ", "))
+		}
+	}
+}
+
+// checkMigrations reports the failed migrations and returns the failed tenants. The requests
+// of a tenant whose schema is not up to date are rejected, the other tenants are still
+// served. An error is returned when the shared datasource failed.
+func checkMigrations(env *micro.Env, reports []micro.MigrationReport) (map[string]bool, error) {
+	failed := map[string]bool{}
+	for _, report := range reports {
+		if report.Err == nil {
+			continue
+		}
+		log.Errorf("[%s] migrations of %s failed: %s", report.Tenant, report.Table, report.Err)
+		if report.Tenant == micro.DefaultTenantId {
+			return nil, fmt.Errorf("migrations of the shared datasource failed: %w", report.Err)
 		}
+		failed[report.Tenant] = true
+		migrationErr := fmt.Errorf("migrations of %s failed: %w", report.Table, report.Err)
+		env.Health.SetTenantUnavailable(report.Tenant, migrationErr)
+		env.Health.RegisterInformational("migrations:"+report.Tenant, func(ctx context.Context) error {
+			return migrationErr
+		})
 	}
+	return failed, nil
+}
 
+// seedPlan returns the seeders of the tenants: cfg.Seeders and the fixtures of
+// db/seeds/{shared,tenant} in multi-tenant mode (db/seeds otherwise)
+func seedPlan(cfg micro.Cfg) micro.SeedPlan {
+	locations := []string{"."}
+	if cfg.MultiTenant {
+		locations = []string{"shared", "tenant"}
+	}
+	seeders := map[string][]micro.Seeder{}
+	for _, location := range locations {
+		seeders[location] = micro.SeedersOf(cfg.Seeders, location)
+		if exists, seedsFS := h.CheckFsFolder(cfg.FS, "db/seeds"); exists {
+			var tenantColumn []string
+			if cfg.Tenancy == micro.TenancyRow && location == "tenant" {
+				// the tenants share the tables, the rows are inserted with their tenant
+				tenantColumn = append(tenantColumn, micro.TenantColumn)
+			}
+			fixtures, err := micro.LoadFixtures(seedsFS, location, tenantColumn...)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Fatalf("unable to load the fixtures of db/seeds/%s: %s", location, err)
+			}
+			seeders[location] = append(seeders[location], fixtures...)
+		}
+	}
+	return func(tenant string) []micro.Seeder {
+		switch {
+		case !cfg.MultiTenant:
+			return seeders["."]
+		case tenant == micro.DefaultTenantId:
+			return seeders["shared"]
+		default:
+			return seeders["tenant"]
+		}
+	}
+}
+
+// tenantMigrationPlan returns the migrations of the tenants in multi-tenant mode: the
+// shared folder for the shared datasource and the tenant folder for the tenants. With
+// row tenancy, the tables of all the tenants are in the shared database.
+func tenantMigrationPlan(migrationsFS fs.FS, migrationsTable string, tenancy micro.TenancyStrategy, migrations []micro.GoMigration) micro.MigrationPlan {
+	return func(tenant string) []micro.MigrationSource {
+		shared := micro.MigrationSource{
+			FS:       migrationsFS,
+			Location: "shared",
+			Table:    migrationsTable,
+			Go:       micro.GoMigrationsOf(migrations, "shared"),
+		}
+		tenantSource := micro.MigrationSource{
+			FS:       migrationsFS,
+			Location: "tenant",
+			Table:    migrationsTable,
+			Go:       micro.GoMigrationsOf(migrations, "tenant"),
+		}
+		switch {
+		case tenancy == micro.TenancyRow && tenant == micro.DefaultTenantId:
+			tenantSource.Table += "_tenant"
+			return []micro.MigrationSource{shared, tenantSource}
+		case tenancy == micro.TenancyRow:
+			return nil
+		case tenant == micro.DefaultTenantId:
+			return []micro.MigrationSource{shared}
+		default:
+			return []micro.MigrationSource{tenantSource}
+		}
+	}
 }
 
 func setupScheduler(env *micro.Env) {
@@ -153,15 +540,20 @@ func setupScheduler(env *micro.Env) {
 }
 
 func setupMailer(env *micro.Env) {
-	config := h.GetEnv(micro.EmailSender, "MAILER")
+	config := env.Settings.EmailSender
 	if config == "" {
 		return
 	}
 	log.Infof("env.%s found, configuring mailer", micro.EmailSender)
 	var mailer micro.Mailer
-	mailerConfig := strings.Split(config, "://")
+	mailerConfig := strings.SplitN(resolveSecret(env, micro.EmailSender, config), "://", 2)
 	if mailerConfig[0] == "sendgrid" {
-		mailer = NewSendGridEmailSender(mailerConfig[1])
+		// both sendgrid://<ref> and a reference to sendgrid://<apikey> are supported
+		resolveSecret(env, micro.EmailSender, mailerConfig[1])
+		mailer = NewRotatingSendGridEmailSender(func() string {
+			apikey := strings.TrimPrefix(env.Secrets.Get(config), "sendgrid://")
+			return env.Secrets.Get(apikey)
+		})
 	} else if mailerConfig[0] == "fake" {
 		mailer = NewFakeEmailSender()
 	} else {
@@ -172,10 +564,11 @@ func setupMailer(env *micro.Env) {
 
 func setupNotifications(env *micro.Env) {
 
-	config := h.GetEnv(micro.NotificationSender)
+	config := env.Settings.NotificationSender
 	if config == "" {
 		return
 	}
+	config = resolveSecret(env, micro.NotificationSender, config)
 
 	log.Infof("env.%s found, configuring...", micro.NotificationSender)
 	var service micro.NotificationService
@@ -193,29 +586,43 @@ func setupNotifications(env *micro.Env) {
 }
 
 func setupTokenProvider(env *micro.Env) {
-	secret := h.GetEnv(micro.ServerToken)
+	secret := env.Settings.ServerToken
 	if secret == "" {
 		return
 	}
 	log.Infof("env.%s detected, configuring token provider", micro.ServerToken)
-	env.TokenProvider = micro.NewJwtTokenProvider(secret)
+	if source := secretSource(env, micro.ServerToken, secret); source != nil {
+		env.TokenProvider = micro.NewRotatingJwtTokenProvider(source)
+	} else {
+		env.TokenProvider = micro.NewJwtTokenProvider(secret)
+	}
 }
 
 func setupRedis(env *micro.Env, cfg micro.Cfg) {
-	redisUrl := h.GetEnv(micro.RedisUrl)
+	redisUrl := env.Settings.RedisUrl
 	if redisUrl == "" {
 		return
 	}
 	log.Infof("env.%s detected, configuring redis client", micro.RedisUrl)
-	opts, err := redis.ParseURL(redisUrl)
+	opts, err := redis.ParseURL(resolveSecret(env, micro.RedisUrl, redisUrl))
 	if err != nil {
 		log.Fatalf("error configuring redis: %s", err)
 	}
+	if source := secretSource(env, micro.RedisUrl, redisUrl); source != nil {
+		opts.CredentialsProvider = func() (string, string) {
+			latest, err := redis.ParseURL(source())
+			if err != nil {
+				log.Errorf("invalid %s: %s", micro.RedisUrl, err)
+				return opts.Username, opts.Password
+			}
+			return latest.Username, latest.Password
+		}
+	}
 	rdb := redis.NewClient(opts)
 	env.RedisClient = rdb
 	if cfg.EnableDiscovery {
 		env.DiscoverySericeName = micro.DiscoveryServicePrefix + env.AppName
-		hostname := h.GetEnv("APP_PRIVATE_DOMAIN", "APP_DOMAIN", "RAILWAY_PRIVATE_DOMAIN", "RAILWAY_PUBLIC_DOMAIN")
+		hostname := env.Settings.PrivateDomain
 		if hostname == "" {
 			hostname = "localhost"
 		}
@@ -224,6 +631,91 @@ func setupRedis(env *micro.Env, cfg micro.Cfg) {
 	}
 }
 
+func setupAudit(env *micro.Env, cfg micro.Cfg) {
+	if !cfg.Audit && !env.Settings.AuditEnabled {
+		return
+	}
+	if env.DataSources == nil {
+		log.Fatalf("audit requires %s", micro.DatabaseUrl)
+	}
+	redact := cfg.AuditRedact
+	if len(env.Settings.AuditRedact) > 0 {
+		redact = env.Settings.AuditRedact
+	}
+	env.Audit = micro.NewAuditLog(cfg.TablePrefix+micro.DefaultAuditTable, redact...)
+	log.Infof("audit enabled, changes recorded in %s", env.Audit.Table)
+}
+
+func setupFlags(env *micro.Env, cfg micro.Cfg) func() {
+	flags := micro.NewFlagRegistry()
+	flags.RefreshInterval = env.Settings.FlagsRefreshInterval
+	flags.Define(cfg.Flags...)
+	env.Flags = flags
+
+	sources := cfg.FlagSources
+	if len(env.Settings.FlagSources) > 0 {
+		sources = env.Settings.FlagSources
+	}
+	for _, source := range sources {
+		switch source {
+		case micro.FlagSourceFile:
+			file := ""
+			for _, candidate := range []string{"flags.yaml", "flags.yml", "flags.toml", "flags.json"} {
+				if _, err := fs.Stat(cfg.FS, candidate); err == nil {
+					file = candidate
+					break
+				}
+			}
+			if file == "" {
+				log.Fatalf("feature flags file not found (flags.{yaml,yml,toml,json})")
+			}
+			flags.AddSource(micro.FileFlagSource{FS: cfg.FS, Path: file})
+		case micro.FlagSourceDB:
+			if env.DataSources == nil {
+				log.Fatalf("feature flags source %s requires %s", source, micro.DatabaseUrl)
+			}
+			flags.AddSource(micro.DataSourceFlagSource{
+				DB:    env.SharedDB(),
+				Table: cfg.TablePrefix + micro.DefaultFlagsTable,
+			})
+		case micro.FlagSourceRedis:
+			if env.RedisClient == nil {
+				log.Fatalf("feature flags source %s requires %s", source, micro.RedisUrl)
+			}
+			flags.AddSource(micro.NewRedisFlagSource(env.RedisClient))
+		default:
+			log.Fatalf("feature flags source not supported: %s", source)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if len(sources) > 0 {
+		if err := flags.Reload(ctx); err != nil {
+			log.Errorf("unable to load feature flags: %s", err)
+		}
+		flags.Watch(ctx)
+		log.Infof("feature flags loaded from %s", strings.Join(sources, ","))
+	}
+	return cancel
+}
+
+func setupHealthChecks(env *micro.Env) {
+	for tenant, ds := range env.DataSources {
+		db := ds
+		env.Health.Register("db:"+tenant, func(ctx context.Context) error {
+			return db.WithContext(ctx).Ping()
+		})
+	}
+	if env.RedisClient != nil {
+		env.Health.Register("redis", func(ctx context.Context) error {
+			return env.RedisClient.Ping(ctx).Err()
+		})
+	}
+	if checker, ok := env.Mailer.(micro.HealthChecker); ok {
+		env.Health.RegisterInformational("mailer", checker.HealthCheck)
+	}
+}
+
 func setupRouter(env *micro.Env, cfg micro.Cfg) micro.Router {
 
 	if cfg.DisableRouter {
@@ -232,7 +724,7 @@ func setupRouter(env *micro.Env, cfg micro.Cfg) micro.Router {
 	corsEnabled := true
 	if cfg.CorsDisabled {
 		corsEnabled = false
-	} else if h.GetEnv("CORS_DISABLED") == "true" {
+	} else if env.Settings.CorsDisabled {
 		corsEnabled = false
 	}
 
@@ -240,7 +732,7 @@ func setupRouter(env *micro.Env, cfg micro.Cfg) micro.Router {
 		env,
 		micro.RouterConfig{
 			Cors:                       corsEnabled,
-			SentryDsn:                  h.GetEnv("SENTRY_DSN"),
+			SentryDsn:                  resolveSecret(env, "SENTRY_DSN", env.Settings.SentryDsn),
 			RemoveTrailSlash:           true,
 			BasePath:                   cfg.BasePath,
 			DisableImplicitTransaction: cfg.DisableImplicitTransaction,
@@ -250,6 +742,11 @@ func setupRouter(env *micro.Env, cfg micro.Cfg) micro.Router {
 			TokenProvider:              env.TokenProvider,
 			DisableJwtFilter:           cfg.DisableJwtFilter,
 			MultiTenant:                cfg.MultiTenant,
+			Prometheus: &micro.PrometheusCfg{
+				Enabled: env.Metrics != nil,
+				Path:    micro.DefaultMetricsPath,
+			},
+			Tracing: env.Tracing,
 		})
 
 }
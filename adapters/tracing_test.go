@@ -0,0 +1,95 @@
+package adapters
+
+import (
+	"context"
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/soffa-projects/go-micro/tests"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type tracedEntry struct {
+	Id string `gorm:"primaryKey"`
+}
+
+func TestTracing(t *testing.T) {
+	tests.UseInMemoryDatabase()
+	cfg := micro.Cfg{DisableJwtFilter: true}
+	exporter := tests.UseInMemoryTracing(&cfg)
+	app := NewApp("tracing", "1.0.0", cfg)
+	defer app.Env.Close()
+	_, err := app.Env.DataSources[micro.DefaultTenantId].Raw(micro.Query{Raw: "create table traced_entries (id text primary key)"})
+	assert.Nil(t, err)
+
+	var handled context.Context
+	assert.Nil(t, micro.Subscribe("entries.created", func(ctx micro.Ctx, payload micro.Event) error {
+		handled = ctx.Context()
+		return nil
+	}))
+	defer micro.Reset()
+	app.Router.POST("/entries", func(ctx micro.Ctx) (any, error) {
+		if err := ctx.CurrentDB().Create(&tracedEntry{Id: "e1"}); err != nil {
+			return nil, err
+		}
+		deadline, cancel := context.WithTimeout(ctx.Context(), time.Minute)
+		defer cancel()
+		micro.Publish(ctx.WithContext(deadline), "entries.created", micro.Event{Event: "created"})
+		return nil, nil
+	})
+	exporter.Reset()
+	rec := httptest.NewRecorder()
+	app.Router.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/entries", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the synchronous handler keeps the deadline of the publisher
+	assert.NotNil(t, handled)
+	_, ok := handled.Deadline()
+	assert.True(t, ok)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	server, ok := spans["POST /entries"]
+	assert.True(t, ok)
+	for _, name := range []string{"gorm.create", "event entries.created"} {
+		span, ok := spans[name]
+		if assert.True(t, ok, name) {
+			assert.Equal(t, server.SpanContext.TraceID(), span.SpanContext.TraceID(), name)
+			assert.Equal(t, server.SpanContext.SpanID(), span.Parent.SpanID(), name)
+		}
+	}
+}
+
+func TestAsyncEvents(t *testing.T) {
+	ds := NewGormAdapter("file:async_events?mode=memory&cache=shared", "async_events")
+	defer ds.Close()
+	_, err := ds.Raw(micro.Query{Raw: "create table traced_entries (id text primary key)"})
+	assert.Nil(t, err)
+	env := &micro.Env{DataSources: map[string]micro.DataSource{micro.DefaultTenantId: ds}}
+	ctx := micro.NewCtx(env, micro.DefaultTenantId)
+
+	committed := make(chan struct{})
+	var count int64
+	var countErr error
+	assert.Nil(t, micro.SubscribeAsync("entries.committed", func(ctx micro.Ctx, payload micro.Event) error {
+		<-committed
+		count, countErr = ctx.CurrentDB().Count(&tracedEntry{}, micro.Query{})
+		return countErr
+	}))
+	defer micro.Reset()
+	assert.Nil(t, ctx.Tx(func(tx micro.Ctx) error {
+		micro.Publish(tx, "entries.committed", micro.Event{Event: "created"})
+		return tx.CurrentDB().Create(&tracedEntry{Id: "e1"})
+	}))
+	close(committed)
+	micro.WaitAsync()
+
+	// the async handler runs after the commit, on the datasource instead of the transaction
+	assert.Nil(t, countErr)
+	assert.Equal(t, int64(1), count)
+}
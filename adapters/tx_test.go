@@ -0,0 +1,289 @@
+package adapters
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/soffa-projects/go-micro/di"
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/soffa-projects/go-micro/util/h"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type txEntry struct {
+	Id string `gorm:"primaryKey"`
+}
+
+type txScoped struct {
+	ctx micro.Ctx
+}
+
+func TestTransactions(t *testing.T) {
+	ds := NewGormAdapter("file:transactions?mode=memory&cache=shared", "transactions")
+	defer ds.Close()
+	_, err := ds.Raw(micro.Query{Raw: "create table tx_entries (id text primary key)"})
+	assert.Nil(t, err)
+	env := &micro.Env{DataSources: map[string]micro.DataSource{micro.DefaultTenantId: ds}}
+	ctx := micro.NewCtx(env, micro.DefaultTenantId)
+	count := func() int64 {
+		n, _ := ds.Count(&txEntry{}, micro.Query{})
+		return n
+	}
+
+	attempts := 0
+	err = ctx.Tx(func(tx micro.Ctx) error {
+		attempts++
+		if err := tx.CurrentDB().Create(&txEntry{Id: "retried"}); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return &micro.SerializationFailure{Err: errors.New("could not serialize access")}
+		}
+		return nil
+	}, micro.TxOptions{Retries: 3, Backoff: time.Millisecond})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, int64(1), count())
+
+	// the retries are opt-in
+	attempts = 0
+	err = ctx.Tx(func(tx micro.Ctx) error {
+		attempts++
+		return &micro.Deadlock{}
+	})
+	assert.True(t, micro.IsRetryable(err))
+	assert.Equal(t, 1, attempts)
+
+	err = ctx.Tx(func(tx micro.Ctx) error {
+		if err := tx.CurrentDB().Create(&txEntry{Id: "outer"}); err != nil {
+			return err
+		}
+		nested := tx.Tx(func(sp micro.Ctx) error {
+			if err := sp.CurrentDB().Create(&txEntry{Id: "nested"}); err != nil {
+				return err
+			}
+			return errors.New("rolled back to the savepoint")
+		})
+		assert.NotNil(t, nested)
+		return nil
+	}, micro.TxOptions{Isolation: sql.LevelSerializable})
+	assert.Nil(t, err)
+	exists, _ := ds.Exists(&txEntry{}, micro.Query{W: "id = ?", Args: []any{"nested"}})
+	assert.False(t, exists)
+	assert.Equal(t, int64(2), count())
+
+	router := NewEchoAdapter(env, micro.RouterConfig{})
+	router.POST("/entries", func(ctx micro.Ctx) (any, error) {
+		if err := ctx.CurrentDB().Create(&txEntry{Id: "route"}); err != nil {
+			return nil, err
+		}
+		return nil, &micro.Deadlock{}
+	}, micro.WithTx(micro.TxOptions{Retries: 1, Backoff: time.Millisecond}))
+	rec := httptest.NewRecorder()
+	router.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/entries", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, int64(2), count())
+
+	// each attempt has its own request scope, bound to its transaction
+	env.Container = di.New()
+	assert.Nil(t, di.Declare[micro.Ctx](env.Container))
+	assert.Nil(t, env.Container.Provide(func(ctx micro.Ctx) *txScoped {
+		return &txScoped{ctx: ctx}
+	}, di.PerRequest()))
+	attempts = 0
+	router.POST("/scoped", func(ctx micro.Ctx) (any, error) {
+		attempts++
+		scoped, err := micro.Inject[*txScoped](ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := scoped.ctx.CurrentDB().Create(&txEntry{Id: fmt.Sprintf("scoped-%d", attempts)}); err != nil {
+			return nil, err
+		}
+		if attempts == 1 {
+			return nil, &micro.Deadlock{}
+		}
+		return nil, nil
+	}, micro.WithTx(micro.TxOptions{Retries: 1, Backoff: time.Millisecond}))
+	rec = httptest.NewRecorder()
+	router.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/scoped", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, attempts)
+	exists, _ = ds.Exists(&txEntry{}, micro.Query{W: "id = ?", Args: []any{"scoped-2"}})
+	assert.True(t, exists)
+	assert.Equal(t, int64(3), count())
+
+	// the errors raised with a panic are retried too
+	attempts = 0
+	router.POST("/raised", func(ctx micro.Ctx) (any, error) {
+		attempts++
+		if attempts == 1 {
+			h.RaiseAny(&micro.Deadlock{})
+		}
+		return nil, nil
+	}, micro.WithTx(micro.TxOptions{Retries: 1, Backoff: time.Millisecond}))
+	rec = httptest.NewRecorder()
+	router.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/raised", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, attempts)
+	attempts = 0
+	failure := &micro.SerializationFailure{}
+	assert.PanicsWithValue(t, failure, func() {
+		_ = ctx.Tx(func(tx micro.Ctx) error {
+			attempts++
+			h.RaiseAny(failure)
+			return nil
+		}, micro.TxOptions{Retries: 2, Backoff: time.Millisecond})
+	})
+	assert.Equal(t, 3, attempts)
+
+	err = ctx.Tx(func(tx micro.Ctx) error {
+		<-tx.Context().Done()
+		return tx.Context().Err()
+	}, micro.TxOptions{Timeout: 10 * time.Millisecond})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestTransactionHooks(t *testing.T) {
+	ds := NewGormAdapter("file:transaction_hooks?mode=memory&cache=shared", "transaction_hooks")
+	defer ds.Close()
+	_, err := ds.Raw(micro.Query{Raw: "create table tx_entries (id text primary key)"})
+	assert.Nil(t, err)
+	env := &micro.Env{DataSources: map[string]micro.DataSource{micro.DefaultTenantId: ds}}
+	ctx := micro.NewCtx(env, micro.DefaultTenantId)
+	var events []string
+	record := func(event string) func() {
+		return func() {
+			events = append(events, event)
+		}
+	}
+
+	err = ctx.Tx(func(tx micro.Ctx) error {
+		tx.AfterCommit(record("committed"))
+		tx.AfterRollback(record("rolled back"))
+		_ = tx.Tx(func(sp micro.Ctx) error {
+			sp.AfterCommit(record("savepoint committed"))
+			sp.AfterRollback(record("savepoint rolled back"))
+			return errors.New("savepoint failure")
+		})
+		assert.Equal(t, []string{"savepoint rolled back"}, events)
+		return tx.Tx(func(sp micro.Ctx) error {
+			sp.AfterCommit(record("released savepoint committed"))
+			return nil
+		})
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"savepoint rolled back", "committed", "released savepoint committed"}, events)
+
+	events = nil
+	attempts := 0
+	err = ctx.Tx(func(tx micro.Ctx) error {
+		attempts++
+		tx.AfterCommit(record("committed"))
+		tx.AfterRollback(record("rolled back"))
+		if attempts == 1 {
+			return &micro.Deadlock{}
+		}
+		return nil
+	}, micro.TxOptions{Retries: 1, Backoff: time.Millisecond})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"committed"}, events)
+
+	// the rollback hooks only run once the retries are exhausted
+	events = nil
+	attempts = 0
+	err = ctx.Tx(func(tx micro.Ctx) error {
+		attempts++
+		tx.AfterRollback(record(fmt.Sprintf("rolled back %d", attempts)))
+		return &micro.Deadlock{}
+	}, micro.TxOptions{Retries: 2, Backoff: time.Millisecond})
+	assert.True(t, micro.IsRetryable(err))
+	assert.Equal(t, []string{"rolled back 3"}, events)
+
+	events = nil
+	ctx.AfterCommit(record("no transaction"))
+	ctx.AfterRollback(record("never"))
+	assert.Equal(t, []string{"no transaction"}, events)
+
+	events = nil
+	router := NewEchoAdapter(env, micro.RouterConfig{})
+	router.POST("/entries", func(ctx micro.Ctx) (any, error) {
+		ctx.AfterCommit(record("created"))
+		ctx.AfterRollback(record("rolled back"))
+		return nil, ctx.CurrentDB().Create(&txEntry{Id: "e1"})
+	})
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		router.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/entries", nil))
+	}
+	assert.Equal(t, []string{"created", "rolled back"}, events)
+}
+
+func TestRouteTxModes(t *testing.T) {
+	ds := NewGormAdapter("file:route_tx_modes?mode=memory&cache=shared", "route_tx_modes")
+	defer ds.Close()
+	_, err := ds.Raw(micro.Query{Raw: "create table tx_entries (id text primary key)"})
+	assert.Nil(t, err)
+	env := &micro.Env{DataSources: map[string]micro.DataSource{micro.DefaultTenantId: ds}, Metrics: micro.NewMetrics("test")}
+	router := NewEchoAdapter(env, micro.RouterConfig{Prometheus: &micro.PrometheusCfg{Enabled: true}})
+	failing := func(id string) func(ctx micro.Ctx) (any, error) {
+		return func(ctx micro.Ctx) (any, error) {
+			if err := ctx.CurrentDB().Create(&txEntry{Id: id}); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("failure after the write")
+		}
+	}
+	router.GET("/entries", failing("get"))
+	router.POST("/entries", failing("post"))
+	router.DELETE("/entries", func(ctx micro.Ctx) (any, error) {
+		return nil, ctx.CurrentDB().Create(&txEntry{Id: "read-only"})
+	}, micro.ReadOnlyTx())
+	router.Group("/batch", micro.NoTx()).POST("/entries", failing("batch"))
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		router.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/entries", nil))
+	}
+	// the write of a handler succeeding in a read-only transaction is rejected
+	rec := httptest.NewRecorder()
+	router.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/entries", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	router.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/batch/entries", nil))
+
+	var entries []txEntry
+	assert.Nil(t, ds.Find(&entries, micro.Query{Sort: "id"}))
+	assert.Equal(t, []txEntry{{Id: "batch"}, {Id: "get"}}, entries)
+
+	// SQL Server rejects the read-only transactions, they run as read-write ones
+	readOnly := []micro.TxOptions{{ReadOnly: true}}
+	assert.Equal(t, []*sql.TxOptions{{ReadOnly: true}}, sqlTxOptions(micro.DialectPostgres, readOnly))
+	assert.Empty(t, sqlTxOptions(micro.DialectSQLServer, readOnly))
+	assert.Equal(t, []*sql.TxOptions{{Isolation: sql.LevelSerializable}},
+		sqlTxOptions(micro.DialectSQLServer, []micro.TxOptions{{Isolation: sql.LevelSerializable, ReadOnly: true}}))
+
+	families, err := env.Metrics.Registry.Gather()
+	assert.Nil(t, err)
+	modes := map[string]string{}
+	for _, family := range families {
+		if family.GetName() != "test_http_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			modes[labels["method"]+" "+labels["route"]] = labels["tx"]
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"GET /entries":        "none",
+		"POST /entries":       "read_write",
+		"DELETE /entries":     "read_only",
+		"POST /batch/entries": "none",
+	}, modes)
+}
@@ -0,0 +1,72 @@
+package adapters
+
+import (
+	"github.com/soffa-projects/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type importedProduct struct {
+	Id    string `gorm:"primaryKey"`
+	Sku   string
+	Name  string
+	Price int
+}
+
+func TestUpsertAndImport(t *testing.T) {
+	ds := NewGormAdapter("file:upsert?mode=memory&cache=shared", "upsert")
+	defer ds.Close()
+	_, err := ds.Raw(micro.Query{Raw: "create table imported_products (id text primary key, sku text unique, name text, price integer)"})
+	assert.Nil(t, err)
+
+	env := &micro.Env{DataSources: map[string]micro.DataSource{"upsert": ds}}
+	ctx := micro.NewCtx(env, "upsert")
+	repo := micro.NewRepoImpl[importedProduct](func(e *importedProduct) {}).WithBatchSize(2)
+
+	assert.Nil(t, repo.CreateAll(ctx, []*importedProduct{
+		{Id: "p1", Sku: "A", Name: "a", Price: 1},
+		{Id: "p2", Sku: "B", Name: "b", Price: 2},
+		{Id: "p3", Sku: "C", Name: "c", Price: 3},
+	}))
+
+	assert.Nil(t, repo.UpsertAll(ctx, []*importedProduct{
+		{Id: "p4", Sku: "A", Name: "a2", Price: 10},
+		{Id: "p5", Sku: "D", Name: "d", Price: 4},
+	}, micro.UpsertOptions{Conflict: []string{"sku"}, Update: []string{"price"}}))
+	a, _ := repo.FirstBy(ctx, "sku = ?", "A")
+	assert.Equal(t, "p1", a.Id)
+	assert.Equal(t, "a", a.Name)
+	assert.Equal(t, 10, a.Price)
+
+	assert.Nil(t, repo.Upsert(ctx, &importedProduct{Id: "p2", Sku: "B", Name: "b2", Price: 20}, micro.UpsertOptions{}))
+	b, _ := repo.FindById(ctx, "p2")
+	assert.Equal(t, "b2", b.Name)
+
+	assert.Nil(t, repo.Upsert(ctx, &importedProduct{Id: "p3", Sku: "C", Name: "c2"}, micro.UpsertOptions{DoNothing: true}))
+	c, _ := repo.FindById(ctx, "p3")
+	assert.Equal(t, "c", c.Name)
+
+	items := []*importedProduct{
+		{Id: "x", Sku: "A", Name: "a3", Price: 100},
+		{Id: "p6", Sku: "E", Name: "e"},
+		{Id: "p7", Sku: "F", Name: "f"},
+		{Id: "y", Sku: "E", Name: "e-duplicate"},
+	}
+	bySku := func(item *importedProduct) string { return item.Sku }
+	result, err := repo.ImportWith(ctx, items, micro.ImportOptions[importedProduct]{Key: "sku", KeyOf: bySku, ChunkSize: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, micro.ImportResult{Created: 2, Skipped: 2}, result)
+
+	items[0].Id = "p1"
+	result, err = repo.ImportWith(ctx, items[:1], micro.ImportOptions[importedProduct]{Key: "sku", KeyOf: bySku, Update: true})
+	assert.Nil(t, err)
+	assert.Equal(t, micro.ImportResult{Updated: 1}, result)
+	a, _ = repo.FirstBy(ctx, "sku = ?", "A")
+	assert.Equal(t, "a3", a.Name)
+
+	created, err := repo.Import(ctx, []*importedProduct{{Id: "p1", Sku: "Z"}, {Id: "p8", Sku: "G"}}, func(item *importedProduct) string { return item.Id })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, created)
+	count, _ := repo.CountAll(ctx)
+	assert.Equal(t, int64(7), count)
+}
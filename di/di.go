@@ -1,68 +1,548 @@
 package di
 
 import (
+	"context"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 )
 
-/*
-var c = dig.New()
+// Component is the type returned by the deprecated Feature.Init
+type Component interface {
+}
+
+type Lifetime int
+
+const (
+	// Singleton components are created once per container
+	Singleton Lifetime = iota
+	// Request components are created once per Scope (i.e. per http request)
+	Request
+)
+
+func (l Lifetime) String() string {
+	if l == Request {
+		return "request"
+	}
+	return "singleton"
+}
+
+// Starter is implemented by the singletons that must run code when the application starts
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// Stopper is implemented by the components that must release resources when their
+// container (or scope) is stopped
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+type Option func(p *provider)
+
+// PerRequest registers a component created once per request scope
+func PerRequest() Option {
+	return func(p *provider) {
+		p.lifetime = Request
+	}
+}
+
+type provider struct {
+	out        reflect.Type
+	in         []reflect.Type
+	ctor       reflect.Value
+	value      reflect.Value
+	lifetime   Lifetime
+	returnsErr bool
+	// declared components have no constructor, their value is set on each Scope
+	declared bool
+}
 
-func Provide(provider interface{}) error {
-	return c.Provide(provider)
+func (p *provider) build(args []reflect.Value) (reflect.Value, error) {
+	if p.value.IsValid() {
+		return p.value, nil
+	}
+	results := p.ctor.Call(args)
+	if p.returnsErr && !results[1].IsNil() {
+		return reflect.Value{}, fmt.Errorf("unable to create %s: %w", p.out, results[1].Interface().(error))
+	}
+	return results[0], nil
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+func newProvider(constructor any, opts []Option) (*provider, error) {
+	ctor := reflect.ValueOf(constructor)
+	if ctor.Kind() != reflect.Func {
+		return nil, fmt.Errorf("constructor must be a function, got %T", constructor)
+	}
+	t := ctor.Type()
+	if t.IsVariadic() {
+		return nil, fmt.Errorf("variadic constructors are not supported: %s", t)
+	}
+	p := &provider{ctor: ctor}
+	switch {
+	case t.NumOut() == 1 && t.Out(0) != errorType:
+	case t.NumOut() == 2 && t.Out(1) == errorType:
+		p.returnsErr = true
+	default:
+		return nil, fmt.Errorf("constructor must return T or (T, error): %s", t)
+	}
+	p.out = t.Out(0)
+	for i := 0; i < t.NumIn(); i++ {
+		p.in = append(p.in, t.In(i))
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p, nil
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// Resolver is implemented by Container (singletons) and Scope (singletons and request components)
+type Resolver interface {
+	resolve(t reflect.Type, path []reflect.Type) (reflect.Value, error)
 }
 
-func Overwrite(decorator interface{}) error {
-	return c.Decorate(decorator)
+// ----------------------------------------------------------------------------------------------------------------------
+// CONTAINER
+// ----------------------------------------------------------------------------------------------------------------------
+
+// Container creates the components from their constructors, dependencies are the
+// constructor arguments and are resolved by type.
+type Container struct {
+	mu        sync.Mutex
+	providers map[reflect.Type]*provider
+	order     []reflect.Type
+	instances map[reflect.Type]reflect.Value
+	created   []reflect.Value
+}
+
+func New() *Container {
+	return &Container{
+		providers: map[reflect.Type]*provider{},
+		instances: map[reflect.Type]reflect.Value{},
+	}
 }
 
-func Inject(function interface{}) error {
-	return c.Invoke(func(env *micro.Env) error {
-		_, err := micro.Invoke(env, func() (interface{}, error) {
-			err := c.Invoke(function)
-			return nil, err
-		})
+// Provide registers a constructor: func(deps...) T or func(deps...) (T, error)
+func (c *Container) Provide(constructor any, opts ...Option) error {
+	p, err := newProvider(constructor, opts)
+	if err != nil {
 		return err
-	})
+	}
+	return c.add(p, false)
 }
 
-func Reset() {
-	c = dig.New()
+// Supply registers an existing value under its dynamic type
+func (c *Container) Supply(value any) error {
+	if value == nil {
+		return fmt.Errorf("unable to supply a nil value")
+	}
+	v := reflect.ValueOf(value)
+	return c.add(&provider{out: v.Type(), value: v}, false)
 }
-*/
 
-type Component interface {
+// SupplyAs registers an existing value under T, e.g. an implementation under its interface
+func SupplyAs[T any](c *Container, value T) error {
+	return c.add(&provider{out: typeOf[T](), value: reflect.ValueOf(&value).Elem()}, false)
 }
 
-var registry = make(map[string]any)
+// Declare tells the container that T is given to each Scope with Scope.Set
+func Declare[T any](c *Container) error {
+	return c.add(&provider{out: typeOf[T](), lifetime: Request, declared: true}, false)
+}
 
-func Register(name string, provider interface{}) {
-	registry[name] = provider
+// Override replaces the constructor of a component, it is meant to be used in tests
+func (c *Container) Override(constructor any, opts ...Option) error {
+	p, err := newProvider(constructor, opts)
+	if err != nil {
+		return err
+	}
+	return c.add(p, true)
 }
 
-func Resolve[T Component](typ T) *T {
-	rtype := reflect.TypeOf(typ)
-	for _, component := range registry {
-		cr := reflect.TypeOf(component)
-		if cr == rtype {
-			return component.(*T)
+// OverrideAs replaces a component by value, it is meant to be used in tests
+func OverrideAs[T any](c *Container, value T) error {
+	return c.add(&provider{out: typeOf[T](), value: reflect.ValueOf(&value).Elem()}, true)
+}
+
+func (c *Container) add(p *provider, override bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.providers[p.out]; exists {
+		if !override {
+			return fmt.Errorf("%s is already provided", p.out)
 		}
-		if cr.Kind() == reflect.Ptr && cr.Elem() == rtype {
-			return component.(*T)
+		delete(c.instances, p.out)
+	} else {
+		c.order = append(c.order, p.out)
+	}
+	c.providers[p.out] = p
+	return nil
+}
+
+// Validate checks that every dependency is provided, that no singleton depends on a
+// request component and that there is no cycle
+func (c *Container) Validate() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []string
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[reflect.Type]int{}
+	var visit func(t reflect.Type, path []reflect.Type)
+	visit = func(t reflect.Type, path []reflect.Type) {
+		switch state[t] {
+		case done:
+			return
+		case visiting:
+			errs = append(errs, fmt.Sprintf("dependency cycle: %s", formatPath(append(path, t))))
+			return
+		}
+		state[t] = visiting
+		p := c.providers[t]
+		for _, dep := range p.in {
+			depProvider, ok := c.providers[dep]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("missing dependency %s of %s", dep, t))
+				continue
+			}
+			if p.lifetime == Singleton && depProvider.lifetime == Request {
+				errs = append(errs, fmt.Sprintf("singleton %s depends on request component %s", t, dep))
+				continue
+			}
+			visit(dep, append(path, t))
+		}
+		state[t] = done
+	}
+	for _, t := range c.order {
+		visit(t, nil)
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("invalid container: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// resolve creates the dependencies and the component without holding the lock, so that a
+// constructor may resolve other components. When two goroutines create the same singleton,
+// the first stored instance wins.
+func (c *Container) resolve(t reflect.Type, path []reflect.Type) (reflect.Value, error) {
+	c.mu.Lock()
+	instance, found := c.instances[t]
+	p, ok := c.providers[t]
+	c.mu.Unlock()
+	if found {
+		return instance, nil
+	}
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("missing dependency %s", formatPath(append(path, t)))
+	}
+	if p.lifetime == Request {
+		return reflect.Value{}, fmt.Errorf("%s is a request component, it must be resolved from a Scope", t)
+	}
+	if err := checkCycle(t, path); err != nil {
+		return reflect.Value{}, err
+	}
+	args := make([]reflect.Value, len(p.in))
+	for i, dep := range p.in {
+		arg, err := c.resolve(dep, append(path, t))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		args[i] = arg
+	}
+	instance, err := p.build(args)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.instances[t]; ok {
+		return existing, nil
+	}
+	c.instances[t] = instance
+	if !p.value.IsValid() {
+		c.created = append(c.created, instance)
+	}
+	return instance, nil
+}
+
+// Invoke calls fn with its arguments resolved from the container, the error returned by fn (if any) is returned
+func (c *Container) Invoke(fn any) error {
+	return invoke(c, fn)
+}
+
+// Start validates the container, creates every singleton and calls Start on the ones implementing Starter
+func (c *Container) Start(ctx context.Context) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	var singletons []reflect.Type
+	for _, t := range c.order {
+		if c.providers[t].lifetime == Singleton {
+			singletons = append(singletons, t)
+		}
+	}
+	c.mu.Unlock()
+	for _, t := range singletons {
+		if _, err := c.resolve(t, nil); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	created := c.created
+	c.mu.Unlock()
+	for _, instance := range created {
+		if starter, ok := instance.Interface().(Starter); ok {
+			if err := starter.Start(ctx); err != nil {
+				return fmt.Errorf("unable to start %s: %w", instance.Type(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Stop calls Stop on the singletons implementing Stopper, in reverse creation order
+func (c *Container) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	created := c.created
+	c.created = nil
+	c.mu.Unlock()
+	return stopAll(ctx, created)
+}
+
+// NewScope returns a scope holding the request components
+func (c *Container) NewScope() *Scope {
+	return &Scope{
+		container: c,
+		instances: map[reflect.Type]reflect.Value{},
+	}
+}
+
+// ----------------------------------------------------------------------------------------------------------------------
+// SCOPE
+// ----------------------------------------------------------------------------------------------------------------------
+
+// Scope resolves the request components, singletons are delegated to the container
+type Scope struct {
+	container *Container
+	mu        sync.Mutex
+	instances map[reflect.Type]reflect.Value
+	created   []reflect.Value
+}
+
+// Set gives the value of a component declared with Declare
+func (s *Scope) Set(value any) {
+	v := reflect.ValueOf(value)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances[v.Type()] = v
+}
+
+func (s *Scope) resolve(t reflect.Type, path []reflect.Type) (reflect.Value, error) {
+	s.mu.Lock()
+	instance, found := s.instances[t]
+	s.mu.Unlock()
+	if found {
+		return instance, nil
+	}
+	s.container.mu.Lock()
+	p, ok := s.container.providers[t]
+	s.container.mu.Unlock()
+	if !ok || p.lifetime == Singleton {
+		return s.container.resolve(t, path)
+	}
+	if p.declared {
+		return reflect.Value{}, fmt.Errorf("%s is not set on the scope", t)
+	}
+	if err := checkCycle(t, path); err != nil {
+		return reflect.Value{}, err
+	}
+	args := make([]reflect.Value, len(p.in))
+	for i, dep := range p.in {
+		arg, err := s.resolve(dep, append(path, t))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		args[i] = arg
+	}
+	instance, err := p.build(args)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.instances[t]; ok {
+		return existing, nil
+	}
+	s.instances[t] = instance
+	s.created = append(s.created, instance)
+	return instance, nil
+}
+
+// Invoke calls fn with its arguments resolved from the scope
+func (s *Scope) Invoke(fn any) error {
+	return invoke(s, fn)
+}
+
+// Close calls Stop on the request components implementing Stopper
+func (s *Scope) Close(ctx context.Context) error {
+	s.mu.Lock()
+	created := s.created
+	s.created = nil
+	s.mu.Unlock()
+	return stopAll(ctx, created)
+}
+
+// ----------------------------------------------------------------------------------------------------------------------
+
+// ResolveFrom returns the component of type T, a nil component is returned as the zero value
+func ResolveFrom[T any](r Resolver) (T, error) {
+	var result T
+	value, err := r.resolve(typeOf[T](), nil)
+	if err != nil {
+		return result, err
+	}
+	if !value.IsValid() || (value.Kind() == reflect.Interface && value.IsNil()) {
+		return result, nil
+	}
+	typed, ok := value.Interface().(T)
+	if !ok {
+		return result, fmt.Errorf("%s resolved to a value of type %s", typeOf[T](), value.Type())
+	}
+	return typed, nil
+}
+
+// MustResolve returns the component of type T and panics when it cannot be created
+func MustResolve[T any](r Resolver) T {
+	result, err := ResolveFrom[T](r)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func invoke(r Resolver, fn any) error {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func {
+		return fmt.Errorf("invoke expects a function, got %T", fn)
+	}
+	t := f.Type()
+	args := make([]reflect.Value, t.NumIn())
+	for i := range args {
+		arg, err := r.resolve(t.In(i), nil)
+		if err != nil {
+			return err
+		}
+		args[i] = arg
+	}
+	results := f.Call(args)
+	if len(results) > 0 {
+		last := results[len(results)-1]
+		if last.Type() == errorType && !last.IsNil() {
+			return last.Interface().(error)
+		}
+	}
+	return nil
+}
+
+func stopAll(ctx context.Context, instances []reflect.Value) error {
+	var errs []string
+	for i := len(instances) - 1; i >= 0; i-- {
+		if stopper, ok := instances[i].Interface().(Stopper); ok {
+			if err := stopper.Stop(ctx); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", instances[i].Type(), err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to stop components: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func checkCycle(t reflect.Type, path []reflect.Type) error {
+	for _, p := range path {
+		if p == t {
+			return fmt.Errorf("dependency cycle: %s", formatPath(append(path, t)))
+		}
+	}
+	return nil
+}
+
+func formatPath(path []reflect.Type) string {
+	parts := make([]string, len(path))
+	for i, t := range path {
+		parts[i] = t.String()
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// ----------------------------------------------------------------------------------------------------------------------
+// DEPRECATED REGISTRY
+// ----------------------------------------------------------------------------------------------------------------------
+
+// registry holds the components registered by name with the deprecated package functions
+var registry = struct {
+	sync.RWMutex
+	components map[string]any
+}{components: map[string]any{}}
+
+// Register adds a component to the global registry under name.
+//
+// Deprecated: Provide the components to App.Container (see micro.App.Provide).
+func Register(name string, component interface{}) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.components[name] = component
+}
+
+// Resolve returns the component of type *T of the global registry, it is fatal when none
+// is registered.
+//
+// Deprecated: Use ResolveFrom with App.Container, or micro.Resolve and micro.Inject.
+func Resolve[T Component](typ T) *T {
+	registry.RLock()
+	defer registry.RUnlock()
+	for _, component := range registry.components {
+		if typed, ok := component.(*T); ok {
+			return typed
 		}
 	}
-	log.Fatalf("failed to resolve component %s", typ)
+	log.Fatalf("failed to resolve component %v", reflect.TypeOf(typ))
 	return nil
 }
 
+// ResolveByName returns the component registered under name, it is fatal when none is.
+//
+// Deprecated: Use ResolveFrom with App.Container, or micro.Resolve and micro.Inject.
 func ResolveByName[T interface{}](name string) T {
-	if component, ok := registry[name]; ok {
-		return component.(T)
+	registry.RLock()
+	component, ok := registry.components[name]
+	registry.RUnlock()
+	if !ok {
+		log.Fatalf("failed to resolve component %s", name)
 	}
-	log.Fatalf("failed to resolve component %s", name)
-	panic("failed to resolve component")
+	return component.(T)
 }
 
+// Clear removes the components of the global registry.
+//
+// Deprecated: Use a new Container (see New).
 func Clear() {
-	registry = make(map[string]any)
+	registry.Lock()
+	defer registry.Unlock()
+	registry.components = map[string]any{}
 }
@@ -0,0 +1,190 @@
+package di
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type repository interface {
+	Find() string
+}
+
+type sqlRepository struct {
+	started bool
+	events  *[]string
+}
+
+func (r *sqlRepository) Find() string {
+	return "sql"
+}
+
+func (r *sqlRepository) Start(_ context.Context) error {
+	r.started = true
+	*r.events = append(*r.events, "start repo")
+	return nil
+}
+
+func (r *sqlRepository) Stop(_ context.Context) error {
+	*r.events = append(*r.events, "stop repo")
+	return nil
+}
+
+type service struct {
+	repo repository
+}
+
+func (s *service) Stop(_ context.Context) error {
+	return nil
+}
+
+type requestInfo struct {
+	user string
+}
+
+type handler struct {
+	service *service
+	info    requestInfo
+	events  *[]string
+}
+
+func (h *handler) Stop(_ context.Context) error {
+	*h.events = append(*h.events, "stop handler "+h.info.user)
+	return nil
+}
+
+func newContainer(events *[]string) *Container {
+	c := New()
+	_ = c.Supply(events)
+	_ = c.Provide(func(events *[]string) repository {
+		return &sqlRepository{events: events}
+	})
+	_ = c.Provide(func(repo repository) (*service, error) {
+		return &service{repo: repo}, nil
+	})
+	_ = Declare[requestInfo](c)
+	_ = c.Provide(func(s *service, info requestInfo, events *[]string) *handler {
+		return &handler{service: s, info: info, events: events}
+	}, PerRequest())
+	return c
+}
+
+func TestContainer(t *testing.T) {
+	var events []string
+	c := newContainer(&events)
+	assert.Nil(t, c.Validate())
+	assert.Nil(t, c.Start(context.Background()))
+
+	svc := MustResolve[*service](c)
+	assert.Same(t, svc, MustResolve[*service](c))
+	assert.True(t, svc.repo.(*sqlRepository).started)
+
+	_, err := ResolveFrom[*handler](c)
+	assert.NotNil(t, err, "request components are not resolved by the container")
+
+	scope := c.NewScope()
+	scope.Set(requestInfo{user: "u1"})
+	h1 := MustResolve[*handler](scope)
+	assert.Same(t, h1, MustResolve[*handler](scope))
+	assert.Same(t, svc, h1.service)
+	assert.Equal(t, "u1", h1.info.user)
+
+	other := c.NewScope()
+	other.Set(requestInfo{user: "u2"})
+	assert.NotSame(t, h1, MustResolve[*handler](other))
+
+	assert.Nil(t, scope.Invoke(func(h *handler, repo repository) error {
+		assert.Equal(t, "sql", repo.Find())
+		return nil
+	}))
+	assert.NotNil(t, scope.Invoke(func(h *handler) error {
+		return fmt.Errorf("failed")
+	}))
+
+	assert.Nil(t, scope.Close(context.Background()))
+	assert.Nil(t, other.Close(context.Background()))
+	assert.Nil(t, c.Stop(context.Background()))
+	assert.Equal(t, []string{"start repo", "stop handler u1", "stop handler u2", "stop repo"}, events)
+}
+
+func TestContainerValidation(t *testing.T) {
+	c := New()
+	_ = c.Provide(func(s *service) repository { return &sqlRepository{} })
+	_ = c.Provide(func(repo repository) *service { return &service{repo: repo} })
+	_ = c.Provide(func(info requestInfo) *handler { return &handler{info: info} })
+	err := c.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "dependency cycle")
+	assert.Contains(t, err.Error(), "missing dependency di.requestInfo")
+
+	c = New()
+	_ = c.Provide(func() *handler { return &handler{} }, PerRequest())
+	_ = c.Provide(func(h *handler) *service { return &service{} })
+	assert.Contains(t, c.Validate().Error(), "depends on request component")
+
+	assert.NotNil(t, c.Provide(func() *service { return nil }), "duplicates are rejected")
+	assert.NotNil(t, c.Provide("not a function"))
+}
+
+type fakeRepository struct{}
+
+func (r fakeRepository) Find() string {
+	return "fake"
+}
+
+func TestContainerOverride(t *testing.T) {
+	var events []string
+	c := newContainer(&events)
+	assert.Nil(t, OverrideAs[repository](c, fakeRepository{}))
+	assert.Nil(t, c.Start(context.Background()))
+	assert.Equal(t, "fake", MustResolve[*service](c).repo.Find())
+	assert.Empty(t, events)
+}
+
+type mismatchResolver struct{}
+
+func (r mismatchResolver) resolve(t reflect.Type, path []reflect.Type) (reflect.Value, error) {
+	return reflect.ValueOf("not a service"), nil
+}
+
+func TestContainerResolvingConstructor(t *testing.T) {
+	var events []string
+	c := newContainer(&events)
+	type report struct {
+		repo string
+	}
+	_ = c.Provide(func() (*report, error) {
+		// constructors may resolve other components (e.g. optional ones)
+		svc, err := ResolveFrom[*service](c)
+		if err != nil {
+			return nil, err
+		}
+		return &report{repo: svc.repo.Find()}, nil
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Start(context.Background())
+	}()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the container is locked while the constructors run")
+	}
+	assert.Equal(t, "sql", MustResolve[*report](c).repo)
+
+	_, err := ResolveFrom[*service](mismatchResolver{})
+	assert.ErrorContains(t, err, "resolved to a value of type string")
+}
+
+func TestDeprecatedRegistry(t *testing.T) {
+	defer Clear()
+	svc := &service{}
+	Register("service", svc)
+	assert.Same(t, svc, Resolve(service{}))
+	assert.Same(t, svc, ResolveByName[*service]("service"))
+}
@@ -9,6 +9,8 @@ import (
 	"github.com/soffa-projects/go-micro/util/ids"
 )
 
+// CRUD registers the list, search, create, delete and patch routes of an entity, and the
+// history route (GET /:id/history) when the audit is enabled
 func CRUD[Dto any, CreateDto any, UpdateDto any](g micro.BaseRouter) {
 	g.GET("", func(ctx micro.Ctx, input schema.PagingInput) schema.EntityList[Dto] {
 		return GetEntityList[Dto](ctx, input)
@@ -26,6 +28,42 @@ func CRUD[Dto any, CreateDto any, UpdateDto any](g micro.BaseRouter) {
 	g.PATCH("/:id", func(ctx micro.Ctx, input UpdateDto) Dto {
 		return UpdateEntity[Dto](ctx, input)
 	})
+	if !auditEnabled(g) {
+		return
+	}
+	g.GET("/:id/history", func(ctx micro.Ctx, input schema.AuditHistoryInput) schema.EntityList[micro.AuditEntry] {
+		var model Dto
+		input.Entity = micro.AuditEntityName(model)
+		return GetEntityHistory(ctx, input)
+	})
+}
+
+// AuditHistory registers the route browsing the changes of any audited entity
+// (GET /:entity/:id). It exposes the history of every entity type, including the ones
+// without CRUD routes, so g must be guarded by an authorization filter (e.g. admins only).
+func AuditHistory(g micro.BaseRouter) {
+	g.GET("/:entity/:id", func(ctx micro.Ctx, input schema.AuditHistoryInput) schema.EntityList[micro.AuditEntry] {
+		return GetEntityHistory(ctx, input)
+	})
+}
+
+func auditEnabled(g micro.BaseRouter) bool {
+	r, ok := g.(micro.EnvRouter)
+	return ok && r.Env() != nil && r.Env().Audit != nil
+}
+
+func GetEntityHistory(c micro.Ctx, input schema.AuditHistoryInput) schema.EntityList[micro.AuditEntry] {
+	h.RaiseIf(!c.AuditEnabled(), errors.ResourceNotFound("audit_disabled"))
+	page := 1
+	if input.Page > 1 {
+		page = input.Page
+	}
+	entries, err := c.Env.Audit.History(c, input.Entity, input.Id, page, input.Count)
+	h.RaiseAny(err)
+	return schema.EntityList[micro.AuditEntry]{
+		Data: entries,
+		Page: page,
+	}
 }
 
 func GetEntityList[T any](c micro.Ctx, paging schema.PagingInput) schema.EntityList[T] {
@@ -80,6 +118,7 @@ func CreateEntity[T any](c micro.Ctx, input any, entity T) T {
 	h.RaiseIf(h.IsStrEmpty(prefix), errors.Technical("entity_missing_id_prefix"))
 	h.RaiseAny(reflections.SetField(&entity, "Id", ids.NewIdPtr(prefix)))
 	h.RaiseAny(db.Create(&entity))
+	h.RaiseAny(c.RecordAudit(micro.AuditCreate, nil, &entity))
 	return entity
 }
 
@@ -92,18 +131,30 @@ func UpdateEntity[T any](c micro.Ctx, input any) T {
 		Args: []any{id},
 	})
 	h.RaiseAny(err)
+	before := entity
 	h.RaiseAny(h.CopyAllFields(&entity, input, true))
 	h.RaiseAny(db.Save(&entity))
+	h.RaiseAny(c.RecordAudit(micro.AuditUpdate, &before, &entity))
 	return entity
 }
 
 func DeleteEntity[T any](c micro.Ctx, input schema.IdModel) schema.IdModel {
 	db := c.CurrentDB()
 	var entity T
+	var before *T
+	if c.AuditEnabled() {
+		var loaded T
+		if err := db.First(&loaded, micro.Query{W: "id = ?", Args: []any{*input.Id}}); err == nil {
+			before = &loaded
+		}
+	}
 	_, err := db.Delete(entity, micro.Query{
 		W:    "id = ?",
 		Args: []any{*input.Id},
 	})
 	h.RaiseAny(err)
+	if before != nil {
+		h.RaiseAny(c.RecordAudit(micro.AuditDelete, before, nil))
+	}
 	return input
 }
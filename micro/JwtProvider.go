@@ -3,7 +3,6 @@ package micro
 import (
 	"errors"
 	"github.com/golang-jwt/jwt/v5"
-	log "github.com/sirupsen/logrus"
 	"github.com/soffa-projects/go-micro/util/dates"
 	"github.com/thoas/go-funk"
 	"time"
@@ -16,16 +15,24 @@ type TokenProvider interface {
 }
 
 type DefaultTokenProvider struct {
-	secret string
+	secret func() string
 	kind   string
 }
 
 func NewJwtTokenProvider(secret string) TokenProvider {
+	return NewRotatingJwtTokenProvider(func() string {
+		return secret
+	})
+}
+
+// NewRotatingJwtTokenProvider reads the signing key from secret on every use, so that
+// a rotated SERVER_TOKEN is picked up without restart
+func NewRotatingJwtTokenProvider(secret func() string) TokenProvider {
 	return &DefaultTokenProvider{secret: secret, kind: "jwt"}
 }
 
 func (p *DefaultTokenProvider) SigningKey() string {
-	return p.secret
+	return p.secret()
 }
 
 func (p *DefaultTokenProvider) Decode(token string, checkSignature bool) (map[string]interface{}, error) {
@@ -37,7 +44,7 @@ func (p *DefaultTokenProvider) Decode(token string, checkSignature bool) (map[st
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
 		}
-		return []byte(p.secret), nil
+		return []byte(p.secret()), nil
 	})
 
 	if err != nil && checkSignature {
@@ -78,7 +85,7 @@ func (p *DefaultTokenProvider) CreateToken(subject string, issuer string, audien
 			}
 		}
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		signed, err := token.SignedString([]byte(p.secret))
+		signed, err := token.SignedString([]byte(p.secret()))
 		if err != nil {
 			log.Errorf("Error signing token: %v", err)
 		}
@@ -0,0 +1,245 @@
+package micro
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/soffa-projects/go-micro/util/h"
+	"github.com/soffa-projects/go-micro/util/ids"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditCreate AuditAction = "create"
+	AuditUpdate AuditAction = "update"
+	AuditDelete AuditAction = "delete"
+)
+
+const DefaultAuditTable = "audit_log"
+
+// RedactedValue replaces the values of the redacted fields in the audit entries
+const RedactedValue = "[REDACTED]"
+
+// DefaultAuditRedact lists the fields redacted when AuditLog.Redact is empty
+var DefaultAuditRedact = []string{"password", "secret", "token"}
+
+// AuditEntry is a change of an entity
+type AuditEntry struct {
+	Id        string              `json:"id"`
+	Entity    string              `json:"entity"`
+	EntityId  string              `json:"entity_id"`
+	Action    AuditAction         `json:"action"`
+	Tenant    string              `json:"tenant,omitempty"`
+	Actor     string              `json:"actor,omitempty"`
+	IpAddress string              `json:"ip_address,omitempty"`
+	Changes   map[string]h.Change `json:"changes"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+// AuditLog records the changes made through EntityRepo and handlers.CRUD in a table
+// of the current datasource. The entry is only written in the same transaction as the
+// change when it runs in Ctx.Tx (e.g. the implicit transaction of a route), otherwise the
+// change and its entry are separate statements:
+//
+//	CREATE TABLE audit_log (
+//	  id         VARCHAR(64) PRIMARY KEY,
+//	  entity     VARCHAR(255) NOT NULL,
+//	  entity_id  VARCHAR(255) NOT NULL,
+//	  action     VARCHAR(16) NOT NULL,
+//	  tenant     VARCHAR(255),
+//	  actor      VARCHAR(255),
+//	  ip_address VARCHAR(64),
+//	  changes    TEXT,
+//	  created_at TIMESTAMP NOT NULL
+//	);
+//	CREATE INDEX audit_log_entity_idx ON audit_log (entity, entity_id);
+//
+// The values of the fields whose name contains one of Redact are replaced by RedactedValue.
+type AuditLog struct {
+	Table  string
+	Redact []string
+}
+
+func NewAuditLog(table string, redact ...string) *AuditLog {
+	if table == "" {
+		table = DefaultAuditTable
+	}
+	if len(redact) == 0 {
+		redact = DefaultAuditRedact
+	}
+	return &AuditLog{Table: table, Redact: redact}
+}
+
+type auditRow struct {
+	Id        string
+	Entity    string
+	EntityId  string
+	Action    string
+	Tenant    *string
+	Actor     *string
+	IpAddress *string
+	Changes   *string
+	CreatedAt time.Time
+}
+
+// Record writes the changes between before and after, before is nil for a creation
+// and after is nil for a deletion. Nothing is written for an update without change.
+// Record is a no-op on a nil AuditLog.
+func (a *AuditLog) Record(ctx Ctx, action AuditAction, before, after any) error {
+	if a == nil {
+		return nil
+	}
+	subject := after
+	if action == AuditDelete {
+		subject = before
+	}
+	changes := a.redact(h.Changes(before, after))
+	if action == AuditUpdate && len(changes) == 0 {
+		return nil
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	entry := AuditEntry{
+		Id:        ids.NewId("aud"),
+		Entity:    AuditEntityName(subject),
+		EntityId:  auditEntityId(subject),
+		Action:    action,
+		Tenant:    ctx.TenantId,
+		Actor:     ctx.Actor(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if ctx.Auth != nil {
+		entry.IpAddress = ctx.Auth.IpAddress
+	}
+	_, err = ctx.CurrentDB().Raw(Query{
+		Raw: fmt.Sprintf("INSERT INTO %s (id, entity, entity_id, action, tenant, actor, ip_address, changes, created_at) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", a.Table),
+		Args: []any{
+			entry.Id, entry.Entity, entry.EntityId, string(entry.Action), entry.Tenant,
+			entry.Actor, entry.IpAddress, string(encoded), entry.CreatedAt,
+		},
+	})
+	return err
+}
+
+// History returns the changes of an entity made by the tenant of ctx, the most recent
+// first. page starts at 1.
+func (a *AuditLog) History(ctx Ctx, entity string, id string, page int, count int) ([]AuditEntry, error) {
+	if a == nil {
+		return nil, nil
+	}
+	if count <= 0 {
+		count = 100
+	}
+	if page < 1 {
+		page = 1
+	}
+	db := ctx.CurrentDB()
+	pagination := "LIMIT ? OFFSET ?"
+	args := []any{entity, id, ctx.TenantId, count, (page - 1) * count}
+	if db.Dialect() == DialectSQLServer {
+		pagination = "OFFSET ? ROWS FETCH NEXT ? ROWS ONLY"
+		args[3], args[4] = args[4], args[3]
+	}
+	var rows []auditRow
+	err := db.Find(&rows, Query{
+		Raw: fmt.Sprintf("SELECT id, entity, entity_id, action, tenant, actor, ip_address, changes, created_at "+
+			"FROM %s WHERE entity = ? AND entity_id = ? AND tenant = ? ORDER BY created_at DESC, id DESC %s", a.Table, pagination),
+		Args: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = AuditEntry{
+			Id:        row.Id,
+			Entity:    row.Entity,
+			EntityId:  row.EntityId,
+			Action:    AuditAction(row.Action),
+			Tenant:    h.UnwrapStr(row.Tenant),
+			Actor:     h.UnwrapStr(row.Actor),
+			IpAddress: h.UnwrapStr(row.IpAddress),
+			CreatedAt: row.CreatedAt,
+		}
+		if row.Changes != nil && *row.Changes != "" {
+			if err := json.Unmarshal([]byte(*row.Changes), &entries[i].Changes); err != nil {
+				return nil, fmt.Errorf("invalid audit changes %s: %w", row.Id, err)
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (a *AuditLog) redact(changes map[string]h.Change) map[string]h.Change {
+	for name, change := range changes {
+		for _, pattern := range a.Redact {
+			if pattern != "" && strings.Contains(name, strings.ToLower(pattern)) {
+				if change.From != nil {
+					change.From = RedactedValue
+				}
+				if change.To != nil {
+					change.To = RedactedValue
+				}
+				changes[name] = change
+				break
+			}
+		}
+	}
+	return changes
+}
+
+// RecordAudit records a change in Env.Audit, it does nothing when the audit is disabled
+func (ctx Ctx) RecordAudit(action AuditAction, before, after any) error {
+	if ctx.Env == nil {
+		return nil
+	}
+	return ctx.Env.Audit.Record(ctx, action, before, after)
+}
+
+// AuditEnabled returns true when the changes are recorded
+func (ctx Ctx) AuditEnabled() bool {
+	return ctx.Env != nil && ctx.Env.Audit != nil
+}
+
+// AuditEntityName is the name under which the changes of an entity are recorded,
+// the snake cased name of its type
+func AuditEntityName(entity any) string {
+	t := reflect.TypeOf(entity)
+	if t == nil {
+		return ""
+	}
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return h.ToSnakeCase(t.Name())
+}
+
+func auditEntityId(entity any) string {
+	value := reflect.ValueOf(entity)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return ""
+	}
+	field := value.FieldByName("Id")
+	if !field.IsValid() {
+		return ""
+	}
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return ""
+		}
+		field = field.Elem()
+	}
+	return fmt.Sprint(field.Interface())
+}
@@ -6,12 +6,12 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"github.com/redis/go-redis/v9"
-	log "github.com/sirupsen/logrus"
 	"github.com/soffa-projects/go-micro/di"
 	"github.com/soffa-projects/go-micro/schema"
 	"github.com/soffa-projects/go-micro/util/h"
 	"net/http"
 	"reflect"
+	"time"
 )
 
 var DefaultTenantId = "public"
@@ -19,17 +19,37 @@ var TenantIdHttpHeader = "X-TenantId"
 
 type Feature struct {
 	Name string
-	// Deprecated: Use Configure instead.
+	// DependsOn lists the features configured and started before this one
+	DependsOn []string
+	// Deprecated: Use Configure instead. The returned component is registered in App.Container under its type.
 	Init      func(app *App) (di.Component, error)
 	Configure func(app *App) error
+	// Start is called when the application starts, before the server accepts requests
+	Start func(ctx context.Context) error
+	// Stop is called when the application stops, in reverse start order
+	Stop func(ctx context.Context) error
 }
 
 type App struct {
-	Name              string
-	Version           string
-	Env               *Env
+	Name            string
+	Version         string
+	Env             *Env
+	ShutdownHooks   []ShutdownHook
+	ShutdownTimeout time.Duration
+	Router          Router
+	// ShutdownDelay is how long the readiness check reports draining before the server stops
+	// accepting requests, so that the load balancers stop routing to the instance
+	ShutdownDelay time.Duration
+	// Deprecated: Use ShutdownHooks (see AddShutdownHook), the listeners run after the hooks.
 	ShutdownListeners []func()
-	Router            Router
+	// Container holds the components provided by the features, see Provide and Resolve
+	Container *di.Container
+	features  []Feature
+	started   []Feature
+	// serving is set while the server may accept requests
+	serving bool
+	// configured is set once the env components are registered
+	configured bool
 }
 
 type AuthToken struct {
@@ -83,11 +103,15 @@ type Ctx struct {
 	Env      *Env
 	db       DataSource
 	Wrapped  interface{}
+	stdCtx   context.Context
+	// inTx is set in the Ctx of a transaction, Tx then uses a savepoint
+	inTx bool
 }
 
 type Env struct {
 	Ctx
 	Conf        interface{}
+	Settings    *Settings
 	AppName     string
 	AppVersion  string
 	DataSources map[string]DataSource
@@ -98,11 +122,21 @@ type Env struct {
 	Notifier            NotificationService
 	Mailer              Mailer
 	Production          bool
+	Environment         string
 	TenantLoader        TenantLoader
 	Localizer           *i18n.Localizer
 	RedisClient         *redis.Client
 	DiscoverySericeName string
 	DiscoveryServiceUrl string
+	Metrics             *Metrics
+	Tracing             bool
+	Health              *HealthRegistry
+	Secrets             *SecretResolver
+	Flags               *FlagRegistry
+	Audit               *AuditLog
+	Migrator            *Migrator
+	Seeds               *Seeds
+	Container           *di.Container
 }
 
 type AppCfg struct {
@@ -145,7 +179,33 @@ func (e Env) TenantDB(tenant string) (DataSource, error) {
 }
 
 func (ctx Ctx) CurrentDB() DataSource {
-	return ctx.db
+	if ctx.db == nil {
+		return nil
+	}
+	return ctx.db.WithContext(ctx.dbContext())
+}
+
+// Context returns the standard context bound to ctx: the one set with WithContext,
+// the context of the wrapped http request or context.Background()
+func (ctx Ctx) Context() context.Context {
+	if ctx.stdCtx != nil {
+		return ctx.stdCtx
+	}
+	if req := ctx.Request(); req != nil {
+		return req.Context()
+	}
+	return context.Background()
+}
+
+func (ctx Ctx) WithContext(value context.Context) Ctx {
+	ctx.stdCtx = value
+	return ctx
+}
+
+// WithDB returns a Ctx whose CurrentDB is db
+func (ctx Ctx) WithDB(db DataSource) Ctx {
+	ctx.db = db
+	return ctx
 }
 
 func (ctx Ctx) IsAuthenticated() bool {
@@ -205,31 +265,6 @@ func NewAuthCtx(env *Env, tenantId string, auth *Authentication) Ctx {
 	return ctx
 }
 
-func (ctx Ctx) Tx(cb func(tx Ctx) error) error {
-	db := ctx.db
-	if db == nil {
-		db = ctx.Env.DataSources[ctx.TenantId]
-	}
-	if db == nil {
-		log.Warn("no db found in current context (skipping global transaction)")
-		return cb(Ctx{
-			TenantId: ctx.TenantId,
-			Auth:     ctx.Auth,
-			Env:      ctx.Env,
-			Wrapped:  ctx.Wrapped,
-		})
-	}
-	return db.Transaction(func(tx DataSource) error {
-		return cb(Ctx{
-			TenantId: ctx.TenantId,
-			Auth:     ctx.Auth,
-			db:       tx,
-			Env:      ctx.Env,
-			Wrapped:  ctx.Wrapped,
-		})
-	})
-}
-
 func (e Env) Close() {
 	for _, db := range e.DataSources {
 		db.Close()
@@ -3,7 +3,6 @@ package micro
 import (
 	"context"
 	"github.com/allegro/bigcache/v3"
-	log "github.com/sirupsen/logrus"
 	"github.com/soffa-projects/go-micro/util/h"
 	"time"
 )
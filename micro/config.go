@@ -0,0 +1,261 @@
+package micro
+
+import (
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redacted = "******"
+
+// ConfigOptions tells LoadConfig where to look for values. Sources are applied in this
+// order, the last one wins: `default` tags, files (FS), environment variables.
+type ConfigOptions struct {
+	FS fs.FS
+	// Files are read from FS in order, missing files are skipped. Supported formats are toml and yaml.
+	Files []string
+}
+
+// DefaultConfigFiles returns the files read by adapters.NewApp for the given environment:
+// config.{toml,yaml,yml} then config.<env>.{toml,yaml,yml}
+func DefaultConfigFiles(env string) []string {
+	files := []string{"config.toml", "config.yaml", "config.yml"}
+	if env != "" {
+		files = append(files,
+			fmt.Sprintf("config.%s.toml", env),
+			fmt.Sprintf("config.%s.yaml", env),
+			fmt.Sprintf("config.%s.yml", env),
+		)
+	}
+	return files
+}
+
+// LoadConfig fills target (a pointer to a struct) using the following field tags:
+//
+//	env:"NAME,ALIAS"   environment variables, the first one set is used
+//	default:"value"    value used when no source provides one
+//	required:"true"    fails when no source provides a value
+//	secret:"true"      value redacted by DumpConfig
+//	lenient:"true"     an invalid environment value is ignored with a warning (e.g. a bool set to "yes")
+//	validate:"..."     go-playground/validator rules, checked once everything is bound
+//
+// Files are decoded with the `toml` and `yaml` tags. Nested structs are supported.
+func LoadConfig(target any, opts ConfigOptions) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config target must be a pointer to a struct, got %T", target)
+	}
+	if err := walkConfig(value.Elem(), "", applyDefault); err != nil {
+		return err
+	}
+	if opts.FS != nil {
+		for _, file := range opts.Files {
+			if err := loadConfigFile(opts.FS, file, target); err != nil {
+				return err
+			}
+		}
+	}
+	if err := walkConfig(value.Elem(), "", applyEnv); err != nil {
+		return err
+	}
+	var missing []string
+	_ = walkConfig(value.Elem(), "", func(field reflect.StructField, v reflect.Value, name string) error {
+		if field.Tag.Get("required") == "true" && v.IsZero() {
+			missing = append(missing, name)
+		}
+		return nil
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required configuration: %s", strings.Join(missing, ", "))
+	}
+	if err := configValidator.Struct(target); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	return nil
+}
+
+// DumpConfig returns the configuration as a flat map, with the secret values redacted.
+// It is meant to be logged at startup.
+func DumpConfig(target any) map[string]any {
+	result := map[string]any{}
+	value := reflect.Indirect(reflect.ValueOf(target))
+	if value.Kind() != reflect.Struct {
+		return result
+	}
+	_ = walkConfig(value, "", func(field reflect.StructField, v reflect.Value, name string) error {
+		if field.Tag.Get("secret") == "true" {
+			if v.IsZero() {
+				result[name] = ""
+			} else {
+				result[name] = redacted
+			}
+		} else {
+			result[name] = v.Interface()
+		}
+		return nil
+	})
+	return result
+}
+
+// Conf returns the typed application configuration (Cfg.Config) loaded at startup
+func Conf[T any](env *Env) *T {
+	if env == nil || env.Conf == nil {
+		return nil
+	}
+	if value, ok := env.Conf.(*T); ok {
+		return value
+	}
+	return nil
+}
+
+var configValidator = validator.New()
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+type configVisitor func(field reflect.StructField, value reflect.Value, name string) error
+
+// walkConfig calls visit for every leaf field of a struct, nested structs are walked recursively
+func walkConfig(value reflect.Value, prefix string, visit configVisitor) error {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := value.Field(i)
+		name := prefix + field.Name
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}) {
+			if err := walkConfig(fv, name+".", visit); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := visit(field, fv, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyDefault(field reflect.StructField, value reflect.Value, name string) error {
+	def, ok := field.Tag.Lookup("default")
+	if !ok || !value.IsZero() {
+		return nil
+	}
+	if err := setConfigValue(value, def); err != nil {
+		return fmt.Errorf("invalid default for %s: %w", name, err)
+	}
+	return nil
+}
+
+func applyEnv(field reflect.StructField, value reflect.Value, name string) error {
+	tag := field.Tag.Get("env")
+	if tag == "" {
+		return nil
+	}
+	for _, key := range strings.Split(tag, ",") {
+		raw, ok := os.LookupEnv(strings.TrimSpace(key))
+		if !ok || raw == "" {
+			continue
+		}
+		if err := setConfigValue(value, raw); err != nil {
+			if field.Tag.Get("lenient") == "true" {
+				log.Warnf("invalid value for %s (%s) ignored: %s", name, key, err)
+				return nil
+			}
+			return fmt.Errorf("invalid value for %s (%s): %w", name, key, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+func loadConfigFile(fsys fs.FS, file string, target any) error {
+	data, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to read %s: %w", file, err)
+	}
+	switch path.Ext(file) {
+	case ".toml":
+		err = toml.Unmarshal(data, target)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, target)
+	default:
+		return fmt.Errorf("unsupported config file format: %s", file)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to parse %s: %w", file, err)
+	}
+	log.Infof("configuration loaded from %s", file)
+	return nil
+}
+
+func setConfigValue(value reflect.Value, raw string) error {
+	if value.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(n)
+	case reflect.Slice:
+		parts := strings.Split(raw, ",")
+		slice := reflect.MakeSlice(value.Type(), 0, len(parts))
+		for _, part := range parts {
+			item := reflect.New(value.Type().Elem()).Elem()
+			if err := setConfigValue(item, strings.TrimSpace(part)); err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, item)
+		}
+		value.Set(slice)
+	case reflect.Ptr:
+		item := reflect.New(value.Type().Elem())
+		if err := setConfigValue(item.Elem(), raw); err != nil {
+			return err
+		}
+		value.Set(item)
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package micro
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+type testConfig struct {
+	Name     string        `toml:"name" yaml:"name" env:"TEST_CFG_NAME" default:"demo"`
+	Port     int           `toml:"port" yaml:"port" env:"TEST_CFG_PORT" default:"8080" validate:"gt=0"`
+	Token    string        `toml:"token" yaml:"token" env:"TEST_CFG_TOKEN" required:"true" secret:"true"`
+	Timeout  time.Duration `env:"TEST_CFG_TIMEOUT" default:"5s"`
+	Tenants  []string      `toml:"tenants" yaml:"tenants" env:"TEST_CFG_TENANTS"`
+	Database struct {
+		Url string `toml:"url" yaml:"url" env:"TEST_CFG_DB_URL" secret:"true"`
+	} `toml:"database" yaml:"database"`
+}
+
+func TestLoadConfig(t *testing.T) {
+	fsys := fstest.MapFS{
+		"config.toml":      {Data: []byte("name = \"from-toml\"\nport = 9000\n[database]\nurl = \"postgres://db\"\n")},
+		"config.test.yaml": {Data: []byte("port: 9001\n")},
+	}
+	t.Setenv("TEST_CFG_TOKEN", "s3cret")
+	t.Setenv("TEST_CFG_TENANTS", "t1, t2")
+
+	var cfg testConfig
+	err := LoadConfig(&cfg, ConfigOptions{FS: fsys, Files: DefaultConfigFiles("test")})
+	assert.Nil(t, err)
+	assert.Equal(t, "from-toml", cfg.Name)
+	assert.Equal(t, 9001, cfg.Port)
+	assert.Equal(t, "s3cret", cfg.Token)
+	assert.Equal(t, 5*time.Second, cfg.Timeout)
+	assert.Equal(t, []string{"t1", "t2"}, cfg.Tenants)
+	assert.Equal(t, "postgres://db", cfg.Database.Url)
+
+	dump := DumpConfig(&cfg)
+	assert.Equal(t, redacted, dump["Token"])
+	assert.Equal(t, redacted, dump["Database.Url"])
+	assert.Equal(t, 9001, dump["Port"])
+
+	env := &Env{Conf: &cfg}
+	assert.Equal(t, &cfg, Conf[testConfig](env))
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	var cfg testConfig
+	err := LoadConfig(&cfg, ConfigOptions{})
+	assert.ErrorContains(t, err, "Token")
+
+	t.Setenv("TEST_CFG_TOKEN", "s3cret")
+	t.Setenv("TEST_CFG_PORT", "-1")
+	err = LoadConfig(&testConfig{}, ConfigOptions{})
+	assert.ErrorContains(t, err, "invalid configuration")
+
+	t.Setenv("TEST_CFG_PORT", "abc")
+	err = LoadConfig(&testConfig{}, ConfigOptions{})
+	assert.ErrorContains(t, err, "TEST_CFG_PORT")
+
+	// the lenient values are ignored when invalid
+	t.Setenv("INSECURE_JWT_DEV", "yes")
+	t.Setenv("CORS_DISABLED", "true")
+	var settings Settings
+	assert.Nil(t, LoadConfig(&settings, ConfigOptions{}))
+	assert.False(t, settings.InsecureJwtDev)
+	assert.True(t, settings.CorsDisabled)
+}
@@ -1,5 +1,7 @@
 package micro
 
+// Deprecated: the names of the components in the global registry of di.ResolveByName, the
+// components are registered by type in App.Container: use Resolve or Inject.
 const SchedulerService = "scheduler_service"
 const TokenProviderService = "token_provider_service"
 const MailerServer = "mailer_service"
@@ -9,9 +11,19 @@ const NotificationTopic = "notifications"
 
 const DatabaseUrl = "DATABASE_URL"
 const DatabaseInitialTenants = "DATABASE_INITIAL_TENANTS"
+const DatabaseReplicaUrls = "DATABASE_REPLICA_URLS"
+const DatabaseSharedPool = "DATABASE_SHARED_POOL"
+const DatabaseMigrationConcurrency = "DATABASE_MIGRATION_CONCURRENCY"
 const InsecureJwtDev = "INSECURE_JWT_DEV"
 const ServerToken = "SERVER_TOKEN"
 const EmailSender = "EMAIL_SENDER"
 const NotificationSender = "NOTIFICATION_SENDER"
 const RedisUrl = "REDIS_URL"
 const SessionKey = "SESSION_SECRET"
+const TracesExporter = "OTEL_TRACES_EXPORTER"
+const ShutdownTimeout = "SHUTDOWN_TIMEOUT"
+const VaultAddr = "VAULT_ADDR"
+const VaultToken = "VAULT_TOKEN"
+const SecretsTTL = "SECRETS_TTL"
+const FeatureFlagsSources = "FEATURE_FLAGS_SOURCES"
+const AuditEnabled = "AUDIT_ENABLED"
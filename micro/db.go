@@ -1,24 +1,89 @@
 package micro
 
 import (
+	"context"
 	gerror "errors"
 	"fmt"
 	"github.com/soffa-projects/go-micro/util/errors"
 	"github.com/soffa-projects/go-micro/util/h"
 	"io/fs"
+	"time"
 )
 
-/*type DataSourceCfg struct {
-	Production   bool
-	Url          string
-	Migrations   fs.FS
-	TenantLoader TenantLoader
-}*/
+type DataSourceCfg struct {
+	Url    string
+	Tenant string
+	// UrlSource, when set, returns the current url (e.g. a rotated password) and is used
+	// every time a new connection is opened
+	UrlSource func() string
+	Metrics   *Metrics
+	Tracing   bool
+	// Replicas are the urls of the read replicas, the reads made outside of a transaction
+	// are balanced between them and fall back to the primary when none is healthy
+	Replicas []string
+	// ReplicaSources follow the rotations of the Replicas urls, by index (see UrlSource),
+	// a nil source keeps the url of the replica
+	ReplicaSources []func() string
+	// ReplicaCheckInterval is the delay between two health checks of the replicas
+	ReplicaCheckInterval time.Duration
+	Pool                 PoolCfg
+	// SharedPool makes the postgres tenants of a server share one connection pool, the
+	// tenant is selected by setting the search_path of the connection for each statement
+	SharedPool bool
+	// Tenancy is the isolation of the tenants, TenancySchema when empty
+	Tenancy TenancyStrategy
+	// RowLevelSecurity sets TenantSetting on the postgres connections with TenancyRow
+	RowLevelSecurity bool
+}
+
+// PoolCfg configures the connection pool of a datasource, zero values keep the defaults
+type PoolCfg struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+const DefaultReplicaCheckInterval = 10 * time.Second
+
+type primaryReadsKey struct{}
+
+// WithPrimaryReads returns a context whose reads are made on the primary database
+// (e.g. to read a write made outside of the current transaction)
+func WithPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+// PrimaryReads returns true when the reads of ctx must not be sent to a replica
+func PrimaryReads(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	primary, _ := ctx.Value(primaryReadsKey{}).(bool)
+	return primary
+}
+
+// ReadFromPrimary returns a Ctx whose reads are made on the primary database
+func (ctx Ctx) ReadFromPrimary() Ctx {
+	return ctx.WithContext(WithPrimaryReads(ctx.Context()))
+}
 
 const DefaultMigrationsTable = "z_migrations"
 
+// Dialects of the datasources (DataSource.Dialect)
+const (
+	DialectPostgres  = "postgres"
+	DialectMySQL     = "mysql"
+	DialectSQLServer = "sqlserver"
+	DialectSQLite    = "sqlite"
+)
+
 type DataSourceMigrations interface {
-	Migrate(fs fs.FS, location string, migrationsTable string)
+	// Migrate applies the pending migrations of location
+	Migrate(fs fs.FS, location string, migrationsTable string) error
+	// RunMigrations runs a migration command on the migrations of source, holding a
+	// database lock (postgres advisory lock) for the duration of the command
+	RunMigrations(ctx context.Context, source MigrationSource, opts MigrationOptions) (MigrationReport, error)
 }
 
 type EntityHooks interface {
@@ -27,9 +92,14 @@ type EntityHooks interface {
 
 type DataSource interface {
 	IsPostgres() bool
+	// Dialect returns the database dialect, one of the Dialect constants
+	Dialect() string
 	Tenant() string
+	WithContext(ctx context.Context) DataSource
 	DataSourceMigrations
-	Transaction(func(tx DataSource) error) error
+	// Transaction runs cb in a transaction, or in a savepoint when the datasource is
+	// already a transaction (the options are then ignored)
+	Transaction(cb func(tx DataSource) error, opts ...TxOptions) error
 	Close()
 	Save(target any) error
 	Create(target any) error
@@ -43,6 +113,44 @@ type DataSource interface {
 	Execute(any, Query) (int64, error)
 	Raw(Query) (int64, error)
 	Patch(model any, id string, data map[string]interface{}) (int64, error)
+	CreateInBatches(target any, batchSize int) error
+	Upsert(target any, opts UpsertOptions) (int64, error)
+	// FindInBatches fills target (a pointer to a slice) with batchSize rows at a time, in
+	// primary key order (keyset pagination), and calls fn after each batch. q.Sort must be
+	// empty, the rows following another order would be skipped.
+	FindInBatches(target any, q Query, batchSize int, fn func(batch int) error) error
+	// Iterate opens a cursor on the rows of the query, it must be closed
+	Iterate(model any, q Query) (RowIterator, error)
+}
+
+// TxHooks is implemented by the datasources of a transaction (see Ctx.AfterCommit)
+type TxHooks interface {
+	// AfterCommit registers fn to run once the transaction is committed
+	AfterCommit(fn func())
+	// AfterRollback registers fn to run once the transaction is rolled back
+	AfterRollback(fn func())
+}
+
+// RowIterator streams the rows of a query, see DataSource.Iterate
+type RowIterator interface {
+	Next() bool
+	Scan(dest any) error
+	Err() error
+	Close() error
+}
+
+const DefaultBatchSize = 500
+
+// UpsertOptions describes the insert of rows that may already exist (ON CONFLICT)
+type UpsertOptions struct {
+	// Conflict are the columns of the unique constraint, the primary key when empty
+	Conflict []string
+	// Update are the columns updated when the row exists, all the columns when empty
+	Update []string
+	// DoNothing keeps the existing rows unchanged
+	DoNothing bool
+	// BatchSize is the number of rows per statement, DefaultBatchSize when 0
+	BatchSize int
 }
 
 var ErrRecordNotFound = errors.Functional("record not found")
@@ -56,6 +164,14 @@ type Query struct {
 	Select string
 	Offset int64
 	Limit  int64
+	// Unscoped includes the soft deleted rows and makes Delete permanent
+	Unscoped bool
+	// Conditions are added to W, they are built by QueryBuilder
+	Conditions []Condition
+	Joins      []Clause
+	Preload    []string
+	Group      string
+	Having     []Clause
 }
 
 type SimpleRepo[T any] struct {
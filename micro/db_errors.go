@@ -0,0 +1,128 @@
+package micro
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The DataSource implementations translate the errors of the database drivers to the
+// following types, the driver error is kept and returned by Unwrap.
+
+// UniqueViolation is returned when a row conflicts with a unique constraint or a primary key
+type UniqueViolation struct {
+	// Constraint is the name of the violated constraint when the driver reports it
+	Constraint string
+	// Columns are the columns of the constraint when the driver reports them
+	Columns []string
+	Err     error
+}
+
+func (e *UniqueViolation) Error() string {
+	return dbErrorMessage("unique violation", e.Constraint, e.Err)
+}
+
+func (e *UniqueViolation) Unwrap() error {
+	return e.Err
+}
+
+// ForeignKeyViolation is returned when a row references a missing row or when a row still
+// referenced is deleted
+type ForeignKeyViolation struct {
+	Constraint string
+	Err        error
+}
+
+func (e *ForeignKeyViolation) Error() string {
+	return dbErrorMessage("foreign key violation", e.Constraint, e.Err)
+}
+
+func (e *ForeignKeyViolation) Unwrap() error {
+	return e.Err
+}
+
+// NotNullViolation is returned when a required column has no value
+type NotNullViolation struct {
+	Column string
+	Err    error
+}
+
+func (e *NotNullViolation) Error() string {
+	return dbErrorMessage("not null violation", e.Column, e.Err)
+}
+
+func (e *NotNullViolation) Unwrap() error {
+	return e.Err
+}
+
+// CheckViolation is returned when a row fails a check constraint
+type CheckViolation struct {
+	Constraint string
+	Err        error
+}
+
+func (e *CheckViolation) Error() string {
+	return dbErrorMessage("check violation", e.Constraint, e.Err)
+}
+
+func (e *CheckViolation) Unwrap() error {
+	return e.Err
+}
+
+// Deadlock is returned when the transaction was chosen as the victim of a deadlock
+type Deadlock struct {
+	Err error
+}
+
+func (e *Deadlock) Error() string {
+	return dbErrorMessage("deadlock", "", e.Err)
+}
+
+func (e *Deadlock) Unwrap() error {
+	return e.Err
+}
+
+// SerializationFailure is returned when a transaction conflicts with a concurrent one
+// (serializable or snapshot isolation)
+type SerializationFailure struct {
+	Err error
+}
+
+func (e *SerializationFailure) Error() string {
+	return dbErrorMessage("serialization failure", "", e.Err)
+}
+
+func (e *SerializationFailure) Unwrap() error {
+	return e.Err
+}
+
+// LockTimeout is returned when a lock could not be acquired in time
+type LockTimeout struct {
+	Err error
+}
+
+func (e *LockTimeout) Error() string {
+	return dbErrorMessage("lock timeout", "", e.Err)
+}
+
+func (e *LockTimeout) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable returns true when err is a transient conflict between transactions
+// (Deadlock, SerializationFailure or LockTimeout): the transaction may succeed if retried
+func IsRetryable(err error) bool {
+	var deadlock *Deadlock
+	var serialization *SerializationFailure
+	var lockTimeout *LockTimeout
+	return errors.As(err, &deadlock) || errors.As(err, &serialization) || errors.As(err, &lockTimeout)
+}
+
+func dbErrorMessage(kind string, name string, err error) string {
+	if name != "" {
+		kind = fmt.Sprintf("%s (%s)", kind, name)
+	}
+	if err == nil {
+		return kind
+	}
+	return fmt.Sprintf("%s: %s", kind, err)
+}
@@ -2,6 +2,7 @@ package micro
 
 import (
 	serrors "errors"
+	"fmt"
 	"github.com/soffa-projects/go-micro/util/errors"
 	"github.com/soffa-projects/go-micro/util/h"
 	"github.com/thoas/go-funk"
@@ -18,12 +19,19 @@ type EntityRepo[T any] interface {
 	Update(Ctx, *T) error
 	DeleteById(Ctx, string) error
 	Merge(Ctx, string, func(target *T)) (*T, error)
+	// Deprecated: Use ImportWith with ImportOptions.Key, Import only looks the keys up in the id column.
 	Import(Ctx, []*T, func(item *T) string) (int, error)
+	ImportWith(Ctx, []*T, ImportOptions[T]) (ImportResult, error)
+	Upsert(Ctx, *T, UpsertOptions) error
+	UpsertAll(Ctx, []*T, UpsertOptions) error
 	FindAll(Ctx) ([]*T, error)
 	FindAllSorted(Ctx, string) ([]*T, error)
 	FindById(Ctx, string) (*T, error)
 	FindByIds(ctx Ctx, values []string) ([]*T, error)
 	CountAll(Ctx) (int64, error)
+	NewQuery(Ctx) *QueryBuilder[T]
+	FindInBatches(Ctx, int, func(batch []*T) error) error
+	Iterate(Ctx, func(item *T) error) error
 }
 
 type EntityRepoImpl[T any] interface {
@@ -38,12 +46,32 @@ type EntityRepoImpl[T any] interface {
 	CountBy(Ctx, string, ...interface{}) (int64, error)
 	ExistsBy(Ctx, string, ...interface{}) (bool, error)
 	DeleteBy(Ctx, string, ...interface{}) error
+	WithBatchSize(size int) EntityRepoImpl[T]
+}
+
+// ImportOptions configures EntityRepo.ImportWith
+type ImportOptions[T any] struct {
+	// Key is the column identifying the entities, "id" when empty
+	Key string
+	// KeyOf returns the Key value of an entity, its Id field when nil
+	KeyOf func(item *T) string
+	// Update updates the existing entities instead of skipping them
+	Update bool
+	// ChunkSize is the number of keys per lookup of the existing entities
+	ChunkSize int
+}
+
+type ImportResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
 }
 
 type entityRepoImpl[T any] struct {
 	EntityRepo[T]
 	//db    DataSource
-	hooks RepoHooks[T]
+	hooks     RepoHooks[T]
+	batchSize int
 }
 
 func NewRepoImpl[T any](preCreate func(e *T)) EntityRepoImpl[T] {
@@ -51,7 +79,16 @@ func NewRepoImpl[T any](preCreate func(e *T)) EntityRepoImpl[T] {
 		hooks: RepoHooks[T]{
 			PreCreate: preCreate,
 		},
+		batchSize: DefaultBatchSize,
+	}
+}
+
+// WithBatchSize returns a repo inserting at most size entities per statement
+func (r entityRepoImpl[T]) WithBatchSize(size int) EntityRepoImpl[T] {
+	if size > 0 {
+		r.batchSize = size
 	}
+	return r
 }
 
 // +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
@@ -62,26 +99,77 @@ func (r entityRepoImpl[T]) CreateAll(ctx Ctx, entities []*T) error {
 	for _, e := range entities {
 		r.hooks.PreCreate(e)
 	}
-	return ctx.db.Create(entities)
+	if len(entities) == 0 {
+		return nil
+	}
+	if err := ctx.CurrentDB().CreateInBatches(entities, r.batchSize); err != nil {
+		return err
+	}
+	for _, e := range entities {
+		if err := ctx.RecordAudit(AuditCreate, nil, e); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r entityRepoImpl[T]) Create(ctx Ctx, record *T) error {
 	r.hooks.PreCreate(record)
-	return ctx.db.Create(&record)
+	if err := ctx.CurrentDB().Create(&record); err != nil {
+		return err
+	}
+	return ctx.RecordAudit(AuditCreate, nil, record)
 }
 
 func (r entityRepoImpl[T]) Update(ctx Ctx, data *T) error {
-	return ctx.db.Save(&data)
+	before, err := r.auditedState(ctx, data)
+	if err != nil {
+		return err
+	}
+	if err := ctx.CurrentDB().Save(&data); err != nil {
+		return err
+	}
+	return r.auditSave(ctx, before, data)
 }
 
 func (r entityRepoImpl[T]) UpdateAll(ctx Ctx, data []*T) error {
-	return ctx.db.Save(&data)
+	before := make([]*T, len(data))
+	for i, item := range data {
+		state, err := r.auditedState(ctx, item)
+		if err != nil {
+			return err
+		}
+		before[i] = state
+	}
+	if err := ctx.CurrentDB().Save(&data); err != nil {
+		return err
+	}
+	for i, item := range data {
+		if err := r.auditSave(ctx, before[i], item); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r entityRepoImpl[T]) DeleteBy(ctx Ctx, where string, args ...interface{}) error {
+	var deleted []*T
+	if ctx.AuditEnabled() {
+		var err error
+		if deleted, err = r.FindBy(ctx, where, args...); err != nil {
+			return err
+		}
+	}
 	var model T
-	_, err := ctx.db.Delete(&model, Query{W: where, Args: args})
-	return err
+	if _, err := ctx.CurrentDB().Delete(&model, Query{W: where, Args: args}); err != nil {
+		return err
+	}
+	for _, item := range deleted {
+		if err := ctx.RecordAudit(AuditDelete, item, nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r entityRepoImpl[T]) DeleteById(ctx Ctx, value string) error {
@@ -89,9 +177,25 @@ func (r entityRepoImpl[T]) DeleteById(ctx Ctx, value string) error {
 }
 
 func (r entityRepoImpl[T]) Patch(ctx Ctx, id string, value map[string]interface{}) error {
+	var before *T
+	if ctx.AuditEnabled() {
+		var err error
+		if before, err = r.FindById(ctx, id); err != nil {
+			return err
+		}
+	}
 	var model T
-	_, err := ctx.db.Patch(model, id, value)
-	return err
+	if _, err := ctx.CurrentDB().Patch(model, id, value); err != nil {
+		return err
+	}
+	if before == nil {
+		return nil
+	}
+	after, err := r.FindById(ctx, id)
+	if err != nil {
+		return err
+	}
+	return ctx.RecordAudit(AuditUpdate, before, after)
 }
 
 func (r entityRepoImpl[T]) Merge(ctx Ctx, id string, merger func(target *T)) (*T, error) {
@@ -102,77 +206,201 @@ func (r entityRepoImpl[T]) Merge(ctx Ctx, id string, merger func(target *T)) (*T
 	beforeMerge := *loaded
 	merger(loaded)
 	if &beforeMerge != loaded {
-		err = ctx.db.Save(loaded)
+		err = ctx.CurrentDB().Save(loaded)
+	}
+	if err == nil {
+		err = ctx.RecordAudit(AuditUpdate, &beforeMerge, loaded)
 	}
 	return loaded, err
 }
 
+// Import creates the items whose getId value is not in the id column yet.
+//
+// Deprecated: Use ImportWith with ImportOptions.Key. Import used to compare getId of the
+// items with getId of every stored entity, it now looks the values up in the id column
+// only: a getId returning another field (e.g. a SKU) must use ImportWith with its column.
 func (r entityRepoImpl[T]) Import(ctx Ctx, items []*T, getId func(item *T) string) (int, error) {
+	result, err := r.ImportWith(ctx, items, ImportOptions[T]{KeyOf: getId})
+	return result.Created, err
+}
 
-	existsing, err := r.FindAll(ctx) //TODO: fetch only ids
-
-	if err != nil {
-		return 0, err
+// ImportWith creates the new items and updates (or skips) the existing ones, the existing
+// keys are looked up by chunks. An item whose key appears twice is imported once.
+func (r entityRepoImpl[T]) ImportWith(ctx Ctx, items []*T, opts ImportOptions[T]) (ImportResult, error) {
+	var result ImportResult
+	key := opts.Key
+	if key == "" {
+		key = "id"
+	}
+	if !columnPattern.MatchString(key) {
+		return result, fmt.Errorf("invalid column name: %q", key)
+	}
+	keyOf := opts.KeyOf
+	if keyOf == nil {
+		keyOf = func(item *T) string { return auditEntityId(item) }
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = r.batchSize
 	}
 
-	existingIds := funk.Map(existsing, func(item *T) string {
-		value := getId(item)
-		return value
-	}).([]string)
+	existing := map[string]bool{}
+	keys := funk.UniqString(funk.Map(items, keyOf).([]string))
+	for start := 0; start < len(keys); start += chunkSize {
+		end := start + chunkSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		var found []string
+		err := ctx.CurrentDB().Find(&found, Query{
+			Model:  new(T),
+			Select: key,
+			W:      key + " IN ?",
+			Args:   []any{keys[start:end]},
+		})
+		if err != nil {
+			return result, err
+		}
+		for _, value := range found {
+			existing[value] = true
+		}
+	}
 
-	toBeAdded := funk.Filter(items, func(item *T) bool {
-		itemId := getId(item)
-		return !funk.Contains(existingIds, itemId)
-	}).([]*T)
+	var toCreate, toUpdate []*T
+	seen := map[string]bool{}
+	for _, item := range items {
+		value := keyOf(item)
+		switch {
+		case seen[value]:
+			result.Skipped++
+		case !existing[value]:
+			toCreate = append(toCreate, item)
+		case opts.Update:
+			toUpdate = append(toUpdate, item)
+		default:
+			result.Skipped++
+		}
+		seen[value] = true
+	}
 
-	if len(toBeAdded) > 0 {
-		for _, item := range toBeAdded {
-			r.hooks.PreCreate(item)
+	if err := r.CreateAll(ctx, toCreate); err != nil {
+		return result, err
+	}
+	result.Created = len(toCreate)
+	if len(toUpdate) > 0 {
+		if err := r.UpsertAll(ctx, toUpdate, UpsertOptions{Conflict: []string{key}}); err != nil {
+			return result, err
 		}
-		err = r.CreateAll(ctx, toBeAdded)
+		result.Updated = len(toUpdate)
+	}
+	return result, nil
+}
+
+// Upsert inserts the entity or updates it when it conflicts with an existing row
+func (r entityRepoImpl[T]) Upsert(ctx Ctx, entity *T, opts UpsertOptions) error {
+	return r.UpsertAll(ctx, []*T{entity}, opts)
+}
+
+func (r entityRepoImpl[T]) UpsertAll(ctx Ctx, entities []*T, opts UpsertOptions) error {
+	if len(entities) == 0 {
+		return nil
+	}
+	before := make([]*T, len(entities))
+	for i, e := range entities {
+		r.hooks.PreCreate(e)
+		state, err := r.auditedState(ctx, e)
 		if err != nil {
-			return 0, err
+			return err
 		}
+		before[i] = state
 	}
-	return len(toBeAdded), nil
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = r.batchSize
+	}
+	if _, err := ctx.CurrentDB().Upsert(entities, opts); err != nil {
+		return err
+	}
+	for i, e := range entities {
+		if err := r.auditSave(ctx, before[i], e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditedState loads the stored version of an entity before it is saved, when the audit is enabled
+func (r entityRepoImpl[T]) auditedState(ctx Ctx, entity *T) (*T, error) {
+	if !ctx.AuditEnabled() {
+		return nil, nil
+	}
+	id := auditEntityId(entity)
+	if id == "" {
+		return nil, nil
+	}
+	return r.FindById(ctx, id)
+}
+
+// auditSave records a Save, which creates the entity when it was not stored yet
+func (r entityRepoImpl[T]) auditSave(ctx Ctx, before *T, after *T) error {
+	if before == nil {
+		return ctx.RecordAudit(AuditCreate, nil, after)
+	}
+	return ctx.RecordAudit(AuditUpdate, before, after)
 }
 
 // +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
 // QUERIES
 // +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
 
+// NewQuery starts a typed query on the entities, e.g.
+//
+//	repo.NewQuery(ctx).Where("status = ?", "active").Order("created_at", true).Paginate(1, 20)
+func (r entityRepoImpl[T]) NewQuery(ctx Ctx) *QueryBuilder[T] {
+	return NewQueryBuilder[T](ctx)
+}
+
+// FindInBatches loads the entities size at a time, see QueryBuilder.FindInBatches
+func (r entityRepoImpl[T]) FindInBatches(ctx Ctx, size int, fn func(batch []*T) error) error {
+	return r.NewQuery(ctx).FindInBatches(size, fn)
+}
+
+// Iterate streams the entities one at a time, see QueryBuilder.Iterate
+func (r entityRepoImpl[T]) Iterate(ctx Ctx, fn func(item *T) error) error {
+	return r.NewQuery(ctx).Iterate(fn)
+}
+
 func (r entityRepoImpl[T]) ExistsBy(ctx Ctx, where string, args ...interface{}) (bool, error) {
 	var model T
-	return ctx.db.Exists(model, Query{W: where, Args: args})
+	return ctx.CurrentDB().Exists(model, Query{W: where, Args: args})
 }
 
 func (r entityRepoImpl[T]) FindAll(ctx Ctx) ([]*T, error) {
 	var model []*T
-	err := ctx.db.Find(&model, Query{})
+	err := ctx.CurrentDB().Find(&model, Query{})
 	return model, err
 }
 
 func (r entityRepoImpl[T]) FindAllSorted(ctx Ctx, orderBy string) ([]*T, error) {
 	var model []*T
-	err := ctx.db.Find(&model, Query{Sort: orderBy})
+	err := ctx.CurrentDB().Find(&model, Query{Sort: orderBy})
 	return model, err
 }
 
 func (r entityRepoImpl[T]) FindByInto(ctx Ctx, target any, where string, args ...interface{}) error {
 	var model []*T
-	err := ctx.db.Find(&target, Query{W: where, Args: args, Model: model})
+	err := ctx.CurrentDB().Find(&target, Query{W: where, Args: args, Model: model})
 	return err
 }
 
 func (r entityRepoImpl[T]) FindBy(ctx Ctx, where string, args ...interface{}) ([]*T, error) {
 	var model []*T
-	err := ctx.db.Find(&model, Query{W: where, Args: args})
+	err := ctx.CurrentDB().Find(&model, Query{W: where, Args: args})
 	return model, err
 }
 
 func (r entityRepoImpl[T]) FindBySorted(ctx Ctx, sort string, where string, args ...interface{}) ([]*T, error) {
 	var model []*T
-	err := ctx.db.Find(&model, Query{W: where, Args: args, Sort: sort})
+	err := ctx.CurrentDB().Find(&model, Query{W: where, Args: args, Sort: sort})
 	return model, err
 }
 
@@ -186,7 +414,7 @@ func (r entityRepoImpl[T]) FindByIds(ctx Ctx, ids []string) ([]*T, error) {
 
 func (r entityRepoImpl[T]) FirstBy(ctx Ctx, where string, args ...interface{}) (*T, error) {
 	var model T
-	err := ctx.db.First(&model, Query{W: where, Args: args})
+	err := ctx.CurrentDB().First(&model, Query{W: where, Args: args})
 	if serrors.Is(err, ErrRecordNotFound) {
 		return nil, nil
 	}
@@ -195,19 +423,19 @@ func (r entityRepoImpl[T]) FirstBy(ctx Ctx, where string, args ...interface{}) (
 
 func (r entityRepoImpl[T]) CountBy(ctx Ctx, where string, args ...interface{}) (int64, error) {
 	var model T
-	return ctx.db.Count(model, Query{W: where, Args: args})
+	return ctx.CurrentDB().Count(model, Query{W: where, Args: args})
 }
 
 func (r entityRepoImpl[T]) CountAll(ctx Ctx) (int64, error) {
 	var model T
-	return ctx.db.Count(model, Query{})
+	return ctx.CurrentDB().Count(model, Query{})
 }
 
 func (r entityRepoImpl[T]) Query(ctx Ctx, target interface{}, raw string, args ...interface{}) error {
 	if !h.IsPointer(target) {
 		panic("target must be a pointer")
 	}
-	return ctx.db.Find(target, Query{
+	return ctx.CurrentDB().Find(target, Query{
 		Raw:  raw,
 		Args: args,
 	})
@@ -215,6 +443,6 @@ func (r entityRepoImpl[T]) Query(ctx Ctx, target interface{}, raw string, args .
 
 func (r entityRepoImpl[T]) Raw(ctx Ctx, raw string, args ...interface{}) error {
 	var m = new(T)
-	_, err := ctx.db.Execute(m, Query{Model: m, Raw: raw, Args: args})
+	_, err := ctx.CurrentDB().Execute(m, Query{Model: m, Raw: raw, Args: args})
 	return err
 }
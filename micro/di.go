@@ -1,2 +1,132 @@
 package micro
 
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/soffa-projects/go-micro/di"
+)
+
+// ScopeKey is the echo context key of the request scope
+const ScopeKey = "di.scope"
+
+// Provide registers a component constructor in the application container,
+// see di.Container.Provide
+func (app *App) Provide(constructor any, opts ...di.Option) error {
+	return app.container().Provide(constructor, opts...)
+}
+
+// Invoke calls fn with its arguments resolved from the application container
+func (app *App) Invoke(fn any) error {
+	return app.container().Invoke(fn)
+}
+
+func (app *App) container() *di.Container {
+	if app.Container == nil {
+		app.Container = di.New()
+	}
+	if app.Env != nil {
+		app.Env.Container = app.Container
+	}
+	return app.Container
+}
+
+// Resolve returns a singleton of the application container
+func Resolve[T any](app *App) (T, error) {
+	return di.ResolveFrom[T](app.container())
+}
+
+// Inject returns a component (singleton or request scoped) for the current context
+func Inject[T any](ctx Ctx) (T, error) {
+	return di.ResolveFrom[T](ctx.Scope())
+}
+
+// Scope returns the request scope of the context, it is closed when the http request completes.
+// Outside of an http request (jobs, events), a new scope is returned on each call.
+func (ctx Ctx) Scope() *di.Scope {
+	var container *di.Container
+	if ctx.Env != nil {
+		container = ctx.Env.Container
+	}
+	if container == nil {
+		container = di.New()
+	}
+	c, ok := ctx.Wrapped.(echo.Context)
+	if !ok {
+		return newRequestScope(container, ctx)
+	}
+	if scope, ok := c.Get(ScopeKey).(*di.Scope); ok {
+		return scope
+	}
+	scope := newRequestScope(container, ctx)
+	c.Set(ScopeKey, scope)
+	return scope
+}
+
+// BindScope replaces the request scope by a new one bound to ctx, the components of the
+// previous scope are stopped. The implicit transaction of a route binds a scope to each
+// attempt, so that a retry never uses the components of a rolled back attempt.
+func (ctx Ctx) BindScope() *di.Scope {
+	if c, ok := ctx.Wrapped.(echo.Context); ok {
+		if previous, ok := c.Get(ScopeKey).(*di.Scope); ok {
+			if err := previous.Close(ctx.Context()); err != nil {
+				log.Errorf("unable to close request scope: %s", err)
+			}
+		}
+		c.Set(ScopeKey, nil)
+	}
+	return ctx.Scope()
+}
+
+func newRequestScope(container *di.Container, ctx Ctx) *di.Scope {
+	scope := container.NewScope()
+	scope.Set(ctx)
+	return scope
+}
+
+// registerEnvComponents makes the components configured by adapters.NewApp injectable
+func (app *App) registerEnvComponents() error {
+	c := app.container()
+	env := app.Env
+	errs := []error{
+		c.Supply(app),
+		c.Supply(env),
+		di.Declare[Ctx](c),
+	}
+	// the components are also registered under their name for di.ResolveByName (deprecated)
+	if env.Scheduler != nil {
+		di.Register(SchedulerService, env.Scheduler)
+		errs = append(errs, di.SupplyAs[Scheduler](c, env.Scheduler))
+	}
+	if env.TokenProvider != nil {
+		di.Register(TokenProviderService, env.TokenProvider)
+		errs = append(errs, di.SupplyAs[TokenProvider](c, env.TokenProvider))
+	}
+	if env.Mailer != nil {
+		di.Register(MailerServer, env.Mailer)
+		errs = append(errs, di.SupplyAs[Mailer](c, env.Mailer))
+	}
+	if env.Notifier != nil {
+		di.Register(Notifications, env.Notifier)
+		errs = append(errs, di.SupplyAs[NotificationService](c, env.Notifier))
+	}
+	if env.RedisClient != nil {
+		errs = append(errs, c.Supply(env.RedisClient))
+	}
+	if env.Health != nil {
+		errs = append(errs, c.Supply(env.Health))
+	}
+	if env.Flags != nil {
+		errs = append(errs, c.Supply(env.Flags))
+	}
+	if env.Metrics != nil {
+		errs = append(errs, c.Supply(env.Metrics))
+	}
+	if env.Audit != nil {
+		errs = append(errs, c.Supply(env.Audit))
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package micro
+
+import (
+	"context"
+	"github.com/soffa-projects/go-micro/util/errors"
+	"time"
+)
+
+// ErrStaleEntity is returned when a Versioned entity was modified since it was loaded
+var ErrStaleEntity = errors.Conflict("stale_entity")
+
+// Audit is embedded by the entities that track who created and updated them. The
+// timestamps are set by the datasource, the users are read from the Ctx authentication.
+type Audit struct {
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+}
+
+// SoftDelete is embedded by the entities that are flagged instead of deleted, the
+// deleted rows are excluded from every query unless Query.Unscoped is set.
+type SoftDelete struct {
+	DeletedAt *time.Time `json:"deleted_at,omitempty" gorm:"index"`
+	DeletedBy *string    `json:"deleted_by,omitempty"`
+}
+
+// Versioned is embedded by the entities protected by optimistic locking, an update of
+// an outdated version fails with ErrStaleEntity.
+type Versioned struct {
+	Version int64 `json:"version"`
+}
+
+// AuditedEntity is implemented by the entities embedding Audit
+type AuditedEntity interface {
+	auditConvention()
+}
+
+// SoftDeletedEntity is implemented by the entities embedding SoftDelete
+type SoftDeletedEntity interface {
+	softDeleteConvention()
+}
+
+// VersionedEntity is implemented by the entities embedding Versioned
+type VersionedEntity interface {
+	versionConvention()
+}
+
+func (Audit) auditConvention() {}
+
+func (SoftDelete) softDeleteConvention() {}
+
+func (Versioned) versionConvention() {}
+
+type actorKey struct{}
+
+// WithActor returns a context carrying the user recorded in the audit columns
+func WithActor(ctx context.Context, actor string) context.Context {
+	if actor == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the user set by WithActor
+func ActorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// Actor returns the user identifier recorded in the audit columns
+func (ctx Ctx) Actor() string {
+	if ctx.Auth == nil || !ctx.Auth.Authenticated {
+		return ""
+	}
+	if ctx.Auth.UserId != "" {
+		return ctx.Auth.UserId
+	}
+	if ctx.Auth.Username != "" {
+		return ctx.Auth.Username
+	}
+	return ctx.Auth.Email
+}
+
+// dbContext is the context given to the datasource, it carries the actor
+func (ctx Ctx) dbContext() context.Context {
+	return WithActor(ctx.Context(), ctx.Actor())
+}
@@ -1,17 +1,23 @@
 package micro
 
 import (
+	"context"
 	"github.com/asaskevich/EventBus"
-	"github.com/google/martian/v3/log"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var impl = EventBus.New()
 
+var eventsLog = Logger("micro.events")
+
 type Event struct {
-	Subject string
-	Event   string
-	Error   string
-	Data    interface{}
+	Subject      string
+	Event        string
+	Error        string
+	Data         interface{}
+	TraceContext map[string]string
 }
 
 type SubscribeFunc = func(ctx Ctx, payload Event) error
@@ -19,21 +25,42 @@ type SubscribeFunc = func(ctx Ctx, payload Event) error
 func Subscribe(topic string, handle SubscribeFunc) error {
 	//ctx := micro.CurrentContext()
 	return impl.Subscribe(topic, func(ctx Ctx, payload Event) {
-		if err := handle(ctx, payload); err != nil {
-			log.Errorf("error handling event: %s", err)
-		}
+		handleEvent(topic, handle, ctx, payload, false)
 	})
 }
 
 func SubscribeAsync(topic string, handle SubscribeFunc) error {
 	//return impl.SubscribeAsync(topic, handle, false)
 	return impl.SubscribeAsync(topic, func(ctx Ctx, payload Event) {
-		if err := handle(ctx, payload); err != nil {
-			log.Errorf("error handling event: %s", err)
-		}
+		handleEvent(topic, handle, ctx, payload, true)
 	}, false)
 }
 
+// handleEvent runs handle with the context of the publisher (deadline and cancellation), the
+// async handlers only keep its trace and run outside of its transaction since they may outlive it
+func handleEvent(topic string, handle SubscribeFunc, ctx Ctx, payload Event, async bool) {
+	parent := ctx.Context()
+	if async {
+		parent = context.Background()
+		ctx = ctx.withoutTx()
+	}
+	ctx = ctx.WithContext(payload.extractTraceContext(parent))
+	ctx, span := ctx.StartSpan("event "+topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(semconv.MessagingDestinationName(topic)),
+	)
+	defer span.End()
+	err := handle(ctx, payload)
+	if err != nil {
+		ctx.LogWith(eventsLog).WithField("topic", topic).Errorf("error handling event: %s", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	if ctx.Env != nil {
+		ctx.Env.Metrics.EventHandled(topic, err)
+	}
+}
+
 func SendNotification(ctx Ctx, event Notification) {
 	Publish(ctx, NotificationTopic, Event{
 		Event: event.Message,
@@ -42,8 +69,12 @@ func SendNotification(ctx Ctx, event Notification) {
 
 func Publish(ctx Ctx, topic string, payload Event) {
 	if payload.Error != "" {
-		log.Errorf(payload.Error)
+		ctx.LogWith(eventsLog).WithField("topic", topic).Error(payload.Error)
+	}
+	if ctx.Env != nil {
+		ctx.Env.Metrics.EventPublished(topic)
 	}
+	payload.injectTraceContext(ctx.Context())
 	impl.Publish(topic, ctx, payload)
 }
 
@@ -51,6 +82,21 @@ func WaitAsync() {
 	impl.WaitAsync()
 }
 
+// WaitAsyncContext waits for the async handlers to complete, or until ctx is done
+func WaitAsyncContext(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		impl.WaitAsync()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func Reset() {
 	impl.WaitAsync()
 	impl = EventBus.New()
@@ -0,0 +1,116 @@
+package micro
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConfigurationError reports every feature that failed to configure
+type ConfigurationError struct {
+	Errors []FeatureError
+}
+
+type FeatureError struct {
+	Feature string
+	Err     error
+}
+
+func (e FeatureError) Error() string {
+	if e.Feature == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("feature %s: %s", e.Feature, e.Err)
+}
+
+func (e FeatureError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConfigurationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = "  - " + err.Error()
+	}
+	return fmt.Sprintf("application configuration failed:\n%s", strings.Join(messages, "\n"))
+}
+
+func (e *ConfigurationError) add(feature string, err error) {
+	e.Errors = append(e.Errors, FeatureError{Feature: feature, Err: err})
+}
+
+func (e *ConfigurationError) orNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// sortFeatures orders the features so that each one comes after its dependencies,
+// the declaration order is kept for independent features. The configured features
+// (from a previous call to App.Configure) are satisfied dependencies.
+func sortFeatures(features []Feature, configured []Feature) ([]Feature, error) {
+	byName := map[string]int{}
+	existing := map[string]bool{}
+	for _, feat := range configured {
+		existing[feat.Name] = true
+	}
+	for i, feat := range features {
+		if feat.Name == "" {
+			continue
+		}
+		if _, exists := byName[feat.Name]; exists || existing[feat.Name] {
+			return nil, fmt.Errorf("feature %s is declared twice", feat.Name)
+		}
+		byName[feat.Name] = i
+	}
+	for _, feat := range features {
+		for _, dep := range feat.DependsOn {
+			if _, ok := byName[dep]; !ok && !existing[dep] {
+				return nil, fmt.Errorf("feature %s depends on unknown feature %s", feat.Name, dep)
+			}
+		}
+	}
+
+	sorted := make([]Feature, 0, len(features))
+	done := make([]bool, len(features))
+	for len(sorted) < len(features) {
+		progress := false
+		for i, feat := range features {
+			if done[i] || !allDone(feat.DependsOn, byName, done) {
+				continue
+			}
+			done[i] = true
+			sorted = append(sorted, feat)
+			progress = true
+			break
+		}
+		if !progress {
+			var pending []string
+			for i, feat := range features {
+				if !done[i] {
+					pending = append(pending, feat.Name)
+				}
+			}
+			return nil, fmt.Errorf("features dependency cycle: %s", strings.Join(pending, ", "))
+		}
+	}
+	return sorted, nil
+}
+
+func allDone(deps []string, byName map[string]int, done []bool) bool {
+	for _, dep := range deps {
+		if i, ok := byName[dep]; ok && !done[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func firstFailed(deps []string, failed map[string]bool) string {
+	for _, dep := range deps {
+		if failed[dep] {
+			return dep
+		}
+	}
+	return ""
+}
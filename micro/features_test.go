@@ -0,0 +1,81 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSortFeatures(t *testing.T) {
+	sorted, err := sortFeatures([]Feature{
+		{Name: "api", DependsOn: []string{"db", "cache"}},
+		{Name: "db"},
+		{Name: "cache", DependsOn: []string{"db"}},
+		{Name: "jobs"},
+	}, nil)
+	assert.Nil(t, err)
+	names := make([]string, len(sorted))
+	for i, feat := range sorted {
+		names[i] = feat.Name
+	}
+	assert.Equal(t, []string{"db", "cache", "api", "jobs"}, names)
+
+	_, err = sortFeatures([]Feature{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}}, nil)
+	assert.ErrorContains(t, err, "cycle")
+	_, err = sortFeatures([]Feature{{Name: "a", DependsOn: []string{"missing"}}}, nil)
+	assert.ErrorContains(t, err, "unknown feature missing")
+	_, err = sortFeatures([]Feature{{Name: "a", DependsOn: []string{"db"}}}, []Feature{{Name: "db"}})
+	assert.Nil(t, err)
+}
+
+func TestConfigureCollectsErrors(t *testing.T) {
+	app := &App{Env: &Env{}}
+	err := app.Configure([]Feature{
+		{Name: "db", Configure: func(app *App) error { return errors.New("no database") }},
+		{Name: "api", DependsOn: []string{"db"}, Configure: func(app *App) error { return nil }},
+		{Name: "mailer", Configure: func(app *App) error { return errors.New("no api key") }},
+	})
+	var configErr *ConfigurationError
+	assert.True(t, errors.As(err, &configErr))
+	assert.Len(t, configErr.Errors, 3)
+	assert.Contains(t, err.Error(), "feature db: no database")
+	assert.Contains(t, err.Error(), "feature api: skipped, dependency db failed")
+	assert.Contains(t, err.Error(), "feature mailer: no api key")
+
+	// the failed features are never started
+	assert.Empty(t, app.features)
+}
+
+func TestFeaturesLifecycle(t *testing.T) {
+	var events []string
+	feature := func(name string, deps ...string) Feature {
+		return Feature{
+			Name:      name,
+			DependsOn: deps,
+			Configure: func(app *App) error {
+				events = append(events, "configure "+name)
+				return nil
+			},
+			Start: func(ctx context.Context) error {
+				events = append(events, "start "+name)
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				events = append(events, "stop "+name)
+				return nil
+			},
+		}
+	}
+	app := &App{Env: &Env{}}
+	assert.Nil(t, app.Configure([]Feature{feature("api", "db"), feature("db")}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Nil(t, app.Serve(ctx))
+	assert.Equal(t, []string{
+		"configure db", "configure api",
+		"start db", "start api",
+		"stop api", "stop db",
+	}, events)
+}
@@ -0,0 +1,484 @@
+package micro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const DefaultFlagsRefreshInterval = 30 * time.Second
+const DefaultFlagsTable = "feature_flags"
+const DefaultFlagsRedisKey = "feature_flags"
+const DefaultFlagsRedisChannel = "feature_flags_changed"
+
+const (
+	FlagSourceFile  = "file"
+	FlagSourceDB    = "db"
+	FlagSourceRedis = "redis"
+)
+
+// FlagRule overrides the flag for the tenants and users it matches. When Percentage
+// is set, only this share of the matched users (or tenants) is selected.
+type FlagRule struct {
+	Tenants    []string `json:"tenants,omitempty" yaml:"tenants" toml:"tenants"`
+	Users      []string `json:"users,omitempty" yaml:"users" toml:"users"`
+	Percentage int      `json:"percentage,omitempty" yaml:"percentage" toml:"percentage"`
+	Enabled    bool     `json:"enabled" yaml:"enabled" toml:"enabled"`
+	Value      any      `json:"value,omitempty" yaml:"value" toml:"value"`
+}
+
+// Flag is a feature flag (Enabled) or a dynamic setting (Value), the first matching rule wins
+type Flag struct {
+	Name        string     `json:"name" yaml:"name" toml:"name"`
+	Description string     `json:"description,omitempty" yaml:"description" toml:"description"`
+	Enabled     bool       `json:"enabled" yaml:"enabled" toml:"enabled"`
+	Value       any        `json:"value,omitempty" yaml:"value" toml:"value"`
+	Rules       []FlagRule `json:"rules,omitempty" yaml:"rules" toml:"rules"`
+}
+
+// FlagSource loads flags definitions, the flags it returns override the defaults
+type FlagSource interface {
+	Load(ctx context.Context) ([]Flag, error)
+}
+
+// FlagWatcher can be implemented by a source able to notify changes, the registry reloads
+// the flags each time notify is called. Watch blocks until ctx is done.
+type FlagWatcher interface {
+	Watch(ctx context.Context, notify func()) error
+}
+
+// FlagRegistry holds the feature flags, their defaults are declared with Define and
+// updated at runtime from the sources
+type FlagRegistry struct {
+	RefreshInterval time.Duration
+	mu              sync.RWMutex
+	defaults        map[string]Flag
+	flags           map[string]Flag
+	sources         []FlagSource
+	// loaded are the flags of the last successful load of each source
+	loaded    [][]Flag
+	listeners []func()
+}
+
+func NewFlagRegistry() *FlagRegistry {
+	return &FlagRegistry{
+		RefreshInterval: DefaultFlagsRefreshInterval,
+		defaults:        map[string]Flag{},
+		flags:           map[string]Flag{},
+	}
+}
+
+// Define declares a flag with its default value
+func (r *FlagRegistry) Define(flags ...Flag) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, flag := range flags {
+		r.defaults[flag.Name] = flag
+		if _, ok := r.flags[flag.Name]; !ok {
+			r.flags[flag.Name] = flag
+		}
+	}
+}
+
+// AddSource registers a source, the sources are applied in registration order (the last one wins)
+func (r *FlagRegistry) AddSource(source FlagSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources = append(r.sources, source)
+	r.loaded = append(r.loaded, nil)
+}
+
+// OnChange registers a listener called after a reload that changed the flags
+func (r *FlagRegistry) OnChange(listener func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, listener)
+}
+
+// Reload loads the flags from every source. When a source fails, the flags it provided
+// by its last successful load are kept and the error is returned.
+func (r *FlagRegistry) Reload(ctx context.Context) error {
+	r.mu.RLock()
+	sources := r.sources
+	loaded := make([][]Flag, len(r.loaded))
+	copy(loaded, r.loaded)
+	r.mu.RUnlock()
+
+	var err error
+	for i, source := range sources {
+		flags, loadErr := source.Load(ctx)
+		if loadErr != nil {
+			err = fmt.Errorf("unable to load flags from %T: %w", source, loadErr)
+			continue
+		}
+		loaded[i] = flags
+	}
+
+	r.mu.Lock()
+	flags := make(map[string]Flag, len(r.defaults))
+	for name, flag := range r.defaults {
+		flags[name] = flag
+	}
+	for _, sourceFlags := range loaded {
+		for _, flag := range sourceFlags {
+			if _, ok := flags[flag.Name]; !ok {
+				log.Warnf("flag %s is not defined by the application", flag.Name)
+			}
+			flags[flag.Name] = flag
+		}
+	}
+	changed := !reflect.DeepEqual(r.flags, flags)
+	r.flags = flags
+	r.loaded = loaded
+	listeners := r.listeners
+	r.mu.Unlock()
+
+	if changed {
+		log.Infof("%d feature flags loaded", len(flags))
+		for _, listener := range listeners {
+			listener()
+		}
+	}
+	return err
+}
+
+// Watch reloads the flags when a source notifies a change, and every RefreshInterval
+// for the other sources, until ctx is done
+func (r *FlagRegistry) Watch(ctx context.Context) {
+	r.mu.RLock()
+	sources := r.sources
+	r.mu.RUnlock()
+	polling := false
+	reload := func() {
+		if err := r.Reload(ctx); err != nil {
+			log.Error(err)
+		}
+	}
+	for _, source := range sources {
+		if watcher, ok := source.(FlagWatcher); ok {
+			go func() {
+				if err := watcher.Watch(ctx, reload); err != nil && ctx.Err() == nil {
+					log.Errorf("flags watcher stopped: %s", err)
+				}
+			}()
+		} else {
+			polling = true
+		}
+	}
+	if !polling || r.RefreshInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(r.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reload()
+			}
+		}
+	}()
+}
+
+// Get returns the current definition of a flag
+func (r *FlagRegistry) Get(name string) (Flag, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	flag, ok := r.flags[name]
+	return flag, ok
+}
+
+// All returns the current definition of every flag
+func (r *FlagRegistry) All() []Flag {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := make([]Flag, 0, len(r.flags))
+	for _, flag := range r.flags {
+		result = append(result, flag)
+	}
+	return result
+}
+
+// Enabled evaluates a flag for a tenant and a user, unknown flags are disabled
+func (r *FlagRegistry) Enabled(name string, tenant string, user string) bool {
+	enabled, _ := r.evaluate(name, tenant, user)
+	return enabled
+}
+
+// Value evaluates the value of a flag for a tenant and a user
+func (r *FlagRegistry) Value(name string, tenant string, user string) any {
+	_, value := r.evaluate(name, tenant, user)
+	return value
+}
+
+func (r *FlagRegistry) evaluate(name string, tenant string, user string) (bool, any) {
+	if r == nil {
+		return false, nil
+	}
+	flag, ok := r.Get(name)
+	if !ok {
+		log.Debugf("unknown flag: %s", name)
+		return false, nil
+	}
+	for _, rule := range flag.Rules {
+		if rule.matches(flag.Name, tenant, user) {
+			value := rule.Value
+			if value == nil {
+				value = flag.Value
+			}
+			return rule.Enabled, value
+		}
+	}
+	return flag.Enabled, flag.Value
+}
+
+func (rule FlagRule) matches(name string, tenant string, user string) bool {
+	if len(rule.Tenants) > 0 && !containsString(rule.Tenants, tenant) {
+		return false
+	}
+	if len(rule.Users) > 0 && !containsString(rule.Users, user) {
+		return false
+	}
+	if rule.Percentage > 0 && rule.Percentage < 100 {
+		key := user
+		if key == "" {
+			key = tenant
+		}
+		return flagBucket(name, key) < rule.Percentage
+	}
+	return true
+}
+
+// flagBucket places a subject in one of 100 buckets, the same subject always gets the same bucket for a flag
+func flagBucket(name string, key string) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name + ":" + key))
+	return int(hash.Sum32() % 100)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// FlagEnabled evaluates a flag for the tenant and the user of the current context
+func (ctx Ctx) FlagEnabled(name string) bool {
+	if ctx.Env == nil {
+		return false
+	}
+	return ctx.Env.Flags.Enabled(name, ctx.TenantId, ctx.flagUser())
+}
+
+// FlagValue evaluates a dynamic setting for the tenant and the user of the current
+// context, def is returned when the flag has no value or the value cannot be converted
+func FlagValue[T any](ctx Ctx, name string, def T) T {
+	if ctx.Env == nil {
+		return def
+	}
+	value := ctx.Env.Flags.Value(name, ctx.TenantId, ctx.flagUser())
+	if value == nil {
+		return def
+	}
+	if typed, ok := value.(T); ok {
+		return typed
+	}
+	// values read from json/yaml do not always have the expected type (e.g. float64 for numbers)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return def
+	}
+	var result T
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Warnf("invalid value for flag %s: %s", name, err)
+		return def
+	}
+	return result
+}
+
+func (ctx Ctx) flagUser() string {
+	if ctx.Auth == nil {
+		return ""
+	}
+	return ctx.Auth.UserId
+}
+
+// ----------------------------------------------------------------------------------------------------------------------
+// SOURCES
+// ----------------------------------------------------------------------------------------------------------------------
+
+// FileFlagSource reads flags from a yaml, toml or json file:
+//
+//	flags:
+//	  new_checkout:
+//	    enabled: false
+//	    rules:
+//	      - tenants: [acme]
+//	        enabled: true
+type FileFlagSource struct {
+	FS   fs.FS
+	Path string
+}
+
+func (s FileFlagSource) Load(_ context.Context) ([]Flag, error) {
+	data, err := fs.ReadFile(s.FS, s.Path)
+	if err != nil {
+		return nil, err
+	}
+	var content struct {
+		Flags map[string]Flag `json:"flags" yaml:"flags" toml:"flags"`
+	}
+	switch path.Ext(s.Path) {
+	case ".toml":
+		err = toml.Unmarshal(data, &content)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &content)
+	case ".json":
+		err = json.Unmarshal(data, &content)
+	default:
+		return nil, fmt.Errorf("unsupported flags file format: %s", s.Path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", s.Path, err)
+	}
+	return namedFlags(content.Flags), nil
+}
+
+// DataSourceFlagSource reads flags from a table, value and rules are stored as json:
+//
+//	CREATE TABLE feature_flags (
+//	  name    VARCHAR(255) PRIMARY KEY,
+//	  enabled BOOLEAN NOT NULL DEFAULT FALSE,
+//	  value   TEXT,
+//	  rules   TEXT
+//	);
+type DataSourceFlagSource struct {
+	DB    DataSource
+	Table string
+}
+
+type flagRow struct {
+	Name    string
+	Enabled bool
+	Value   *string
+	Rules   *string
+}
+
+func (s DataSourceFlagSource) Load(ctx context.Context) ([]Flag, error) {
+	table := s.Table
+	if table == "" {
+		table = DefaultFlagsTable
+	}
+	var rows []flagRow
+	err := s.DB.WithContext(ctx).Find(&rows, Query{
+		Raw: fmt.Sprintf("SELECT name, enabled, value, rules FROM %s", table),
+	})
+	if err != nil {
+		return nil, err
+	}
+	flags := make([]Flag, 0, len(rows))
+	for _, row := range rows {
+		flag := Flag{Name: row.Name, Enabled: row.Enabled}
+		if row.Value != nil && *row.Value != "" {
+			if err := json.Unmarshal([]byte(*row.Value), &flag.Value); err != nil {
+				return nil, fmt.Errorf("invalid value for flag %s: %w", row.Name, err)
+			}
+		}
+		if row.Rules != nil && *row.Rules != "" {
+			if err := json.Unmarshal([]byte(*row.Rules), &flag.Rules); err != nil {
+				return nil, fmt.Errorf("invalid rules for flag %s: %w", row.Name, err)
+			}
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+// RedisFlagSource reads flags from a redis hash (one json encoded Flag per field) and
+// reloads them when a message is published on Channel. Use Set to update a flag.
+type RedisFlagSource struct {
+	Client  *redis.Client
+	Key     string
+	Channel string
+}
+
+func NewRedisFlagSource(client *redis.Client) *RedisFlagSource {
+	return &RedisFlagSource{
+		Client:  client,
+		Key:     DefaultFlagsRedisKey,
+		Channel: DefaultFlagsRedisChannel,
+	}
+}
+
+func (s *RedisFlagSource) Load(ctx context.Context) ([]Flag, error) {
+	values, err := s.Client.HGetAll(ctx, s.Key).Result()
+	if err != nil {
+		return nil, err
+	}
+	flags := make([]Flag, 0, len(values))
+	for name, value := range values {
+		var flag Flag
+		if err := json.Unmarshal([]byte(value), &flag); err != nil {
+			return nil, fmt.Errorf("invalid flag %s: %w", name, err)
+		}
+		flag.Name = name
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+// Set stores a flag and notifies every instance of the service
+func (s *RedisFlagSource) Set(ctx context.Context, flag Flag) error {
+	data, err := json.Marshal(flag)
+	if err != nil {
+		return err
+	}
+	if err := s.Client.HSet(ctx, s.Key, flag.Name, data).Err(); err != nil {
+		return err
+	}
+	return s.Client.Publish(ctx, s.Channel, flag.Name).Err()
+}
+
+func (s *RedisFlagSource) Watch(ctx context.Context, notify func()) error {
+	sub := s.Client.Subscribe(ctx, s.Channel)
+	//goland:noinspection ALL
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			notify()
+		}
+	}
+}
+
+func namedFlags(flags map[string]Flag) []Flag {
+	result := make([]Flag, 0, len(flags))
+	for name, flag := range flags {
+		flag.Name = name
+		result = append(result, flag)
+	}
+	return result
+}
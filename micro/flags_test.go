@@ -0,0 +1,79 @@
+package micro
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFlagRegistry(t *testing.T) {
+	fsys := fstest.MapFS{
+		"flags.yaml": {Data: []byte(`
+flags:
+  new_checkout:
+    enabled: false
+    rules:
+      - tenants: [acme]
+        enabled: true
+      - users: [u1]
+        enabled: true
+  page_size:
+    value: 20
+    rules:
+      - tenants: [acme]
+        value: 50
+`)},
+	}
+	flags := NewFlagRegistry()
+	flags.Define(
+		Flag{Name: "new_checkout"},
+		Flag{Name: "page_size", Value: 10},
+		Flag{Name: "dark_mode", Enabled: true},
+	)
+	assert.Equal(t, 10, flags.Value("page_size", "", ""))
+
+	changes := 0
+	flags.OnChange(func() {
+		changes++
+	})
+	flags.AddSource(FileFlagSource{FS: fsys, Path: "flags.yaml"})
+	assert.Nil(t, flags.Reload(context.Background()))
+	assert.Nil(t, flags.Reload(context.Background()))
+	assert.Equal(t, 1, changes)
+
+	assert.True(t, flags.Enabled("dark_mode", "", ""))
+	assert.False(t, flags.Enabled("new_checkout", "other", ""))
+	assert.True(t, flags.Enabled("new_checkout", "acme", ""))
+	assert.True(t, flags.Enabled("new_checkout", "other", "u1"))
+	assert.False(t, flags.Enabled("unknown", "acme", "u1"))
+
+	ctx := Ctx{TenantId: "acme", Env: &Env{Flags: flags}}
+	assert.True(t, ctx.FlagEnabled("new_checkout"))
+	assert.Equal(t, 50, FlagValue(ctx, "page_size", 0))
+	ctx.TenantId = "other"
+	assert.Equal(t, 20, FlagValue(ctx, "page_size", 0))
+	assert.Equal(t, "x", FlagValue(ctx, "unknown", "x"))
+	assert.False(t, Ctx{}.FlagEnabled("dark_mode"))
+
+	// a failing source keeps the flags of its last successful load
+	delete(fsys, "flags.yaml")
+	assert.NotNil(t, flags.Reload(context.Background()))
+	assert.Equal(t, 1, changes)
+	assert.True(t, flags.Enabled("new_checkout", "acme", ""))
+	assert.Equal(t, 50, FlagValue(Ctx{TenantId: "acme", Env: &Env{Flags: flags}}, "page_size", 0))
+}
+
+func TestFlagRolloutPercentage(t *testing.T) {
+	flags := NewFlagRegistry()
+	flags.Define(Flag{Name: "beta", Rules: []FlagRule{{Percentage: 30, Enabled: true}}})
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		user := string(rune('a'+i%26)) + string(rune('a'+i/26))
+		if flags.Enabled("beta", "", user) {
+			enabled++
+		}
+		assert.Equal(t, flags.Enabled("beta", "", user), flags.Enabled("beta", "", user))
+	}
+	assert.InDelta(t, 300, enabled, 80)
+}
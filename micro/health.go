@@ -0,0 +1,222 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"github.com/soffa-projects/go-micro/schema"
+	"sync"
+	"time"
+)
+
+const DefaultHealthCheckTimeout = 2 * time.Second
+const DefaultHealthCacheTTL = 5 * time.Second
+
+type HealthState int
+
+const (
+	HealthStarting HealthState = iota
+	HealthReady
+	HealthDraining
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthReady:
+		return "UP"
+	case HealthDraining:
+		return "DRAINING"
+	default:
+		return "STARTING"
+	}
+}
+
+// HealthCheck returns an error when the checked component is not healthy
+type HealthCheck func(ctx context.Context) error
+
+// HealthChecker can be implemented by components (mailer, notifier, ...) so that
+// they are picked up by the health registry
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// healthScope selects the checks run by a status endpoint
+type healthScope int
+
+const (
+	healthReadiness healthScope = iota
+	healthLiveness
+	healthInformational
+)
+
+type healthEntry struct {
+	name  string
+	check HealthCheck
+	scope healthScope
+}
+
+type healthResult struct {
+	status *schema.HealthStatus
+	at     time.Time
+}
+
+// HealthRegistry runs the checks registered by the features and serves the
+// liveness and readiness status of the service
+type HealthRegistry struct {
+	Timeout  time.Duration
+	CacheTTL time.Duration
+	mu       sync.RWMutex
+	entries  []healthEntry
+	state    HealthState
+	cache    map[healthScope]*healthResult
+	tenants  map[string]error
+}
+
+func NewHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{
+		Timeout:  DefaultHealthCheckTimeout,
+		CacheTTL: DefaultHealthCacheTTL,
+		state:    HealthStarting,
+		cache:    map[healthScope]*healthResult{},
+		tenants:  map[string]error{},
+	}
+}
+
+// Register adds a readiness check, the service is not ready while it fails
+func (r *HealthRegistry) Register(name string, check HealthCheck) {
+	r.register(healthEntry{name: name, check: check})
+}
+
+// RegisterLiveness adds a liveness check, a failing liveness check means the process should be restarted
+func (r *HealthRegistry) RegisterLiveness(name string, check HealthCheck) {
+	r.register(healthEntry{name: name, check: check, scope: healthLiveness})
+}
+
+// RegisterInformational adds a check reported by Check but ignored by Ready, for the
+// dependencies (upstreams, mailer, ...) the service can still serve requests without
+func (r *HealthRegistry) RegisterInformational(name string, check HealthCheck) {
+	r.register(healthEntry{name: name, check: check, scope: healthInformational})
+}
+
+func (r *HealthRegistry) register(entry healthEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	r.cache = map[healthScope]*healthResult{}
+}
+
+// SetTenantUnavailable marks a tenant unable to serve requests (e.g. its migrations failed),
+// its requests are rejected while the service stays ready for the other tenants
+func (r *HealthRegistry) SetTenantUnavailable(tenant string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tenants == nil {
+		r.tenants = map[string]error{}
+	}
+	r.tenants[tenant] = err
+}
+
+// TenantUnavailable returns why a tenant cannot serve requests, nil when it can
+func (r *HealthRegistry) TenantUnavailable(tenant string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.tenants[tenant]
+}
+
+func (r *HealthRegistry) SetState(state HealthState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = state
+}
+
+func (r *HealthRegistry) State() HealthState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state
+}
+
+// Live runs the liveness checks
+func (r *HealthRegistry) Live(ctx context.Context) (*schema.HealthStatus, bool) {
+	status := r.run(ctx, healthLiveness)
+	return status, status.Status == "UP"
+}
+
+// Check runs the readiness and the informational checks regardless of the lifecycle state,
+// a failing informational check is reported without turning the status DOWN
+func (r *HealthRegistry) Check(ctx context.Context) (*schema.HealthStatus, bool) {
+	status := r.run(ctx, healthInformational)
+	return status, status.Status == "UP"
+}
+
+// Ready runs the readiness checks, the service is never ready while starting or draining
+func (r *HealthRegistry) Ready(ctx context.Context) (*schema.HealthStatus, bool) {
+	status := r.run(ctx, healthReadiness)
+	state := r.State()
+	if state != HealthReady {
+		result := *status
+		result.Status = state.String()
+		return &result, false
+	}
+	return status, status.Status == "UP"
+}
+
+// run runs the checks of scope, the informational scope includes the readiness checks
+func (r *HealthRegistry) run(ctx context.Context, scope healthScope) *schema.HealthStatus {
+	r.mu.RLock()
+	cached := r.cache[scope]
+	entries := r.entries
+	r.mu.RUnlock()
+	if cached != nil && time.Since(cached.at) < r.CacheTTL {
+		return cached.status
+	}
+
+	status := schema.NewHealthStatus()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		if entry.scope != scope && !(scope == healthInformational && entry.scope == healthReadiness) {
+			continue
+		}
+		wg.Add(1)
+		go func(entry healthEntry) {
+			defer wg.Done()
+			err := r.check(ctx, entry)
+			mu.Lock()
+			defer mu.Unlock()
+			overall := status.Status
+			status.SetComponentStatus(entry.name, err)
+			if entry.scope == healthInformational {
+				// reported without changing the status of the service
+				status.Status = overall
+			}
+		}(entry)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	r.cache[scope] = &healthResult{status: status, at: time.Now()}
+	r.mu.Unlock()
+	return status
+}
+
+func (r *HealthRegistry) check(ctx context.Context, entry healthEntry) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				done <- fmt.Errorf("health check panicked: %v", e)
+			}
+		}()
+		done <- entry.check(ctx)
+	}()
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("health check timed out after %s", r.Timeout)
+	}
+}
@@ -0,0 +1,65 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHealthRegistry(t *testing.T) {
+	registry := NewHealthRegistry()
+	registry.Timeout = 50 * time.Millisecond
+	registry.CacheTTL = 0
+
+	calls := 0
+	registry.Register("db", func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+	registry.RegisterLiveness("loop", func(ctx context.Context) error {
+		return nil
+	})
+
+	status, ok := registry.Ready(context.Background())
+	assert.False(t, ok)
+	assert.Equal(t, "STARTING", status.Status)
+
+	registry.RegisterInformational("mailer", func(ctx context.Context) error {
+		return errors.New("smtp unreachable")
+	})
+
+	registry.SetState(HealthReady)
+	status, ok = registry.Ready(context.Background())
+	assert.True(t, ok)
+	assert.Equal(t, "UP", status.Components["db"].Status)
+	assert.NotContains(t, status.Components, "loop")
+	assert.NotContains(t, status.Components, "mailer")
+
+	status, ok = registry.Check(context.Background())
+	assert.True(t, ok)
+	assert.Equal(t, "UP", status.Status)
+	assert.Equal(t, "UP", status.Components["db"].Status)
+	assert.Equal(t, "DOWN", status.Components["mailer"].Status)
+
+	registry.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return errors.New("unreachable")
+	})
+	status, ok = registry.Ready(context.Background())
+	assert.False(t, ok)
+	assert.Equal(t, "DOWN", status.Components["slow"].Status)
+
+	registry.SetState(HealthDraining)
+	_, ok = registry.Ready(context.Background())
+	assert.False(t, ok)
+	_, ok = registry.Live(context.Background())
+	assert.True(t, ok)
+
+	registry.CacheTTL = time.Minute
+	registry.Check(context.Background())
+	before := calls
+	registry.Check(context.Background())
+	assert.Equal(t, before, calls)
+}
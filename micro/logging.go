@@ -0,0 +1,141 @@
+package micro
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"sync"
+)
+
+const LogLevel = "LOG_LEVEL"
+const LogFormat = "LOG_FORMAT"
+
+const AppLogger = "app"
+
+var log = Logger("micro")
+
+type loggingRegistry struct {
+	mu           sync.Mutex
+	loggers      map[string]*logrus.Logger
+	defaultLevel logrus.Level
+	levels       map[string]logrus.Level
+	formatter    logrus.Formatter
+}
+
+var logging = &loggingRegistry{
+	loggers:      map[string]*logrus.Logger{},
+	defaultLevel: logrus.InfoLevel,
+	levels:       map[string]logrus.Level{},
+	formatter:    &logrus.TextFormatter{},
+}
+
+// Logger returns the logger of a package (e.g. "adapters", "micro"). Its level can be set
+// independently with LOG_LEVEL, for instance LOG_LEVEL=info,adapters=debug,micro.events=warn
+func Logger(name string) *logrus.Entry {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logger, ok := logging.loggers[name]
+	if !ok {
+		logger = logrus.New()
+		logger.SetOutput(os.Stderr)
+		logger.SetFormatter(logging.formatter)
+		logger.SetLevel(logging.levelOf(name))
+		logging.loggers[name] = logger
+	}
+	return logrus.NewEntry(logger).WithField("logger", name)
+}
+
+// ConfigureLogging sets the output format (json in production) and the log levels.
+// levels is a comma separated list of `level` or `package=level` entries.
+func ConfigureLogging(production bool, levels string, format string) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+
+	if format == "" {
+		format = "text"
+		if production {
+			format = "json"
+		}
+	}
+	if format == "json" {
+		logging.formatter = &logrus.JSONFormatter{}
+	} else {
+		logging.formatter = &logrus.TextFormatter{FullTimestamp: true}
+	}
+
+	logging.defaultLevel = logrus.InfoLevel
+	logging.levels = map[string]logrus.Level{}
+	for _, entry := range strings.Split(levels, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, found := strings.Cut(entry, "=")
+		if !found {
+			name, value = "", entry
+		}
+		level, err := logrus.ParseLevel(strings.TrimSpace(value))
+		if err != nil {
+			logrus.Warnf("invalid log level %q: %s", entry, err)
+			continue
+		}
+		if name == "" {
+			logging.defaultLevel = level
+		} else {
+			logging.levels[strings.TrimSpace(name)] = level
+		}
+	}
+
+	logrus.SetFormatter(logging.formatter)
+	logrus.SetLevel(logging.defaultLevel)
+	for name, logger := range logging.loggers {
+		logger.SetFormatter(logging.formatter)
+		logger.SetLevel(logging.levelOf(name))
+	}
+}
+
+// levelOf returns the level of the most specific package prefix matching name
+func (r *loggingRegistry) levelOf(name string) logrus.Level {
+	level := r.defaultLevel
+	matched := -1
+	for prefix, value := range r.levels {
+		if (name == prefix || strings.HasPrefix(name, prefix+".")) && len(prefix) > matched {
+			level = value
+			matched = len(prefix)
+		}
+	}
+	return level
+}
+
+// Log returns the application logger enriched with the request scoped fields:
+// request id, tenant, user id, route, transaction mode and trace id.
+func (ctx Ctx) Log() *logrus.Entry {
+	return ctx.LogWith(Logger(AppLogger))
+}
+
+// LogWith enriches the given logger with the request scoped fields
+func (ctx Ctx) LogWith(logger *logrus.Entry) *logrus.Entry {
+	fields := logrus.Fields{}
+	if ctx.TenantId != "" {
+		fields["tenant"] = ctx.TenantId
+	}
+	if ctx.Auth != nil && ctx.Auth.UserId != "" {
+		fields["user_id"] = ctx.Auth.UserId
+	}
+	if c, ok := ctx.Wrapped.(echo.Context); ok {
+		if requestId := c.Response().Header().Get(echo.HeaderXRequestID); requestId != "" {
+			fields["request_id"] = requestId
+		}
+		if route := c.Path(); route != "" {
+			fields["route"] = route
+		}
+		if txMode, ok := c.Get(TxModeKey).(TxMode); ok {
+			fields["tx"] = txMode
+		}
+	}
+	if traceId := ctx.TraceId(); traceId != "" {
+		fields["trace_id"] = traceId
+	}
+	return logger.WithFields(fields)
+}
@@ -0,0 +1,50 @@
+package micro
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoggerLevels(t *testing.T) {
+	defer ConfigureLogging(false, "", "")
+	ConfigureLogging(false, "warn,adapters=debug,adapters.gorm=error", "")
+
+	assert.Equal(t, logrus.WarnLevel, Logger("micro").Logger.GetLevel())
+	assert.Equal(t, logrus.DebugLevel, Logger("adapters").Logger.GetLevel())
+	assert.Equal(t, logrus.DebugLevel, Logger("adapters.echo").Logger.GetLevel())
+	assert.Equal(t, logrus.ErrorLevel, Logger("adapters.gorm").Logger.GetLevel())
+	assert.IsType(t, &logrus.TextFormatter{}, Logger("micro").Logger.Formatter)
+
+	ConfigureLogging(true, "", "")
+	assert.Equal(t, logrus.InfoLevel, Logger("adapters").Logger.GetLevel())
+	assert.IsType(t, &logrus.JSONFormatter{}, Logger("micro").Logger.Formatter)
+}
+
+func TestRequestLogFields(t *testing.T) {
+	traceId, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanId, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	req := httptest.NewRequest(http.MethodGet, "/entries/e1", nil)
+	req = req.WithContext(trace.ContextWithSpanContext(req.Context(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceId,
+		SpanID:  spanId,
+	})))
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	c.SetPath("/entries/:id")
+	c.Response().Header().Set(echo.HeaderXRequestID, "req-1")
+	c.Set(TxModeKey, TxModeNone)
+	ctx := Ctx{TenantId: "acme", Auth: &Authentication{UserId: "u1"}, Wrapped: c}
+
+	fields := ctx.Log().Data
+	assert.Equal(t, "req-1", fields["request_id"])
+	assert.Equal(t, "acme", fields["tenant"])
+	assert.Equal(t, "u1", fields["user_id"])
+	assert.Equal(t, "/entries/:id", fields["route"])
+	assert.Equal(t, TxModeNone, fields["tx"])
+	assert.Equal(t, traceId.String(), fields["trace_id"])
+	assert.Equal(t, AppLogger, fields["logger"])
+}
@@ -0,0 +1,169 @@
+package micro
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"strconv"
+	"time"
+)
+
+const DefaultMetricsPath = "/metrics"
+
+type PrometheusCfg struct {
+	Enabled bool
+	Path    string
+}
+
+// Metrics holds the prometheus registry and the collectors used by the framework.
+// The registry is exposed so that applications can register their own collectors.
+// All the methods are safe to call on a nil receiver (metrics disabled).
+type Metrics struct {
+	Registry          *prometheus.Registry
+	httpRequests      *prometheus.CounterVec
+	httpDuration      *prometheus.HistogramVec
+	dbQueryDuration   *prometheus.HistogramVec
+	jobRuns           *prometheus.CounterVec
+	jobFailures       *prometheus.CounterVec
+	jobDuration       *prometheus.HistogramVec
+	eventsPublished   *prometheus.CounterVec
+	eventsHandled     *prometheus.CounterVec
+	upstreamDurations *prometheus.HistogramVec
+}
+
+func NewMetrics(namespace string) *Metrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	m := &Metrics{
+		Registry: registry,
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests by route template, transaction mode and status.",
+		}, []string{"method", "route", "tx", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by route template, transaction mode and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "tx", "status"}),
+		dbQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "db",
+			Name:      "query_duration_seconds",
+			Help:      "Database query duration by tenant and operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"tenant", "operation"}),
+		jobRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scheduler",
+			Name:      "job_runs_total",
+			Help:      "Number of scheduled job runs.",
+		}, []string{"job", "tenant"}),
+		jobFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scheduler",
+			Name:      "job_failures_total",
+			Help:      "Number of failed scheduled job runs.",
+		}, []string{"job", "tenant"}),
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "scheduler",
+			Name:      "job_duration_seconds",
+			Help:      "Scheduled job duration.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"job", "tenant"}),
+		eventsPublished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "events",
+			Name:      "published_total",
+			Help:      "Number of events published by topic.",
+		}, []string{"topic"}),
+		eventsHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "events",
+			Name:      "handled_total",
+			Help:      "Number of events handled by topic and outcome.",
+		}, []string{"topic", "outcome"}),
+		upstreamDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "upstream_duration_seconds",
+			Help:      "Proxy upstream latency by upstream and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"upstream", "status"}),
+	}
+	registry.MustRegister(
+		m.httpRequests,
+		m.httpDuration,
+		m.dbQueryDuration,
+		m.jobRuns,
+		m.jobFailures,
+		m.jobDuration,
+		m.eventsPublished,
+		m.eventsHandled,
+		m.upstreamDurations,
+	)
+	return m
+}
+
+// ObserveHttpRequest records a request, txMode is the implicit transaction of the route
+// (TxModeNone when the request reached no handler)
+func (m *Metrics) ObserveHttpRequest(method string, route string, txMode TxMode, status int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	if txMode == "" {
+		txMode = TxModeNone
+	}
+	code := strconv.Itoa(status)
+	m.httpRequests.WithLabelValues(method, route, string(txMode), code).Inc()
+	m.httpDuration.WithLabelValues(method, route, string(txMode), code).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) ObserveDbQuery(tenant string, operation string, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.dbQueryDuration.WithLabelValues(tenant, operation).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) ObserveJob(job string, tenant string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.jobRuns.WithLabelValues(job, tenant).Inc()
+	if err != nil {
+		m.jobFailures.WithLabelValues(job, tenant).Inc()
+	}
+	m.jobDuration.WithLabelValues(job, tenant).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) EventPublished(topic string) {
+	if m == nil {
+		return
+	}
+	m.eventsPublished.WithLabelValues(topic).Inc()
+}
+
+func (m *Metrics) EventHandled(topic string, err error) {
+	if m == nil {
+		return
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	m.eventsHandled.WithLabelValues(topic, outcome).Inc()
+}
+
+func (m *Metrics) ObserveUpstream(upstream string, status int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.upstreamDurations.WithLabelValues(upstream, strconv.Itoa(status)).Observe(elapsed.Seconds())
+}
@@ -0,0 +1,298 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MigrationCommand is an operation of the Migrator
+type MigrationCommand string
+
+const (
+	// MigrateStatus lists the applied and pending migrations
+	MigrateStatus MigrationCommand = "status"
+	// MigrateUp applies the pending migrations
+	MigrateUp MigrationCommand = "up"
+	// MigrateDown rolls back the last applied migration
+	MigrateDown MigrationCommand = "down"
+	// MigrateRedo rolls back and applies again the last applied migration
+	MigrateRedo MigrationCommand = "redo"
+	// MigrateTo migrates up or down to MigrationOptions.Version
+	MigrateTo MigrationCommand = "to"
+)
+
+// MigrateCommandName is the App subcommand running the migrations (./svc migrate status)
+const MigrateCommandName = "migrate"
+
+const DefaultMigrationConcurrency = 4
+
+type MigrationOptions struct {
+	Command MigrationCommand
+	// Version is the target of MigrateTo
+	Version int64
+	// DryRun reports the migrations that would be applied or rolled back without running them
+	DryRun bool
+}
+
+// MigrationSource is a set of migrations (the location folder of FS and the Go migrations)
+// tracked in Table
+type MigrationSource struct {
+	FS       fs.FS
+	Location string
+	Table    string
+	Go       []GoMigration
+	// Env is the environment of the Ctx given to the Go migrations
+	Env *Env
+}
+
+// MigrationFunc is the Up or Down function of a GoMigration
+type MigrationFunc func(ctx Ctx) error
+
+// GoMigration is a migration coded in Go, run in the migration transaction: ctx.CurrentDB()
+// is bound to the transaction and ctx.TenantId is the migrated tenant. Its version is
+// ordered with the versions of the sql files of Location and must not collide with them.
+type GoMigration struct {
+	// Location is the migrations folder the migration belongs to: shared and tenant in
+	// multi-tenant mode, "." otherwise
+	Location string
+	Version  int64
+	Up       MigrationFunc
+	// Down is optional, the rollback only removes the version when nil
+	Down MigrationFunc
+}
+
+// GoMigrationsOf returns the migrations of a location
+func GoMigrationsOf(migrations []GoMigration, location string) []GoMigration {
+	var result []GoMigration
+	for _, migration := range migrations {
+		if migration.Location == location {
+			result = append(result, migration)
+		}
+	}
+	return result
+}
+
+// MigrationState is the status of a migration
+type MigrationState struct {
+	Version   int64     `json:"version"`
+	Name      string    `json:"name"`
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"applied_at,omitempty"`
+}
+
+// MigrationStep is a migration applied or rolled back (planned in dry-run mode)
+type MigrationStep struct {
+	Version   int64         `json:"version"`
+	Name      string        `json:"name"`
+	Direction string        `json:"direction"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// MigrationReport is the result of a command on the migrations of a tenant
+type MigrationReport struct {
+	Tenant   string           `json:"tenant"`
+	Location string           `json:"location"`
+	Table    string           `json:"table"`
+	DryRun   bool             `json:"dry_run,omitempty"`
+	Version  int64            `json:"version"`
+	Steps    []MigrationStep  `json:"steps,omitempty"`
+	Status   []MigrationState `json:"status,omitempty"`
+	Err      error            `json:"-"`
+}
+
+// MigrationPlan returns the migration sources of a tenant, in the order they are applied
+type MigrationPlan func(tenant string) []MigrationSource
+
+// Migrator runs the migration commands on the datasources of the tenants, Concurrency
+// tenants at a time. A failure stops the migrations of its tenant only and is reported
+// in its MigrationReport.
+type Migrator struct {
+	Concurrency int
+	env         *Env
+	plan        MigrationPlan
+}
+
+func NewMigrator(env *Env, plan MigrationPlan, concurrency int) *Migrator {
+	if concurrency <= 0 {
+		concurrency = DefaultMigrationConcurrency
+	}
+	return &Migrator{Concurrency: concurrency, env: env, plan: plan}
+}
+
+// Run executes the command on the given tenants (all the datasources when empty), the
+// reports are sorted by tenant then by source
+func (m *Migrator) Run(ctx context.Context, opts MigrationOptions, tenants ...string) []MigrationReport {
+	if len(tenants) == 0 {
+		for tenant := range m.env.DataSources {
+			tenants = append(tenants, tenant)
+		}
+	}
+	sort.Strings(tenants)
+	results := make([][]MigrationReport, len(tenants))
+	slots := make(chan struct{}, m.Concurrency)
+	var wg sync.WaitGroup
+	for i, tenant := range tenants {
+		wg.Add(1)
+		go func(i int, tenant string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() {
+				<-slots
+			}()
+			results[i] = m.migrate(ctx, tenant, opts)
+		}(i, tenant)
+	}
+	wg.Wait()
+	var reports []MigrationReport
+	for _, result := range results {
+		reports = append(reports, result...)
+	}
+	return reports
+}
+
+func (m *Migrator) migrate(ctx context.Context, tenant string, opts MigrationOptions) []MigrationReport {
+	ds := m.env.DataSources[tenant]
+	if ds == nil {
+		return []MigrationReport{{Tenant: tenant, DryRun: opts.DryRun, Err: fmt.Errorf("unknown tenant: %s", tenant)}}
+	}
+	sources := m.plan(tenant)
+	if (opts.Command == MigrateDown || opts.Command == MigrateRedo) && len(sources) > 1 {
+		// the last applied migration belongs to the last source
+		sources = sources[len(sources)-1:]
+	}
+	var reports []MigrationReport
+	for _, source := range sources {
+		if source.Env == nil {
+			source.Env = m.env
+		}
+		report, err := ds.RunMigrations(ctx, source, opts)
+		report.Tenant = tenant
+		report.Err = err
+		reports = append(reports, report)
+		if err != nil {
+			break
+		}
+	}
+	return reports
+}
+
+// MigrationErrors returns the failures of the reports, nil when every migration succeeded
+func MigrationErrors(reports []MigrationReport) error {
+	var errs []string
+	for _, report := range reports {
+		if report.Err != nil {
+			errs = append(errs, fmt.Sprintf("%s (%s): %s", report.Tenant, report.Table, report.Err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("migrations failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// IsCommand returns true when the process was started with the subcommand name
+// (e.g. ./svc migrate up)
+func IsCommand(name string) bool {
+	return len(os.Args) > 1 && os.Args[1] == name
+}
+
+// ParseMigrationArgs parses the arguments of the migrate subcommand:
+//
+//	migrate [status|up|down|redo|to VERSION] [--dry-run] [--tenant=t1,t2]
+func ParseMigrationArgs(args []string) (MigrationOptions, []string, error) {
+	opts := MigrationOptions{Command: MigrateStatus}
+	var tenants []string
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--dry-run" || arg == "-dry-run":
+			opts.DryRun = true
+		case arg == "--tenant" || arg == "-tenant" || strings.HasPrefix(arg, "--tenant="):
+			value := strings.TrimPrefix(arg, "--tenant=")
+			if !strings.Contains(arg, "=") {
+				if i+1 == len(args) {
+					return opts, nil, fmt.Errorf("missing value of %s", arg)
+				}
+				i++
+				value = args[i]
+			}
+			for _, tenant := range strings.Split(value, ",") {
+				if tenant = strings.TrimSpace(tenant); tenant != "" {
+					tenants = append(tenants, tenant)
+				}
+			}
+		case strings.HasPrefix(arg, "-"):
+			return opts, nil, fmt.Errorf("unknown flag: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) > 0 {
+		opts.Command = MigrationCommand(positional[0])
+	}
+	switch opts.Command {
+	case MigrateStatus, MigrateUp, MigrateDown, MigrateRedo:
+		if len(positional) > 1 {
+			return opts, nil, fmt.Errorf("unexpected argument: %s", positional[1])
+		}
+	case MigrateTo:
+		if len(positional) != 2 {
+			return opts, nil, fmt.Errorf("usage: migrate to VERSION")
+		}
+		version, err := strconv.ParseInt(positional[1], 10, 64)
+		if err != nil {
+			return opts, nil, fmt.Errorf("invalid version: %s", positional[1])
+		}
+		opts.Version = version
+	default:
+		return opts, nil, fmt.Errorf("unknown migrate command: %s (status, up, down, redo, to)", opts.Command)
+	}
+	return opts, tenants, nil
+}
+
+// RunMigrationCommand runs the migrate subcommand and prints the reports in out
+func (app *App) RunMigrationCommand(ctx context.Context, args []string, out io.Writer) error {
+	if app.Env.Migrator == nil {
+		return fmt.Errorf("no database migrations configured")
+	}
+	opts, tenants, err := ParseMigrationArgs(args)
+	if err != nil {
+		return err
+	}
+	reports := app.Env.Migrator.Run(ctx, opts, tenants...)
+	for _, report := range reports {
+		WriteMigrationReport(out, report)
+	}
+	return MigrationErrors(reports)
+}
+
+// WriteMigrationReport prints a report in a human readable form
+func WriteMigrationReport(out io.Writer, report MigrationReport) {
+	header := fmt.Sprintf("[%s] %s (version %d)", report.Tenant, report.Table, report.Version)
+	if report.DryRun {
+		header += " dry-run"
+	}
+	_, _ = fmt.Fprintln(out, header)
+	for _, state := range report.Status {
+		applied := "pending"
+		if state.Applied {
+			applied = "applied " + state.AppliedAt.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(out, "  %-14d %-40s %s\n", state.Version, state.Name, applied)
+	}
+	for _, step := range report.Steps {
+		_, _ = fmt.Fprintf(out, "  %-4s %-14d %-40s %s\n", step.Direction, step.Version, step.Name, step.Duration.Round(time.Millisecond))
+	}
+	if report.Err != nil {
+		_, _ = fmt.Fprintf(out, "  error: %s\n", report.Err)
+	}
+}
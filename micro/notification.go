@@ -1,9 +1,5 @@
 package micro
 
-import (
-	log "github.com/sirupsen/logrus"
-)
-
 type Notification struct {
 	Message string `json:"content"`
 }
@@ -0,0 +1,283 @@
+package micro
+
+import (
+	serrors "errors"
+	"fmt"
+	"github.com/soffa-projects/go-micro/schema"
+	"regexp"
+	"strings"
+)
+
+// Condition is a where clause of a Query, combined with the previous ones with AND
+// (or OR when Or is set)
+type Condition struct {
+	Expr string
+	Args []any
+	Or   bool
+}
+
+// Clause is a sql fragment with its arguments (joins, having)
+type Clause struct {
+	Expr string
+	Args []any
+}
+
+const DefaultPageSize = 100
+
+var columnPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// QueryBuilder is a chainable query on the entities of an EntityRepo, the conditions
+// are translated by the datasource. Column names given to In, Between, Like, Order and
+// GroupBy are validated, the expressions given to Where, Join and Having are not and
+// must never contain user input (use the ? placeholders).
+type QueryBuilder[T any] struct {
+	ctx Ctx
+	q   Query
+	err error
+}
+
+func NewQueryBuilder[T any](ctx Ctx) *QueryBuilder[T] {
+	return &QueryBuilder[T]{ctx: ctx}
+}
+
+func (b *QueryBuilder[T]) Where(expr string, args ...any) *QueryBuilder[T] {
+	b.q.Conditions = append(b.q.Conditions, Condition{Expr: expr, Args: args})
+	return b
+}
+
+func (b *QueryBuilder[T]) OrWhere(expr string, args ...any) *QueryBuilder[T] {
+	b.q.Conditions = append(b.q.Conditions, Condition{Expr: expr, Args: args, Or: true})
+	return b
+}
+
+// In adds a "column IN (values)" condition, values must be a slice
+func (b *QueryBuilder[T]) In(column string, values any) *QueryBuilder[T] {
+	if b.checkColumn(column) {
+		b.Where(column+" IN ?", values)
+	}
+	return b
+}
+
+func (b *QueryBuilder[T]) Between(column string, from any, to any) *QueryBuilder[T] {
+	if b.checkColumn(column) {
+		b.Where(column+" BETWEEN ? AND ?", from, to)
+	}
+	return b
+}
+
+// Like adds a "column LIKE pattern" condition, the pattern contains the wildcards
+func (b *QueryBuilder[T]) Like(column string, pattern string) *QueryBuilder[T] {
+	if b.checkColumn(column) {
+		b.Where(column+" LIKE ?", pattern)
+	}
+	return b
+}
+
+func (b *QueryBuilder[T]) Join(expr string, args ...any) *QueryBuilder[T] {
+	b.q.Joins = append(b.q.Joins, Clause{Expr: expr, Args: args})
+	return b
+}
+
+// Order sorts by column, in descending order when desc is true. Calls are cumulative.
+func (b *QueryBuilder[T]) Order(column string, desc ...bool) *QueryBuilder[T] {
+	if !b.checkColumn(column) {
+		return b
+	}
+	if len(desc) > 0 && desc[0] {
+		column += " DESC"
+	}
+	if b.q.Sort != "" {
+		b.q.Sort += ", "
+	}
+	b.q.Sort += column
+	return b
+}
+
+func (b *QueryBuilder[T]) Limit(limit int) *QueryBuilder[T] {
+	b.q.Limit = int64(limit)
+	return b
+}
+
+func (b *QueryBuilder[T]) Offset(offset int) *QueryBuilder[T] {
+	b.q.Offset = int64(offset)
+	return b
+}
+
+// Preload loads the associations of the entities (e.g. "Orders", "Orders.Items")
+func (b *QueryBuilder[T]) Preload(associations ...string) *QueryBuilder[T] {
+	b.q.Preload = append(b.q.Preload, associations...)
+	return b
+}
+
+// Select restricts the selected columns, used with Into or Project
+func (b *QueryBuilder[T]) Select(columns ...string) *QueryBuilder[T] {
+	b.q.Select = strings.Join(columns, ", ")
+	return b
+}
+
+func (b *QueryBuilder[T]) GroupBy(columns ...string) *QueryBuilder[T] {
+	for _, column := range columns {
+		if !b.checkColumn(column) {
+			return b
+		}
+	}
+	b.q.Group = strings.Join(columns, ", ")
+	return b
+}
+
+func (b *QueryBuilder[T]) Having(expr string, args ...any) *QueryBuilder[T] {
+	b.q.Having = append(b.q.Having, Clause{Expr: expr, Args: args})
+	return b
+}
+
+// Unscoped includes the soft deleted entities
+func (b *QueryBuilder[T]) Unscoped() *QueryBuilder[T] {
+	b.q.Unscoped = true
+	return b
+}
+
+// Query returns the translated Query
+func (b *QueryBuilder[T]) Query() Query {
+	return b.q
+}
+
+func (b *QueryBuilder[T]) Find() ([]*T, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	var result []*T
+	err := b.ctx.CurrentDB().Find(&result, b.q)
+	return result, err
+}
+
+// First returns the first entity or nil when none matches
+func (b *QueryBuilder[T]) First() (*T, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	var model T
+	err := b.ctx.CurrentDB().First(&model, b.q)
+	if serrors.Is(err, ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &model, err
+}
+
+// Into scans the result in target (a pointer to a slice of DTO), see Project
+func (b *QueryBuilder[T]) Into(target any) error {
+	if b.err != nil {
+		return b.err
+	}
+	q := b.q
+	q.Model = new(T)
+	return b.ctx.CurrentDB().Find(target, q)
+}
+
+func (b *QueryBuilder[T]) Count() (int64, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	var model T
+	return b.ctx.CurrentDB().Count(model, b.countQuery())
+}
+
+func (b *QueryBuilder[T]) Exists() (bool, error) {
+	count, err := b.Count()
+	return count > 0, err
+}
+
+// Paginate returns the page (starting at 1) of count entities and the total of matching entities
+func (b *QueryBuilder[T]) Paginate(page int, count int) (schema.EntityList[T], error) {
+	if page < 1 {
+		page = 1
+	}
+	if count <= 0 {
+		count = DefaultPageSize
+	}
+	total, err := b.Count()
+	if err != nil {
+		return schema.EntityList[T]{}, err
+	}
+	q := b.q
+	q.Limit = int64(count)
+	q.Offset = int64((page - 1) * count)
+	data := make([]T, 0)
+	if err := b.ctx.CurrentDB().Find(&data, q); err != nil {
+		return schema.EntityList[T]{}, err
+	}
+	return schema.EntityList[T]{Data: data, Page: page, Total: int(total)}, nil
+}
+
+// FindInBatches loads the matching entities size at a time (DefaultBatchSize when 0) in
+// primary key order, only one batch is kept in memory. The batches are paged by primary
+// key: an error is returned when the query is sorted (see Order). The iteration stops at
+// the first error returned by fn or when the Ctx is cancelled.
+func (b *QueryBuilder[T]) FindInBatches(size int, fn func(batch []*T) error) error {
+	if b.err != nil {
+		return b.err
+	}
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	var batch []*T
+	return b.ctx.CurrentDB().FindInBatches(&batch, b.q, size, func(int) error {
+		if err := b.ctx.Context().Err(); err != nil {
+			return err
+		}
+		return fn(batch)
+	})
+}
+
+// Iterate streams the matching entities through a database cursor. Associations are not
+// preloaded and, on postgres, no other query can run on the same transaction until the
+// iteration ends: use FindInBatches when fn queries the database.
+func (b *QueryBuilder[T]) Iterate(fn func(item *T) error) error {
+	if b.err != nil {
+		return b.err
+	}
+	rows, err := b.ctx.CurrentDB().Iterate(new(T), b.q)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		if err := b.ctx.Context().Err(); err != nil {
+			return err
+		}
+		item := new(T)
+		if err := rows.Scan(item); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Project runs the query and scans the selected columns in a list of D
+func Project[D any, T any](b *QueryBuilder[T]) ([]D, error) {
+	var result []D
+	err := b.Into(&result)
+	return result, err
+}
+
+// countQuery drops the clauses that don't apply to a count of the matching entities
+func (b *QueryBuilder[T]) countQuery() Query {
+	q := b.q
+	q.Sort = ""
+	q.Select = ""
+	q.Limit = 0
+	q.Offset = 0
+	q.Preload = nil
+	return q
+}
+
+func (b *QueryBuilder[T]) checkColumn(column string) bool {
+	if b.err == nil && !columnPattern.MatchString(column) {
+		b.err = fmt.Errorf("invalid column name: %q", column)
+	}
+	return b.err == nil
+}
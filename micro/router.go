@@ -1,7 +1,7 @@
 package micro
 
 import (
-	log "github.com/sirupsen/logrus"
+	"context"
 	"github.com/swaggo/swag"
 	"net/http"
 	"strings"
@@ -19,7 +19,7 @@ type Router interface {
 	BaseRouter
 	Handler() http.Handler
 	Start(addr string) error
-	Shutdown() error
+	Shutdown(ctx context.Context) error
 	Group(path string, filters ...MiddlewareFunc) BaseRouter
 	Use(filter MiddlewareFunc)
 	Proxy(path string, upstreams *RouterUpstream, filters ...MiddlewareFunc)
@@ -35,6 +35,12 @@ type BaseRouter interface {
 	//Resource(resource string, model interface{})
 }
 
+// EnvRouter is implemented by the routers (and groups) bound to the env of the application,
+// the handlers use it to register the routes of the enabled features only
+type EnvRouter interface {
+	Env() *Env
+}
+
 type JwtCfg struct {
 	Provider TokenProvider
 }
@@ -47,7 +53,8 @@ type RouterConfig struct {
 	BodyLimit                  string
 	SwaggerSpec                *swag.Spec
 	MultiTenant                bool
-	//Prometheus       *PrometheusCfg
+	Prometheus                 *PrometheusCfg
+	Tracing                    bool
 	//JwtAuth    bool
 	Production       bool
 	TokenProvider    TokenProvider
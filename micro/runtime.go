@@ -3,14 +3,18 @@ package micro
 import (
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
-	log "github.com/sirupsen/logrus"
 	"github.com/soffa-projects/go-micro/di"
 	"github.com/soffa-projects/go-micro/util/h"
 	"github.com/swaggo/swag"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -25,23 +29,60 @@ type Cfg struct {
 	MultiTenant                bool
 	TablePrefix                string
 	EnableDiscovery            bool
+	EnableMetrics              bool
+	TraceExporter              sdktrace.SpanExporter
 	BasePath                   string
 	DisableRouter              bool
 	DisableJwtFilter           bool
 	CorsDisabled               bool
 	DisableImplicitTransaction bool
 	SwaggerSpec                *swag.Spec
+	ShutdownTimeout            time.Duration
+	// ShutdownDelay is the time given to the load balancers to see the draining readiness
+	// before the server stops accepting requests (see App.ShutdownDelay)
+	ShutdownDelay time.Duration
+	// Config is a pointer to the application configuration struct, loaded with LoadConfig
+	// and available through Conf[T](env)
+	Config any
+	// Flags are the feature flags of the application with their default value
+	Flags []Flag
+	// FlagSources lists where the flags are updated from: file (flags.{yaml,toml,json} in FS),
+	// db (feature_flags table of the shared datasource) and redis
+	FlagSources []string
+	// Audit records the entity changes in the audit_log table of each datasource,
+	// AuditRedact lists the fields whose values are not recorded (see AuditLog)
+	Audit       bool
+	AuditRedact []string
+	// Tenancy is how the tenants are isolated when MultiTenant is set (TenancySchema by default)
+	Tenancy TenancyStrategy
+	// TenantRegistry lists the tenants and, with TenancyDatabase, their database url.
+	// DATABASE_INITIAL_TENANTS ("tenant|url" entries) is used when nil.
+	TenantRegistry TenantRegistry
+	// RowLevelSecurity makes the connections set the tenant for the postgres policies
+	// (see EnableRowLevelSecurity) with TenancyRow
+	RowLevelSecurity bool
+	// Migrations are the Go migrations, run with the sql migrations of their location
+	Migrations []GoMigration
+	// Seeders run once per tenant and environment with the fixtures of db/seeds/{shared,tenant,.}
+	Seeders []Seeder
 }
 
+const DefaultShutdownTimeout = 30 * time.Second
+
 // ----------------------------------------------
 
 var _configStore = map[string]string{}
+var _configStoreMu sync.RWMutex
 
 func Set(key string, value string) {
+	_configStoreMu.Lock()
+	defer _configStoreMu.Unlock()
 	_configStore[key] = value
 }
 
 func Get(key string) string {
+	_configStoreMu.RLock()
+	defer _configStoreMu.RUnlock()
 	return _configStore[key]
 }
 
@@ -51,62 +92,157 @@ func (app *App) Cleanup() {
 	}
 }
 
+// Init configures the features and returns the app for chaining, it is fatal when a feature
+// fails.
+//
+// Deprecated: Use Configure, which returns the configuration errors.
 func (app *App) Init(features []Feature) *App {
-	//env.components = make([]Component, 0)
+	if err := app.Configure(features); err != nil {
+		log.Fatal(err)
+	}
+	return app
+}
 
+// Configure configures the features in dependency order. Every failure is reported in the
+// returned ConfigurationError, the features depending on a failed feature are skipped. The
+// failed and skipped features are never started.
+func (app *App) Configure(features []Feature) error {
 	env := app.Env
 	globalLocalizer = env.Localizer
 
-	if env.Scheduler != nil {
-		di.Register(SchedulerService, env.Scheduler)
+	errs := &ConfigurationError{}
+	if !app.configured {
+		app.configured = true
+		if err := app.registerEnvComponents(); err != nil {
+			errs.add("", fmt.Errorf("failed to register components: %w", err))
+		}
+		if env.Notifier != nil {
+			_ = Subscribe(NotificationTopic, func(ctx Ctx, payload Event) error {
+				return env.Notifier.Send(ctx, Notification{
+					Message: payload.Event,
+				})
+			})
+		}
 	}
-	if env.TokenProvider != nil {
-		di.Register(TokenProviderService, env.TokenProvider)
+
+	sorted, err := sortFeatures(features, app.features)
+	if err != nil {
+		errs.add("", err)
+		return errs
 	}
-	if env.Mailer != nil {
-		di.Register(MailerServer, env.Mailer)
+	failed := map[string]bool{}
+	for _, feat := range sorted {
+		if dep := firstFailed(feat.DependsOn, failed); dep != "" {
+			failed[feat.Name] = true
+			errs.add(feat.Name, fmt.Errorf("skipped, dependency %s failed", dep))
+			continue
+		}
+		if err := app.configureFeature(feat); err != nil {
+			failed[feat.Name] = true
+			errs.add(feat.Name, err)
+			continue
+		}
+		app.features = append(app.features, feat)
 	}
 
-	if env.Notifier != nil {
-		di.Register(Notifications, env.Notifier)
-		_ = Subscribe(NotificationTopic, func(ctx Ctx, payload Event) error {
-			return env.Notifier.Send(ctx, Notification{
-				Message: payload.Event,
-			})
-		})
+	if err := app.container().Validate(); err != nil {
+		errs.add("", err)
 	}
+	return errs.orNil()
+}
 
-	for _, feat := range features {
-		if feat.Configure != nil {
-			if err := feat.Configure(app); err != nil {
-				log.Fatalf("failed to init feature %s.\n%v", feat.Name, err)
-			}
-		} else if feat.Init != nil {
-			component, err := feat.Init(app)
-			if err != nil {
-				log.Fatalf("failed to init feature %s.\n%v", feat.Name, err)
-			}
-			//env.components = append(env.components, component)
-			if component != nil {
-				di.Register(feat.Name, component)
+func (app *App) configureFeature(feat Feature) error {
+	if feat.Configure != nil {
+		return feat.Configure(app)
+	}
+	if feat.Init != nil {
+		component, err := feat.Init(app)
+		if err != nil {
+			return err
+		}
+		if component != nil {
+			di.Register(feat.Name, component)
+			return app.container().Supply(component)
+		}
+	}
+	return nil
+}
+
+// startFeatures starts the features in dependency order, the features already
+// started are stopped when one of them fails
+func (app *App) startFeatures(ctx context.Context) error {
+	for _, feat := range app.features {
+		if feat.Start != nil {
+			if err := feat.Start(ctx); err != nil {
+				_ = app.stopFeatures(ctx)
+				return fmt.Errorf("failed to start feature %s: %w", feat.Name, err)
 			}
 		}
+		app.started = append(app.started, feat)
 	}
+	return nil
+}
 
-	return app
+func (app *App) stopFeatures(ctx context.Context) error {
+	var errs []string
+	for i := len(app.started) - 1; i >= 0; i-- {
+		feat := app.started[i]
+		if feat.Stop != nil {
+			if err := feat.Stop(ctx); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", feat.Name, err))
+			}
+		}
+	}
+	app.started = nil
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
 }
 
+// ShutdownHook is run when the application stops, before the datasources are closed.
+// Hooks run in reverse registration order and receive the shutdown deadline.
+type ShutdownHook func(ctx context.Context) error
+
 func (app *App) AddShutdownListener(listener func()) {
-	app.ShutdownListeners = append(app.ShutdownListeners, listener)
+	app.AddShutdownHook(func(_ context.Context) error {
+		listener()
+		return nil
+	})
+}
+
+func (app *App) AddShutdownHook(hook ShutdownHook) {
+	app.ShutdownHooks = append(app.ShutdownHooks, hook)
 }
 
+// Run starts the application and blocks until SIGINT/SIGTERM, the process exits with
+// a non-zero code when the server fails to start or the shutdown does not complete.
+// When started with the migrate (./svc migrate up) or seed subcommand, Run executes
+// the command instead (see RunMigrationCommand and RunSeedCommand).
 func (app *App) Run(addr ...string) {
-	// setup exit code for graceful shutdown
-	var exitCode int
-	defer func() {
-		os.Exit(exitCode)
-	}()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	var err error
+	switch {
+	case IsCommand(MigrateCommandName):
+		err = app.RunMigrationCommand(ctx, os.Args[2:], os.Stdout)
+		app.Cleanup()
+	case IsCommand(SeedCommandName):
+		err = app.RunSeedCommand(ctx, os.Args[2:], os.Stdout)
+		app.Cleanup()
+	default:
+		err = app.Serve(ctx, addr...)
+	}
+	stop()
+	if err != nil {
+		log.Errorf("application stopped with error: %s", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
 
+// Serve starts the application and blocks until ctx is done or the server fails,
+// then drains the in-flight work and releases the resources.
+func (app *App) Serve(ctx context.Context, addr ...string) error {
 	var port string
 	if len(addr) == 0 {
 		port = h.GetEnvOrDefault("PORT", "8080")
@@ -114,37 +250,47 @@ func (app *App) Run(addr ...string) {
 		port = addr[0]
 	}
 
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := app.container().Start(runCtx); err != nil {
+		_ = app.Shutdown()
+		return err
+	}
+	if err := app.startFeatures(runCtx); err != nil {
+		_ = app.Shutdown()
+		return err
+	}
+
 	// start the server
-	go func() {
-		_ = app.Router.Start("0.0.0.0:" + port)
-	}()
-
-	// run the cleanup after the server is terminated
-	defer func() {
-		_ = app.Router.Shutdown()
-		if app.Env.DataSources != nil {
-			app.Env.Close()
-		}
-		if app.ShutdownListeners != nil {
-			for _, listener := range app.ShutdownListeners {
-				listener()
+	startErr := make(chan error, 1)
+	if app.Router != nil {
+		go func() {
+			if err := app.Router.Start("0.0.0.0:" + port); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				startErr <- err
 			}
-		}
-	}()
+		}()
+	}
 
 	if app.Env.Scheduler != nil && !app.Env.Scheduler.IsEmpty() {
 		go func() {
-			time.Sleep(10 * time.Second)
-			app.Env.Scheduler.StartAsync()
-			log.Infof("scheduler started")
+			select {
+			case <-time.After(10 * time.Second):
+				app.Env.Scheduler.StartAsync()
+				log.Infof("scheduler started")
+			case <-runCtx.Done():
+			}
 		}()
 	}
 
 	if app.Env.RedisClient != nil && app.Env.DiscoverySericeName != "" {
 		go func() {
 			rc := app.Env.RedisClient
-			h.RaiseAny(rc.Set(context.Background(), app.Env.DiscoverySericeName, app.Env.DiscoveryServiceUrl, 0).Err())
-			rc.Publish(context.Background(), DiscoveryServicesChannel, fmt.Sprintf(
+			if err := rc.Set(runCtx, app.Env.DiscoverySericeName, app.Env.DiscoveryServiceUrl, 0).Err(); err != nil {
+				log.Errorf("unable to register discovery service url: %s", err)
+				return
+			}
+			rc.Publish(runCtx, DiscoveryServicesChannel, fmt.Sprintf(
 				"%s:%s",
 				app.Name,
 				app.Env.DiscoveryServiceUrl,
@@ -152,21 +298,90 @@ func (app *App) Run(addr ...string) {
 			log.Infof("discovery service url broadcasted: %s -> %s", app.Name, app.Env.DiscoveryServiceUrl)
 		}()
 	}
-	/*if err != nil {
-		fmt.Printf("error: %v", err)
-		exitCode = 1
-		return
-	}*/
 
-	// ensure the server is shutdown gracefully & app runs
-	gracefully()
+	if app.Env.Health != nil {
+		app.Env.Health.SetState(HealthReady)
+	}
+	app.serving = true
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Infof("shutdown requested, draining for at most %s", app.shutdownTimeout())
+	case err = <-startErr:
+		err = fmt.Errorf("unable to start server: %w", err)
+		// no request was accepted, there is nothing to drain
+		app.serving = false
+	}
+	cancel()
+
+	if shutdownErr := app.Shutdown(); shutdownErr != nil && err == nil {
+		err = shutdownErr
+	}
+	return err
 }
 
-func gracefully() {
-	quit := make(chan os.Signal, 1)
-	defer close(quit)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+// Shutdown reports the service as draining, waits for ShutdownDelay (when the server was
+// started) then stops accepting requests, waits for the in-flight http requests, scheduled
+// jobs and async event handlers, runs the shutdown hooks and closes the datasources.
+func (app *App) Shutdown() error {
+	if app.Env.Health != nil {
+		app.Env.Health.SetState(HealthDraining)
+	}
+	if app.Router != nil && app.ShutdownDelay > 0 && app.serving {
+		log.Infof("draining, the server stops accepting requests in %s", app.ShutdownDelay)
+		time.Sleep(app.ShutdownDelay)
+	}
+	app.serving = false
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.shutdownTimeout())
+	defer cancel()
+
+	var errs []string
+	if app.Router != nil {
+		if err := app.Router.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("router: %s", err))
+		}
+	}
+	if app.Env.Scheduler != nil {
+		if err := app.Env.Scheduler.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("scheduler: %s", err))
+		}
+	}
+	if err := WaitAsyncContext(ctx); err != nil {
+		errs = append(errs, fmt.Sprintf("events: %s", err))
+	}
+	if err := app.stopFeatures(ctx); err != nil {
+		errs = append(errs, fmt.Sprintf("features: %s", err))
+	}
+	for i := len(app.ShutdownHooks) - 1; i >= 0; i-- {
+		if err := app.ShutdownHooks[i](ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("hook: %s", err))
+		}
+	}
+	for _, listener := range app.ShutdownListeners {
+		listener()
+	}
+	if app.Container != nil {
+		if err := app.Container.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("components: %s", err))
+		}
+	}
+	if app.Env.DataSources != nil {
+		app.Env.Close()
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("shutdown failed: %s", strings.Join(errs, "; "))
+	}
+	log.Infof("application stopped")
+	return nil
+}
+
+func (app *App) shutdownTimeout() time.Duration {
+	if app.ShutdownTimeout > 0 {
+		return app.ShutdownTimeout
+	}
+	return DefaultShutdownTimeout
 }
 
 func T(messageId string, other ...string) string {
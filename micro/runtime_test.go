@@ -0,0 +1,99 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type fakeRouter struct {
+	Router
+	health   *HealthRegistry
+	stopped  chan struct{}
+	events   *[]string
+	drained  time.Time
+	startErr error
+}
+
+func (r *fakeRouter) Start(addr string) error {
+	if r.startErr != nil {
+		return r.startErr
+	}
+	<-r.stopped
+	return http.ErrServerClosed
+}
+
+func (r *fakeRouter) Shutdown(ctx context.Context) error {
+	r.drained = time.Now()
+	*r.events = append(*r.events, "router "+r.health.State().String())
+	close(r.stopped)
+	return nil
+}
+
+func TestServe(t *testing.T) {
+	var events []string
+	record := func(event string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			events = append(events, event)
+			return nil
+		}
+	}
+	env := &Env{Health: NewHealthRegistry()}
+	router := &fakeRouter{health: env.Health, stopped: make(chan struct{}), events: &events}
+	app := &App{Env: env, Router: router, ShutdownDelay: 50 * time.Millisecond}
+	assert.Nil(t, app.Configure([]Feature{
+		{Name: "db", Start: record("start db"), Stop: record("stop db")},
+		{Name: "api", DependsOn: []string{"db"}, Start: record("start api"), Stop: record("stop api")},
+	}))
+	app.AddShutdownHook(record("first hook"))
+	app.AddShutdownHook(record("second hook"))
+	app.AddShutdownListener(func() {
+		events = append(events, "listener")
+	})
+	app.ShutdownListeners = append(app.ShutdownListeners, func() {
+		events = append(events, "deprecated listener")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Serve(ctx, "0")
+	}()
+	assert.Eventually(t, func() bool {
+		return env.Health.State() == HealthReady
+	}, time.Second, time.Millisecond)
+
+	cancelled := time.Now()
+	cancel()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after the context was cancelled")
+	}
+	assert.GreaterOrEqual(t, router.drained.Sub(cancelled), app.ShutdownDelay)
+	assert.Equal(t, []string{
+		"start db", "start api",
+		"router DRAINING",
+		"stop api", "stop db",
+		"listener", "second hook", "first hook",
+		"deprecated listener",
+	}, events)
+}
+
+func TestServeStartFailure(t *testing.T) {
+	var events []string
+	env := &Env{Health: NewHealthRegistry()}
+	router := &fakeRouter{health: env.Health, stopped: make(chan struct{}), events: &events, startErr: errors.New("address already in use")}
+	app := &App{Env: env, Router: router, ShutdownDelay: time.Minute}
+	assert.Nil(t, app.Configure(nil))
+
+	// the server never accepted a request, the shutdown is not delayed
+	started := time.Now()
+	assert.ErrorContains(t, app.Serve(context.Background(), "0"), "address already in use")
+	assert.Less(t, time.Since(started), app.ShutdownDelay)
+	assert.Equal(t, []string{"router DRAINING"}, events)
+}
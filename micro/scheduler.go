@@ -1,10 +1,14 @@
 package micro
 
+import "context"
+
 type SchedulerHandler = func(ctx Ctx) error
 
 type Scheduler interface {
 	IsEmpty() bool
 	StartAsync()
+	// Stop stops scheduling new runs and waits for the running jobs until ctx is done
+	Stop(ctx context.Context) error
 	Every(interval string, handler SchedulerHandler)
 	Once(handler SchedulerHandler)
 	EveryTenant(interval string, handler SchedulerHandler)
@@ -0,0 +1,314 @@
+package micro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultSecretsTTL = 5 * time.Minute
+
+// SecretProvider resolves a secret reference such as file:///run/secrets/db or vault://secret/app#db_url
+type SecretProvider interface {
+	Resolve(ctx context.Context, ref *url.URL) (string, error)
+}
+
+// secretMatcher can be implemented by a provider to refuse values that share its scheme
+// but are not secret references (e.g. sqlite file: urls)
+type secretMatcher interface {
+	Accepts(ref *url.URL) bool
+}
+
+type cachedSecret struct {
+	value string
+	at    time.Time
+}
+
+// SecretResolver resolves configuration values through the registered providers.
+// Resolved values are cached for TTL, so that rotated credentials are picked up
+// without a restart. Values that are not references are returned unchanged.
+type SecretResolver struct {
+	TTL       time.Duration
+	mu        sync.RWMutex
+	providers map[string]SecretProvider
+	cache     map[string]cachedSecret
+	listeners map[string][]func(value string)
+}
+
+func NewSecretResolver(ttl time.Duration) *SecretResolver {
+	if ttl <= 0 {
+		ttl = DefaultSecretsTTL
+	}
+	return &SecretResolver{
+		TTL:       ttl,
+		providers: map[string]SecretProvider{},
+		cache:     map[string]cachedSecret{},
+		listeners: map[string][]func(value string){},
+	}
+}
+
+func (r *SecretResolver) RegisterProvider(scheme string, provider SecretProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[scheme] = provider
+}
+
+// IsReference returns true when value must be resolved by one of the providers
+func (r *SecretResolver) IsReference(value string) bool {
+	_, _, ok := r.lookup(value)
+	return ok
+}
+
+func (r *SecretResolver) lookup(value string) (SecretProvider, *url.URL, bool) {
+	if r == nil || !strings.Contains(value, "://") {
+		return nil, nil, false
+	}
+	ref, err := url.Parse(value)
+	if err != nil {
+		return nil, nil, false
+	}
+	r.mu.RLock()
+	provider, ok := r.providers[ref.Scheme]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, nil, false
+	}
+	if matcher, ok := provider.(secretMatcher); ok && !matcher.Accepts(ref) {
+		return nil, nil, false
+	}
+	return provider, ref, true
+}
+
+// Resolve returns the secret value of a reference (from cache when fresh enough)
+func (r *SecretResolver) Resolve(ctx context.Context, value string) (string, error) {
+	provider, ref, ok := r.lookup(value)
+	if !ok {
+		return value, nil
+	}
+	r.mu.RLock()
+	cached, found := r.cache[value]
+	r.mu.RUnlock()
+	if found && time.Since(cached.at) < r.TTL {
+		return cached.value, nil
+	}
+	return r.fetch(ctx, value, provider, ref)
+}
+
+// Get returns the current value of a reference. If the provider is unavailable,
+// the last known value is returned.
+func (r *SecretResolver) Get(value string) string {
+	resolved, err := r.Resolve(context.Background(), value)
+	if err != nil {
+		log.Errorf("unable to refresh secret %s: %s", redactRef(value), err)
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.cache[value].value
+	}
+	return resolved
+}
+
+// Source returns a function returning the current value of a reference, it is
+// given to the components that support credentials rotation
+func (r *SecretResolver) Source(value string) func() string {
+	return func() string {
+		return r.Get(value)
+	}
+}
+
+// OnChange registers a listener called when a refresh returns a new value for ref
+func (r *SecretResolver) OnChange(ref string, listener func(value string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners[ref] = append(r.listeners[ref], listener)
+}
+
+// Refresh resolves again every secret already resolved
+func (r *SecretResolver) Refresh(ctx context.Context) error {
+	r.mu.RLock()
+	refs := make([]string, 0, len(r.cache))
+	for ref := range r.cache {
+		refs = append(refs, ref)
+	}
+	r.mu.RUnlock()
+	var errs []string
+	for _, value := range refs {
+		provider, ref, ok := r.lookup(value)
+		if !ok {
+			continue
+		}
+		if _, err := r.fetch(ctx, value, provider, ref); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to refresh secrets: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Watch refreshes the secrets every TTL until ctx is done
+func (r *SecretResolver) Watch(ctx context.Context) {
+	ticker := time.NewTicker(r.TTL)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.Refresh(ctx); err != nil {
+					log.Error(err)
+				}
+			}
+		}
+	}()
+}
+
+func (r *SecretResolver) fetch(ctx context.Context, value string, provider SecretProvider, ref *url.URL) (string, error) {
+	resolved, err := provider.Resolve(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve secret %s: %w", redactRef(value), err)
+	}
+	r.mu.Lock()
+	previous, known := r.cache[value]
+	r.cache[value] = cachedSecret{value: resolved, at: time.Now()}
+	listeners := r.listeners[value]
+	r.mu.Unlock()
+	if known && previous.value != resolved {
+		log.Infof("secret %s rotated", redactRef(value))
+		for _, listener := range listeners {
+			listener(resolved)
+		}
+	}
+	return resolved, nil
+}
+
+// ResolveSecrets replaces the references found in the string fields of target
+// tagged with secret:"true" by their value
+func ResolveSecrets(ctx context.Context, r *SecretResolver, target any) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("secrets target must be a pointer to a struct, got %T", target)
+	}
+	return walkConfig(value.Elem(), "", func(field reflect.StructField, v reflect.Value, name string) error {
+		if field.Tag.Get("secret") != "true" || v.Kind() != reflect.String || !r.IsReference(v.String()) {
+			return nil
+		}
+		resolved, err := r.Resolve(ctx, v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		v.SetString(resolved)
+		return nil
+	})
+}
+
+func redactRef(value string) string {
+	if ref, err := url.Parse(value); err == nil {
+		return ref.Redacted()
+	}
+	return redacted
+}
+
+// ----------------------------------------------------------------------------------------------------------------------
+// PROVIDERS
+// ----------------------------------------------------------------------------------------------------------------------
+
+// FileSecretProvider reads secrets from files: file:///run/secrets/db. The sqlite urls are
+// not secrets, only an absolute path without query string nor database extension is read.
+type FileSecretProvider struct{}
+
+func (p FileSecretProvider) Accepts(ref *url.URL) bool {
+	if ref.Host != "" || !strings.HasPrefix(ref.Path, "/") || ref.RawQuery != "" || ref.ForceQuery {
+		return false
+	}
+	for _, ext := range []string{".db", ".sqlite", ".sqlite3"} {
+		if strings.HasSuffix(strings.ToLower(ref.Path), ext) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p FileSecretProvider) Resolve(_ context.Context, ref *url.URL) (string, error) {
+	data, err := os.ReadFile(ref.Path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// EnvSecretProvider reads secrets from other environment variables: env://DB_PASSWORD
+type EnvSecretProvider struct{}
+
+func (p EnvSecretProvider) Resolve(_ context.Context, ref *url.URL) (string, error) {
+	value, ok := os.LookupEnv(ref.Host)
+	if !ok {
+		return "", fmt.Errorf("env variable %s is not set", ref.Host)
+	}
+	return value, nil
+}
+
+// VaultSecretProvider reads secrets from a HashiCorp Vault KV v2 engine:
+// vault://<mount>/<path>#<key>, e.g. vault://secret/myapp/db#url
+type VaultSecretProvider struct {
+	Address   string
+	Token     string
+	Namespace string
+	Client    *http.Client
+}
+
+func NewVaultSecretProvider(address string, token string) *VaultSecretProvider {
+	return &VaultSecretProvider{
+		Address: strings.TrimSuffix(address, "/"),
+		Token:   token,
+		Client:  NewHttpClient(10 * time.Second),
+	}
+}
+
+func (p *VaultSecretProvider) Resolve(ctx context.Context, ref *url.URL) (string, error) {
+	mount := ref.Host
+	secretPath := strings.TrimPrefix(ref.Path, "/")
+	key := ref.Fragment
+	if mount == "" || secretPath == "" || key == "" {
+		return "", fmt.Errorf("invalid vault reference, expected vault://<mount>/<path>#<key>")
+	}
+	endpoint := fmt.Sprintf("%s/v1/%s/data/%s", p.Address, mount, secretPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	//goland:noinspection ALL
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %d for %s/%s", res.StatusCode, mount, secretPath)
+	}
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	value, ok := body.Data.Data[key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in %s/%s", key, mount, secretPath)
+	}
+	return fmt.Sprintf("%v", value), nil
+}
@@ -0,0 +1,82 @@
+package micro
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSecretResolver(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "db")
+	assert.Nil(t, os.WriteFile(file, []byte("postgres://user:v1@localhost/db\n"), 0600))
+	t.Setenv("TEST_SECRET_TOKEN", "s3cret")
+
+	r := NewSecretResolver(time.Hour)
+	r.RegisterProvider("file", FileSecretProvider{})
+	r.RegisterProvider("env", EnvSecretProvider{})
+	ctx := context.Background()
+
+	value, err := r.Resolve(ctx, "env://TEST_SECRET_TOKEN")
+	assert.Nil(t, err)
+	assert.Equal(t, "s3cret", value)
+
+	_, err = r.Resolve(ctx, "env://TEST_SECRET_MISSING")
+	assert.NotNil(t, err)
+
+	// plain values and sqlite urls are not references
+	value, _ = r.Resolve(ctx, "postgres://localhost/db")
+	assert.Equal(t, "postgres://localhost/db", value)
+	assert.False(t, r.IsReference("file://test.db?mode=memory"))
+	assert.False(t, r.IsReference("file:///var/lib/app/data.sqlite"))
+	assert.False(t, r.IsReference("file:///var/lib/app/data.db"))
+	assert.False(t, r.IsReference("file:///var/lib/app/data?cache=shared"))
+	assert.True(t, r.IsReference("file:///run/secrets/db"))
+
+	ref := "file://" + file
+	source := r.Source(ref)
+	assert.Equal(t, "postgres://user:v1@localhost/db", source())
+
+	var rotated string
+	r.OnChange(ref, func(value string) {
+		rotated = value
+	})
+	assert.Nil(t, os.WriteFile(file, []byte("postgres://user:v2@localhost/db"), 0600))
+	assert.Equal(t, "postgres://user:v1@localhost/db", source(), "cached until refreshed")
+	assert.Nil(t, r.Refresh(ctx))
+	assert.Equal(t, "postgres://user:v2@localhost/db", source())
+	assert.Equal(t, "postgres://user:v2@localhost/db", rotated)
+
+	// the last known value is kept when the provider fails
+	assert.Nil(t, os.Remove(file))
+	assert.NotNil(t, r.Refresh(ctx))
+	assert.Equal(t, "postgres://user:v2@localhost/db", source())
+}
+
+func TestVaultSecretProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "root" || req.URL.Path != "/v1/secret/data/app/db" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"url":"postgres://vault"}}}`))
+	}))
+	defer server.Close()
+
+	r := NewSecretResolver(0)
+	r.RegisterProvider("vault", NewVaultSecretProvider(server.URL, "root"))
+
+	value, err := r.Resolve(context.Background(), "vault://secret/app/db#url")
+	assert.Nil(t, err)
+	assert.Equal(t, "postgres://vault", value)
+
+	_, err = r.Resolve(context.Background(), "vault://secret/app/db#missing")
+	assert.NotNil(t, err)
+	_, err = r.Resolve(context.Background(), "vault://secret/other#url")
+	assert.NotNil(t, err)
+}
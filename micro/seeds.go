@@ -0,0 +1,318 @@
+package micro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultSeedsTable = "z_seeds"
+
+// SeedCommandName is the App subcommand running the seeders (./svc seed)
+const SeedCommandName = "seed"
+
+// Seeder inserts data in the datasource of a tenant, it runs once per tenant and per
+// environment in a transaction (ctx.CurrentDB() is bound to it)
+type Seeder struct {
+	// Name identifies the seeder in the seeds table
+	Name string
+	// Location selects the seeded tenants like the migrations folders: shared and tenant
+	// in multi-tenant mode, "." otherwise
+	Location string
+	// Envs restricts the environments (GO_ENV) where the seeder runs, all when empty
+	Envs []string
+	Run  func(ctx Ctx) error
+}
+
+// Fixture is a table and the rows inserted in it
+type Fixture struct {
+	Table string           `json:"table" yaml:"table"`
+	Rows  []map[string]any `json:"rows" yaml:"rows"`
+}
+
+// SeedReport is the result of the seeders of a tenant
+type SeedReport struct {
+	Tenant  string
+	Applied []string
+	Skipped []string
+	Err     error
+}
+
+// SeedPlan returns the seeders of a tenant, in the order they run
+type SeedPlan func(tenant string) []Seeder
+
+// Seeds runs the seeders of the tenants and records them in Table:
+//
+//	CREATE TABLE z_seeds (
+//	  tenant      VARCHAR(255) NOT NULL,
+//	  name        VARCHAR(255) NOT NULL,
+//	  environment VARCHAR(64) NOT NULL,
+//	  applied_at  TIMESTAMP NOT NULL,
+//	  PRIMARY KEY (tenant, name, environment)
+//	);
+//
+// The table is created in each datasource when missing.
+type Seeds struct {
+	Table       string
+	Environment string
+	Concurrency int
+	env         *Env
+	plan        SeedPlan
+}
+
+func NewSeeds(env *Env, plan SeedPlan, table string, concurrency int) *Seeds {
+	if table == "" {
+		table = DefaultSeedsTable
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultMigrationConcurrency
+	}
+	return &Seeds{Table: table, Environment: env.Environment, Concurrency: concurrency, env: env, plan: plan}
+}
+
+// Run executes the seeders not yet applied on the given tenants (all the datasources when
+// empty). A failure stops the seeders of its tenant only.
+func (s *Seeds) Run(ctx context.Context, tenants ...string) []SeedReport {
+	if len(tenants) == 0 {
+		for tenant := range s.env.DataSources {
+			tenants = append(tenants, tenant)
+		}
+	}
+	sort.Strings(tenants)
+	reports := make([]SeedReport, len(tenants))
+	slots := make(chan struct{}, s.Concurrency)
+	var wg sync.WaitGroup
+	for i, tenant := range tenants {
+		wg.Add(1)
+		go func(i int, tenant string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() {
+				<-slots
+			}()
+			reports[i] = s.seed(NewCtx(s.env, tenant).WithContext(ctx))
+		}(i, tenant)
+	}
+	wg.Wait()
+	return reports
+}
+
+type seedRow struct {
+	Name string
+}
+
+func (s *Seeds) seed(ctx Ctx) SeedReport {
+	report := SeedReport{Tenant: ctx.TenantId}
+	db := ctx.CurrentDB()
+	if db == nil {
+		report.Err = fmt.Errorf("unknown tenant: %s", ctx.TenantId)
+		return report
+	}
+	var seeders []Seeder
+	for _, seeder := range s.plan(ctx.TenantId) {
+		if len(seeder.Envs) == 0 || containsString(seeder.Envs, s.Environment) {
+			seeders = append(seeders, seeder)
+		}
+	}
+	if len(seeders) == 0 {
+		return report
+	}
+	_, err := db.Raw(Query{Raw: s.createTable(db.Dialect())})
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	var rows []seedRow
+	err = db.Find(&rows, Query{
+		Raw:  fmt.Sprintf("SELECT name FROM %s WHERE tenant = ? AND environment = ?", s.Table),
+		Args: []any{ctx.TenantId, s.Environment},
+	})
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	applied := map[string]bool{}
+	for _, row := range rows {
+		applied[row.Name] = true
+	}
+	for _, seeder := range seeders {
+		if applied[seeder.Name] {
+			report.Skipped = append(report.Skipped, seeder.Name)
+			continue
+		}
+		err = ctx.Tx(func(tx Ctx) error {
+			if err := seeder.Run(tx); err != nil {
+				return err
+			}
+			_, err := tx.CurrentDB().Raw(Query{
+				Raw:  fmt.Sprintf("INSERT INTO %s (tenant, name, environment, applied_at) VALUES (?, ?, ?, ?)", s.Table),
+				Args: []any{ctx.TenantId, seeder.Name, s.Environment, time.Now().UTC()},
+			})
+			return err
+		})
+		if err != nil {
+			report.Err = fmt.Errorf("seeder %s failed: %w", seeder.Name, err)
+			return report
+		}
+		report.Applied = append(report.Applied, seeder.Name)
+	}
+	return report
+}
+
+func (s *Seeds) createTable(dialect string) string {
+	columns := "(tenant VARCHAR(255) NOT NULL, name VARCHAR(255) NOT NULL, environment VARCHAR(64) NOT NULL, " +
+		"applied_at %s NOT NULL, PRIMARY KEY (tenant, name, environment))"
+	if dialect == DialectSQLServer {
+		// TIMESTAMP is a row version on SQL Server, which has no CREATE TABLE IF NOT EXISTS
+		return fmt.Sprintf("IF OBJECT_ID(N'%s', N'U') IS NULL CREATE TABLE %s "+columns, s.Table, s.Table, "DATETIME2")
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s "+columns, s.Table, "TIMESTAMP")
+}
+
+// SeedErrors returns the failures of the reports, nil when every seeder succeeded
+func SeedErrors(reports []SeedReport) error {
+	var errs []string
+	for _, report := range reports {
+		if report.Err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", report.Tenant, report.Err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("seeding failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// LoadFixtures returns a Seeder for each fixture file (json, yaml or yml) of the location
+// folder of fsys. A file named NAME.ENV.json only runs in the environment ENV. The rows are
+// inserted with the seeded tenant in tenantColumn when given (see FixtureSeeder).
+//
+//	# db/seeds/tenant/accounts.yaml
+//	- table: accounts
+//	  rows:
+//	    - {id: a1, name: Acme}
+func LoadFixtures(fsys fs.FS, location string, tenantColumn ...string) ([]Seeder, error) {
+	entries, err := fs.ReadDir(fsys, location)
+	if err != nil {
+		return nil, err
+	}
+	var seeders []Seeder
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".json" && ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(location, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var fixtures []Fixture
+		if ext == ".json" {
+			err = json.Unmarshal(data, &fixtures)
+		} else {
+			err = yaml.Unmarshal(data, &fixtures)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixture %s: %w", entry.Name(), err)
+		}
+		name := strings.TrimSuffix(entry.Name(), ext)
+		seeder := Seeder{Name: name, Location: location, Run: FixtureSeeder(fixtures, tenantColumn...)}
+		if parts := strings.SplitN(name, ".", 2); len(parts) == 2 {
+			seeder.Envs = []string{parts[1]}
+		}
+		seeders = append(seeders, seeder)
+	}
+	return seeders, nil
+}
+
+// FixtureSeeder returns a seeder function inserting the rows of the fixtures. With
+// tenantColumn (TenantColumn for the tables shared by the tenants, see TenancyRow), the
+// rows without this column are inserted with the tenant of ctx.
+func FixtureSeeder(fixtures []Fixture, tenantColumn ...string) func(ctx Ctx) error {
+	return func(ctx Ctx) error {
+		for _, fixture := range fixtures {
+			if !columnPattern.MatchString(fixture.Table) {
+				return fmt.Errorf("invalid table name: %q", fixture.Table)
+			}
+			for _, row := range fixture.Rows {
+				if len(tenantColumn) > 0 {
+					if _, ok := row[tenantColumn[0]]; !ok {
+						scoped := make(map[string]any, len(row)+1)
+						for column, value := range row {
+							scoped[column] = value
+						}
+						scoped[tenantColumn[0]] = ctx.TenantId
+						row = scoped
+					}
+				}
+				columns := make([]string, 0, len(row))
+				for column := range row {
+					if !columnPattern.MatchString(column) {
+						return fmt.Errorf("invalid column name: %q", column)
+					}
+					columns = append(columns, column)
+				}
+				sort.Strings(columns)
+				args := make([]any, len(columns))
+				for i, column := range columns {
+					args[i] = row[column]
+				}
+				placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+				_, err := ctx.CurrentDB().Raw(Query{
+					Raw:  fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", fixture.Table, strings.Join(columns, ", "), placeholders),
+					Args: args,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// SeedersOf returns the seeders of a location
+func SeedersOf(seeders []Seeder, location string) []Seeder {
+	var result []Seeder
+	for _, seeder := range seeders {
+		if seeder.Location == location {
+			result = append(result, seeder)
+		}
+	}
+	return result
+}
+
+// RunSeedCommand runs the seed subcommand (seed [--tenant=t1,t2]) and prints the reports in out
+func (app *App) RunSeedCommand(ctx context.Context, args []string, out io.Writer) error {
+	if app.Env.Seeds == nil {
+		return fmt.Errorf("no seeders configured")
+	}
+	var tenants []string
+	for i := 0; i < len(args); i++ {
+		value := strings.TrimPrefix(args[i], "--tenant=")
+		if args[i] == "--tenant" && i+1 < len(args) {
+			i++
+			value = args[i]
+		} else if value == args[i] {
+			return fmt.Errorf("unexpected argument: %s", args[i])
+		}
+		tenants = append(tenants, strings.Split(value, ",")...)
+	}
+	reports := app.Env.Seeds.Run(ctx, tenants...)
+	for _, report := range reports {
+		_, _ = fmt.Fprintf(out, "[%s] applied: %s, skipped: %s\n", report.Tenant,
+			strings.Join(report.Applied, ", "), strings.Join(report.Skipped, ", "))
+		if report.Err != nil {
+			_, _ = fmt.Fprintf(out, "  error: %s\n", report.Err)
+		}
+	}
+	return SeedErrors(reports)
+}
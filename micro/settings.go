@@ -0,0 +1,42 @@
+package micro
+
+import "time"
+
+// Settings is the framework configuration, read from the environment (and .env) by adapters.NewApp.
+// Secret values may be references (file://, env://, vault://) resolved through Env.Secrets.
+// The flags read before the typed configuration (INSECURE_JWT_DEV, CORS_DISABLED) are lenient:
+// a value other than a bool (e.g. "yes") is ignored, as it used to be.
+type Settings struct {
+	Port                   int           `env:"PORT" default:"8080"`
+	DatabaseUrl            string        `env:"DATABASE_URL" secret:"true"`
+	DatabaseInitialTenants []string      `env:"DATABASE_INITIAL_TENANTS" secret:"true"`
+	DatabaseReplicaUrls    []string      `env:"DATABASE_REPLICA_URLS" secret:"true"`
+	DatabaseMaxOpenConns   int           `env:"DATABASE_MAX_OPEN_CONNS"`
+	DatabaseMaxIdleConns   int           `env:"DATABASE_MAX_IDLE_CONNS"`
+	DatabaseConnLifetime   time.Duration `env:"DATABASE_CONN_MAX_LIFETIME"`
+	DatabaseConnIdleTime   time.Duration `env:"DATABASE_CONN_MAX_IDLE_TIME"`
+	DatabaseSharedPool     bool          `env:"DATABASE_SHARED_POOL"`
+	MigrationConcurrency   int           `env:"DATABASE_MIGRATION_CONCURRENCY" default:"4"`
+	InsecureJwtDev         bool          `env:"INSECURE_JWT_DEV" lenient:"true"`
+	ServerToken            string        `env:"SERVER_TOKEN" secret:"true"`
+	EmailSender            string        `env:"EMAIL_SENDER,MAILER" secret:"true"`
+	NotificationSender     string        `env:"NOTIFICATION_SENDER" secret:"true"`
+	RedisUrl               string        `env:"REDIS_URL" secret:"true"`
+	SentryDsn              string        `env:"SENTRY_DSN" secret:"true"`
+	CorsDisabled           bool          `env:"CORS_DISABLED" lenient:"true"`
+	MetricsEnabled         bool          `env:"METRICS_ENABLED"`
+	TracesExporter         string        `env:"OTEL_TRACES_EXPORTER"`
+	LogLevel               string        `env:"LOG_LEVEL"`
+	LogFormat              string        `env:"LOG_FORMAT"`
+	ShutdownTimeout        time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	ShutdownDelay          time.Duration `env:"SHUTDOWN_DELAY"`
+	PrivateDomain          string        `env:"APP_PRIVATE_DOMAIN,APP_DOMAIN,RAILWAY_PRIVATE_DOMAIN,RAILWAY_PUBLIC_DOMAIN"`
+	VaultAddr              string        `env:"VAULT_ADDR"`
+	VaultToken             string        `env:"VAULT_TOKEN" secret:"true"`
+	VaultNamespace         string        `env:"VAULT_NAMESPACE"`
+	SecretsTTL             time.Duration `env:"SECRETS_TTL" default:"5m"`
+	FlagSources            []string      `env:"FEATURE_FLAGS_SOURCES"`
+	FlagsRefreshInterval   time.Duration `env:"FEATURE_FLAGS_REFRESH" default:"30s"`
+	AuditEnabled           bool          `env:"AUDIT_ENABLED"`
+	AuditRedact            []string      `env:"AUDIT_REDACT"`
+}
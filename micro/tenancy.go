@@ -0,0 +1,112 @@
+package micro
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TenancyStrategy is how the data of the tenants is isolated
+type TenancyStrategy string
+
+const (
+	// TenancySchema stores each tenant in its own schema (postgres search_path), the default
+	TenancySchema TenancyStrategy = "schema"
+	// TenancyDatabase stores each tenant in its own database, see TenantRegistry
+	TenancyDatabase TenancyStrategy = "database"
+	// TenancyRow stores the tenants in shared tables, the rows are scoped by TenantColumn
+	TenancyRow TenancyStrategy = "row"
+)
+
+// TenantColumn is the column holding the tenant of a row with TenancyRow
+const TenantColumn = "tenant_id"
+
+// TenantSetting is the postgres setting holding the current tenant, read by the
+// row level security policies (see EnableRowLevelSecurity)
+const TenantSetting = "app.tenant_id"
+
+// TenantUrlPlaceholder is replaced by the tenant in the database url
+const TenantUrlPlaceholder = "__tenant__"
+
+// TenantOwned is embedded by the entities stored in shared tables (TenancyRow), the
+// datasource fills TenantId on insert and adds it to the conditions of every statement.
+type TenantOwned struct {
+	TenantId string `json:"tenant_id" gorm:"index"`
+}
+
+// TenantRegistry is a TenantLoader knowing the database url of each tenant (TenancyDatabase)
+type TenantRegistry interface {
+	TenantLoader
+	TenantUrl(tenant string) (string, error)
+}
+
+// StaticTenantRegistry is a TenantRegistry built from a fixed list of tenants, the
+// tenants without url use the url template (with TenantUrlPlaceholder). Without template,
+// their url is empty and the datasource uses DATABASE_URL as template.
+type StaticTenantRegistry struct {
+	urls     map[string]string
+	tenants  []string
+	template string
+}
+
+// NewStaticTenantRegistry parses entries in the form "tenant" or "tenant|url"
+// (the format of DATABASE_INITIAL_TENANTS)
+func NewStaticTenantRegistry(template string, entries ...string) *StaticTenantRegistry {
+	registry := &StaticTenantRegistry{urls: map[string]string{}, template: template}
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "|", 2)
+		registry.tenants = append(registry.tenants, parts[0])
+		if len(parts) == 2 && parts[1] != "" {
+			registry.urls[parts[0]] = parts[1]
+		}
+	}
+	return registry
+}
+
+func (r *StaticTenantRegistry) GetTenant() []string {
+	return r.tenants
+}
+
+func (r *StaticTenantRegistry) TenantUrl(tenant string) (string, error) {
+	if url, ok := r.urls[tenant]; ok {
+		return url, nil
+	}
+	if r.template == "" {
+		return "", nil
+	}
+	if !strings.Contains(r.template, TenantUrlPlaceholder) {
+		return "", fmt.Errorf("no database url for tenant %s (the url has no %s placeholder)", tenant, TenantUrlPlaceholder)
+	}
+	return strings.ReplaceAll(r.template, TenantUrlPlaceholder, tenant), nil
+}
+
+// EnableRowLevelSecurity creates on each table a postgres policy restricting the rows to
+// the tenant of the connection (TenantSetting), it can be used in a migration:
+//
+//	ALTER TABLE orders ENABLE ROW LEVEL SECURITY;
+//	ALTER TABLE orders FORCE ROW LEVEL SECURITY;
+//	CREATE POLICY tenant_isolation ON orders
+//	  USING (tenant_id = current_setting('app.tenant_id', true))
+//	  WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+func EnableRowLevelSecurity(db DataSource, tables ...string) error {
+	if !db.IsPostgres() {
+		return fmt.Errorf("row level security requires postgres")
+	}
+	condition := fmt.Sprintf("%s = current_setting('%s', true)", TenantColumn, TenantSetting)
+	for _, table := range tables {
+		if !columnPattern.MatchString(table) {
+			return fmt.Errorf("invalid table name: %q", table)
+		}
+		statements := []string{
+			fmt.Sprintf("ALTER TABLE %s ENABLE ROW LEVEL SECURITY", table),
+			fmt.Sprintf("ALTER TABLE %s FORCE ROW LEVEL SECURITY", table),
+			fmt.Sprintf("DROP POLICY IF EXISTS tenant_isolation ON %s", table),
+			fmt.Sprintf("CREATE POLICY tenant_isolation ON %s USING (%s) WITH CHECK (%s)", table, condition, condition),
+		}
+		for _, statement := range statements {
+			if _, err := db.Raw(Query{Raw: statement}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
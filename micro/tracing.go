@@ -0,0 +1,113 @@
+package micro
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"time"
+)
+
+const TracerName = "github.com/soffa-projects/go-micro"
+
+const (
+	TraceExporterOtlp = "otlp"
+	TraceExporterNone = "none"
+)
+
+// Tracer returns the tracer used by the framework. It is a noop tracer until a
+// tracer provider is installed (see adapters.NewApp)
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// StartSpan starts a new span as a child of the span carried by ctx (if any) and
+// returns a Ctx carrying the new span.
+func (ctx Ctx) StartSpan(name string, opts ...trace.SpanStartOption) (Ctx, trace.Span) {
+	spanCtx, span := Tracer().Start(ctx.Context(), name, opts...)
+	return ctx.WithContext(spanCtx), span
+}
+
+// TraceId returns the id of the current trace, or an empty string if the request is not traced
+func (ctx Ctx) TraceId() string {
+	sc := trace.SpanContextFromContext(ctx.Context())
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// InjectTraceHeaders propagates the trace context of ctx to an outgoing request (W3C traceparent)
+func InjectTraceHeaders(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractTraceHeaders returns a context carrying the remote trace context found in header
+func ExtractTraceHeaders(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+// NewTracingTransport wraps an http.RoundTripper so that every outgoing request
+// gets a client span and the W3C trace context headers.
+func NewTracingTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tracingTransport{base: base}
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Tracer().Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(req.URL.String()),
+			semconv.ServerAddress(req.URL.Hostname()),
+		),
+	)
+	defer span.End()
+	req = req.Clone(ctx)
+	InjectTraceHeaders(ctx, req.Header)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// NewHttpClient returns an http client propagating the trace context
+func NewHttpClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: NewTracingTransport(http.DefaultTransport),
+	}
+}
+
+func (e *Event) injectTraceContext(ctx context.Context) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+	e.TraceContext = carrier
+}
+
+func (e *Event) extractTraceContext(ctx context.Context) context.Context {
+	if len(e.TraceContext) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(e.TraceContext))
+}
@@ -0,0 +1,257 @@
+package micro
+
+import (
+	"context"
+	"database/sql"
+	"github.com/labstack/echo/v4"
+	"math/rand"
+	"time"
+)
+
+const (
+	DefaultTxBackoff    = 20 * time.Millisecond
+	DefaultTxMaxBackoff = time.Second
+)
+
+// TxOptionsKey is the key of the TxOptions of the implicit transaction of a route (see WithTx)
+const TxOptionsKey = "tx_options"
+
+// TxModeKey is the key of the TxMode of a route, the request context holds the mode used
+// once the handler ran
+const TxModeKey = "tx_mode"
+
+// TxMode is the implicit transaction of a route
+type TxMode string
+
+const (
+	// TxModeNone runs the handler without transaction, the default of GET and HEAD routes
+	TxModeNone TxMode = "none"
+	// TxModeReadOnly runs the handler in a read-only transaction
+	TxModeReadOnly TxMode = "read_only"
+	// TxModeReadWrite runs the handler in a transaction, the default of the other routes
+	TxModeReadWrite TxMode = "read_write"
+)
+
+// TxOptions configures a transaction (see Ctx.Tx), the zero value uses the defaults of the
+// database and never retries
+type TxOptions struct {
+	// Isolation is the isolation level, the database default when sql.LevelDefault
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// Retries is the number of times the transaction is run again after a retryable error
+	// (see IsRetryable), none when 0. Only enable it for transactions without side effects
+	// outside of the database.
+	Retries int
+	// Backoff is the delay before the first retry, doubled at each retry (with a random
+	// jitter) up to DefaultTxMaxBackoff, DefaultTxBackoff when 0
+	Backoff time.Duration
+	// Timeout bounds the duration of each attempt, none when 0
+	Timeout time.Duration
+}
+
+// WithTx returns a route (or group) filter running the handlers in a transaction with the
+// given options, read-only when opts.ReadOnly is set
+func WithTx(opts TxOptions) MiddlewareFunc {
+	mode := TxModeReadWrite
+	if opts.ReadOnly {
+		mode = TxModeReadOnly
+	}
+	return func(ctx Ctx) error {
+		if c, ok := ctx.Wrapped.(echo.Context); ok {
+			c.Set(TxModeKey, mode)
+			c.Set(TxOptionsKey, opts)
+		}
+		return nil
+	}
+}
+
+// Tx returns a route (or group) filter running the handlers in a transaction with the given
+// isolation level (sql.LevelDefault for the database default)
+func Tx(isolation sql.IsolationLevel) MiddlewareFunc {
+	return WithTx(TxOptions{Isolation: isolation})
+}
+
+// ReadOnlyTx returns a route (or group) filter running the handlers in a read-only transaction,
+// a read-write one on SQL Server whose driver doesn't support them
+func ReadOnlyTx() MiddlewareFunc {
+	return WithTx(TxOptions{ReadOnly: true})
+}
+
+// NoTx returns a route (or group) filter running the handlers without transaction, their
+// reads may then be sent to the replicas
+func NoTx() MiddlewareFunc {
+	return func(ctx Ctx) error {
+		if c, ok := ctx.Wrapped.(echo.Context); ok {
+			c.Set(TxModeKey, TxModeNone)
+		}
+		return nil
+	}
+}
+
+func txOptionsOf(opts []TxOptions) TxOptions {
+	var options TxOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = DefaultTxBackoff
+	}
+	return options
+}
+
+// backoff is the delay before the retry following attempt (0 for the first one), between
+// half and all of the exponential delay
+func (o TxOptions) backoff(attempt int) time.Duration {
+	delay := o.Backoff << attempt
+	if delay <= 0 || delay > DefaultTxMaxBackoff {
+		delay = DefaultTxMaxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Tx runs cb in a transaction of the datasource of the tenant, committed when cb returns
+// nil and rolled back otherwise. With TxOptions.Retries, a transaction failing with a
+// retryable error (deadlock, serialization failure), returned or raised with a panic, is
+// run again, cb must then have no side effect outside of the database. Inside a transaction,
+// cb runs in a savepoint rolled back when cb fails, the options and the retries are the ones
+// of the outer transaction.
+func (ctx Ctx) Tx(cb func(tx Ctx) error, opts ...TxOptions) error {
+	db := ctx.db
+	if db == nil {
+		db = ctx.Env.DataSources[ctx.TenantId]
+	}
+	if db == nil {
+		log.Warn("no db found in current context (skipping global transaction)")
+		return cb(Ctx{
+			TenantId: ctx.TenantId,
+			Auth:     ctx.Auth,
+			Env:      ctx.Env,
+			Wrapped:  ctx.Wrapped,
+			stdCtx:   ctx.stdCtx,
+		})
+	}
+	if ctx.inTx {
+		return db.WithContext(ctx.dbContext()).Transaction(func(tx DataSource) error {
+			return cb(ctx.withTx(tx))
+		})
+	}
+	options := txOptionsOf(opts)
+	for attempt := 0; ; attempt++ {
+		current := &txAttempt{}
+		err := ctx.runTx(db, options, current, cb)
+		if err == nil || !IsRetryable(err) || attempt >= options.Retries {
+			return current.done(err)
+		}
+		delay := options.backoff(attempt)
+		ctx.LogWith(log).Warnf("transaction failed, retry %d/%d in %s -- %v", attempt+1, options.Retries, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Context().Done():
+			return current.done(err)
+		}
+	}
+}
+
+type txAttemptKey struct{}
+
+// txAttempt holds the AfterRollback hooks of an attempt of Ctx.Tx failing with a retryable
+// error and the error raised by the attempt with a panic, they are only run (or raised again)
+// when the attempt is the last one
+type txAttempt struct {
+	rollback func()
+	raised   error
+}
+
+// done ends the last attempt of Ctx.Tx
+func (a *txAttempt) done(err error) error {
+	if a.rollback != nil {
+		a.rollback()
+	}
+	if a.raised != nil {
+		panic(a.raised)
+	}
+	return err
+}
+
+// DeferRollbackHooks is called by the datasources when a transaction fails with a retryable
+// error. It returns true when the transaction is an attempt of Ctx.Tx, run is then called
+// once Ctx.Tx knows that the attempt is not retried.
+func DeferRollbackHooks(ctx context.Context, run func()) bool {
+	if ctx == nil {
+		return false
+	}
+	if attempt, ok := ctx.Value(txAttemptKey{}).(*txAttempt); ok {
+		attempt.rollback = run
+		return true
+	}
+	return false
+}
+
+// AfterCommit registers fn to run once the transaction of ctx is committed (e.g. to send an
+// email or publish an event), it runs at once outside of a transaction. The hooks registered
+// in a savepoint are dropped when the savepoint is rolled back, and in an attempt of Tx when
+// the transaction is retried.
+func (ctx Ctx) AfterCommit(fn func()) {
+	if hooks, ok := ctx.db.(TxHooks); ok && ctx.inTx {
+		hooks.AfterCommit(fn)
+		return
+	}
+	fn()
+}
+
+// AfterRollback registers fn to run once the transaction of ctx (or its savepoint) is rolled
+// back, it never runs outside of a transaction. The hooks of an attempt of Tx only run when
+// the attempt is not retried.
+func (ctx Ctx) AfterRollback(fn func()) {
+	if hooks, ok := ctx.db.(TxHooks); ok && ctx.inTx {
+		hooks.AfterRollback(fn)
+	}
+}
+
+func (ctx Ctx) runTx(db DataSource, options TxOptions, attempt *txAttempt, cb func(tx Ctx) error) (err error) {
+	// the handlers raise their errors with a panic (h.RaiseAny), they are retried like the
+	// returned ones and raised again once the transaction is not retried
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			raised, ok := recovered.(error)
+			if !ok {
+				panic(recovered)
+			}
+			attempt.raised = raised
+			err = raised
+		}
+	}()
+	if options.Timeout > 0 {
+		timeoutCtx, cancel := context.WithTimeout(ctx.Context(), options.Timeout)
+		defer cancel()
+		ctx = ctx.WithContext(timeoutCtx)
+	}
+	dbCtx := context.WithValue(ctx.dbContext(), txAttemptKey{}, attempt)
+	return db.WithContext(dbCtx).Transaction(func(tx DataSource) error {
+		return cb(ctx.withTx(tx))
+	}, options)
+}
+
+// withoutTx returns ctx on the datasource of its tenant when it runs in a transaction
+func (ctx Ctx) withoutTx() Ctx {
+	if !ctx.inTx {
+		return ctx
+	}
+	ctx.db, ctx.inTx = nil, false
+	if ctx.Env != nil {
+		ctx.db = ctx.Env.DataSources[ctx.TenantId]
+	}
+	return ctx
+}
+
+func (ctx Ctx) withTx(tx DataSource) Ctx {
+	return Ctx{
+		TenantId: ctx.TenantId,
+		Auth:     ctx.Auth,
+		db:       tx,
+		Env:      ctx.Env,
+		Wrapped:  ctx.Wrapped,
+		stdCtx:   ctx.stdCtx,
+		inTx:     true,
+	}
+}
@@ -36,6 +36,16 @@ func AuthenticatedWithRole(roles ...string) micro.MiddlewareFunc {
 	}
 }
 
+// FeatureFlag returns a middleware that hides the route when the flag is disabled for the current tenant and user.
+func FeatureFlag(name string) micro.MiddlewareFunc {
+	return func(ctx micro.Ctx) error {
+		if !ctx.FlagEnabled(name) {
+			return errors.ResourceNotFound("not_found")
+		}
+		return nil
+	}
+}
+
 func TenantRequired() micro.MiddlewareFunc {
 	return func(ctx micro.Ctx) error {
 		if h.IsStrEmpty(ctx.TenantId) || ctx.IsDefaultTenant() {
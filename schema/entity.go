@@ -23,3 +23,10 @@ type PagingInput struct {
 	Sort  string `json:"sort" query:"sort"`
 	Count int    `json:"count" query:"count"`
 }
+
+type AuditHistoryInput struct {
+	Entity string `param:"entity" json:"entity"`
+	Id     string `param:"id" json:"id" validate:"required"`
+	Page   int    `json:"page" query:"page"`
+	Count  int    `json:"count" query:"count"`
+}
@@ -1,10 +1,29 @@
 package tests
 
 import (
+	"context"
 	"github.com/soffa-projects/go-micro/micro"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"os"
 )
 
 func UseInMemoryDatabase() {
 	_ = os.Setenv(micro.DatabaseUrl, "file:__tenant__?mode=memory&cache=shared")
 }
+
+// UseInMemoryTracing configures cfg to record the spans in memory, the returned
+// exporter can be used to assert on the spans produced by a test
+func UseInMemoryTracing(cfg *micro.Cfg) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	cfg.TraceExporter = exporter
+	return exporter
+}
+
+// Seed runs the seeders of the tenants (all when empty) not yet applied, the first
+// failure is returned
+func Seed(app *micro.App, tenants ...string) error {
+	if app.Env.Seeds == nil {
+		return nil
+	}
+	return micro.SeedErrors(app.Env.Seeds.Run(context.Background(), tenants...))
+}
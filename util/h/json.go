@@ -7,6 +7,7 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"time"
 )
 
 func ToJsonStringPtr(input interface{}) (*string, error) {
@@ -75,6 +76,88 @@ func Diff(original, updated interface{}) map[string]interface{} {
 	return diff
 }
 
+// Change is the previous and the new value of a field
+type Change struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Changes returns the fields (snake cased, embedded structs flattened) whose value differs
+// between original and updated. original or updated can be nil for a creation or a deletion.
+func Changes(original, updated interface{}) map[string]Change {
+	before := fieldValues(original)
+	after := fieldValues(updated)
+	changes := make(map[string]Change)
+	for name, to := range after {
+		from, ok := before[name]
+		if !ok && isZeroValue(to) {
+			continue
+		}
+		if !ok || !sameValue(from, to) {
+			changes[name] = Change{From: from, To: to}
+		}
+	}
+	for name, from := range before {
+		if _, ok := after[name]; !ok && !isZeroValue(from) {
+			changes[name] = Change{From: from}
+		}
+	}
+	return changes
+}
+
+func fieldValues(input interface{}) map[string]any {
+	values := map[string]any{}
+	if input == nil {
+		return values
+	}
+	value := reflect.ValueOf(input)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return values
+		}
+		value = value.Elem()
+	}
+	if value.Kind() == reflect.Struct {
+		collectFieldValues(value, values)
+	}
+	return values
+}
+
+func collectFieldValues(value reflect.Value, values map[string]any) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldValue := value.Field(i)
+		if field.Anonymous && fieldValue.Kind() == reflect.Struct {
+			collectFieldValues(fieldValue, values)
+			continue
+		}
+		if fieldValue.Kind() == reflect.Ptr {
+			if fieldValue.IsNil() {
+				values[ToSnakeCase(field.Name)] = nil
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+		}
+		values[ToSnakeCase(field.Name)] = fieldValue.Interface()
+	}
+}
+
+func isZeroValue(value any) bool {
+	return value == nil || reflect.ValueOf(value).IsZero()
+}
+
+func sameValue(a, b any) bool {
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			return at.Equal(bt)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
 func DeserializeJson(input string, out interface{}) error {
 	return json.Unmarshal([]byte(input), out)
 }